@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"IPFS_CHAT4/control"
+	"IPFS_CHAT4/lineedit"
+	"IPFS_CHAT4/node"
+)
+
+// attachSession is an interactive chat on a daemon's node.
+type attachSession struct {
+	c        *control.Client
+	ed       *lineedit.Editor
+	commands *node.Commands
+
+	mu      sync.Mutex
+	rooms   []string
+	current string
+	detach  bool
+}
+
+// runAttach chats through a running daemon. Detaching with /exit or Ctrl-D
+// leaves the daemon in every room.
+func runAttach(args []string) {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	socket := fs.String("socket", defaultSocket(), "control socket path")
+	cfg, err := node.LoadConfig(GetConfigFile())
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Chat.BindOutputFlags(fs)
+	fs.Parse(args)
+	if err := cfg.Chat.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupOutput(cfg.Chat); err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := control.Dial(*socket)
+	if err != nil {
+		log.Fatalf("no daemon on %s, start one with: IPFS_CHAT4 daemon (%v)", *socket, err)
+	}
+	defer c.Close()
+
+	ed, err := lineedit.New(os.Stdin, os.Stdout, filepath.Join(GetProfileDir(), lineedit.HistoryFile))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ed.Close()
+	ed.Plain = plain
+
+	st, err := c.Status()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &attachSession{c: c, ed: ed, commands: node.NewCommands()}
+	s.registerCommands()
+	ed.Complete = s.commands.Complete
+	for _, r := range st.Rooms {
+		if err := c.Subscribe(r.Name); err != nil {
+			log.Fatal(err)
+		}
+		s.rooms = append(s.rooms, r.Name)
+	}
+	if len(s.rooms) > 0 {
+		s.current = s.rooms[0]
+	}
+	go s.printMessages()
+
+	printInfo("Attached to %s as %s on the %s network.", st.ID, st.Nick, st.Network)
+	if s.current != "" {
+		printInfo("Talking in %s. Type /help for commands, /exit or Ctrl-D to detach.", s.current)
+	} else {
+		printInfo("Not in a room yet, /join one. /exit or Ctrl-D detaches.")
+	}
+
+	for {
+		text, err := ed.ReadLine("> ")
+		if err == lineedit.ErrInterrupt {
+			continue
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal(err)
+		}
+
+		if node.IsCommand(text) {
+			if err := s.commands.Run(text); err != nil {
+				printError(err)
+			}
+			if s.detaching() {
+				break
+			}
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		room := s.room()
+		if room == "" {
+			printError(errors.New("not in a room, /join one first"))
+		} else if err := c.Publish(room, strings.TrimPrefix(text, "/"), false); err != nil {
+			printError(fmt.Errorf("sending message: %w", err))
+		}
+	}
+	s.mu.Lock()
+	s.detach = true
+	s.mu.Unlock()
+	printInfo("Detached, the daemon stays in its rooms.")
+}
+
+func (s *attachSession) room() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+func (s *attachSession) detaching() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.detach
+}
+
+// printMessages shows what the daemon passes on, ours included, since other
+// clients may be talking through the same node.
+func (s *attachSession) printMessages() {
+	for m := range s.c.Messages() {
+		s.mu.Lock()
+		tagged := len(s.rooms) > 1
+		s.mu.Unlock()
+		s.ed.Printf("%s", formatMessage(m.Room, m.Nick, m.Text, m.Kind, m.Self, tagged))
+	}
+	if !s.detaching() {
+		s.ed.Printf("The daemon went away.\n")
+	}
+}
+
+func (s *attachSession) completeRooms(_ int, prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, r := range s.rooms {
+		if strings.HasPrefix(r, prefix) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (s *attachSession) registerCommands() {
+	c := s.commands
+	c.Register(node.Command{
+		Name: "help", Help: "list commands",
+		Run: func([]string) error {
+			printInfo("Commands (start a message with // to send a leading slash):")
+			for _, cmd := range c.List() {
+				printInfo("  %-28s %s", cmd.Usage(), cmd.Help)
+			}
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "join", Args: "<room>", Help: "make the daemon join a room, or switch to one it is in",
+		MinArgs: 1, MaxArgs: 1, Complete: s.completeRooms,
+		Run: func(args []string) error {
+			if err := s.c.Join(args[0]); err != nil {
+				return err
+			}
+			if err := s.c.Subscribe(args[0]); err != nil {
+				return err
+			}
+			s.mu.Lock()
+			if !contains(s.rooms, args[0]) {
+				s.rooms = append(s.rooms, args[0])
+			}
+			s.current = args[0]
+			s.mu.Unlock()
+			printInfo("Now talking in %s", args[0])
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "leave", Args: "[room]", Help: "make the daemon leave a room, the current one by default",
+		MaxArgs: 1, Complete: s.completeRooms,
+		Run: func(args []string) error {
+			name := s.room()
+			if len(args) == 1 {
+				name = args[0]
+			}
+			if name == "" {
+				return fmt.Errorf("not in a room")
+			}
+			if err := s.c.Leave(name); err != nil {
+				return err
+			}
+			s.mu.Lock()
+			var rooms []string
+			for _, r := range s.rooms {
+				if r != name {
+					rooms = append(rooms, r)
+				}
+			}
+			s.rooms = rooms
+			if s.current == name {
+				s.current = ""
+				if len(rooms) > 0 {
+					s.current = rooms[0]
+				}
+			}
+			current := s.current
+			s.mu.Unlock()
+			printInfo("Left %s", name)
+			if current != "" {
+				printInfo("Now talking in %s", current)
+			}
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "rooms", Help: "list the daemon's rooms",
+		Run: func([]string) error {
+			st, err := s.c.Status()
+			if err != nil {
+				return err
+			}
+			current := s.room()
+			for _, r := range st.Rooms {
+				mark := " "
+				if r.Name == current {
+					mark = "*"
+				}
+				printInfo(" %s %-20s %d peers", mark, clean(r.Name), r.Peers)
+			}
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "who", Args: "[room]", Help: "list who is in a room, the current one by default",
+		MaxArgs: 1, Complete: s.completeRooms,
+		Run: func(args []string) error {
+			name := s.room()
+			if len(args) == 1 {
+				name = args[0]
+			}
+			if name == "" {
+				return fmt.Errorf("not in a room")
+			}
+			peers, err := s.c.Peers(name)
+			if err != nil {
+				return err
+			}
+			printInfo("In %s:", name)
+			for _, p := range peers {
+				printInfo(" - %-16s %-8s %s", clean(p.Nick), clean(p.Status), p.ID)
+			}
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "nick", Args: "<name>", Help: "change the daemon's nickname in every room",
+		MinArgs: 1, MaxArgs: 1,
+		Run: func(args []string) error {
+			if err := s.c.SetNick(args[0]); err != nil {
+				return err
+			}
+			printInfo("You are now %s", args[0])
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "me", Args: "<action>", Help: "say what you are doing, e.g. /me waves",
+		MinArgs: 1, MaxArgs: 1, Rest: true,
+		Run: func(args []string) error {
+			room := s.room()
+			if room == "" {
+				return fmt.Errorf("not in a room, /join one first")
+			}
+			return s.c.Publish(room, args[0], true)
+		},
+	})
+	c.Register(node.Command{
+		Name: "exit", Help: "detach, leaving the daemon in its rooms",
+		Run: func([]string) error {
+			s.mu.Lock()
+			s.detach = true
+			s.mu.Unlock()
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "stop", Help: "shut the daemon down and detach",
+		Run: func([]string) error {
+			if err := s.c.Shutdown(); err != nil {
+				return err
+			}
+			s.mu.Lock()
+			s.detach = true
+			s.mu.Unlock()
+			return nil
+		},
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
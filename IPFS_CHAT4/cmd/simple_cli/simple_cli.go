@@ -0,0 +1,401 @@
+// Command simple_cli publishes to and subscribes from chat network topics so
+// the network can be used from shell scripts and CI.
+//
+//	simple_cli pub -topic chat-room:lobby -message hello -peers 1
+//	tail -f build.log | simple_cli pub -topic builds
+//	simple_cli sub -topic chat-room:lobby -format jsonl -count 10 -timeout 1m
+//
+// Topics named like chat rooms carry chat messages, so they show up in the
+// chat apps; any other topic carries each line as raw bytes. The topic
+// policy only lets chat rooms through by default, so simple_cli adds any
+// other topic it is given to its own policy. Other peers on such a topic
+// need it in topicPolicy.allow in their config file too.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"IPFS_CHAT4/node"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// publishLinger gives the last messages time to leave before the host closes.
+const publishLinger = time.Second
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: simple_cli pub|sub -topic TOPIC [flags]")
+	fmt.Fprintln(os.Stderr, "run simple_cli pub -h or simple_cli sub -h for the flags")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("simple_cli: ")
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "pub":
+		err = runPub(os.Args[2:])
+	case "sub":
+		err = runSub(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// session is a short-lived node on one topic: a chat room when the topic is
+// named like one, a raw topic otherwise.
+type session struct {
+	h     host.Host
+	ps    *pubsub.PubSub
+	room  *node.ChatRoom
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+	close []func()
+}
+
+// sessionFlags are the flags shared by pub and sub.
+type sessionFlags struct {
+	cfg     node.Config
+	topic   string
+	connect string
+}
+
+func newSessionFlags(fs *flag.FlagSet) (*sessionFlags, error) {
+	profileDir, err := node.ProfileDir()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := node.LoadConfig(node.ConfigPath(profileDir))
+	if err != nil {
+		return nil, err
+	}
+
+	sf := &sessionFlags{cfg: cfg}
+	fs.StringVar(&sf.topic, "topic", "", "topic to use, e.g. "+node.TopicName("lobby")+" for a chat room")
+	fs.StringVar(&sf.connect, "connect", "", "comma separated peer multiaddrs to dial")
+	sf.cfg.BindFlags(fs)
+	return sf, nil
+}
+
+func (sf *sessionFlags) validate() error {
+	if sf.topic == "" {
+		return errors.New("-topic is required")
+	}
+	if !strings.HasPrefix(sf.topic, node.TopicName("")) && sf.topic != node.DirectoryTopic {
+		sf.cfg.TopicPolicy.AllowRegex = append(sf.cfg.TopicPolicy.AllowRegex, regexp.QuoteMeta(sf.topic))
+	}
+	if err := sf.cfg.Validate(); err != nil {
+		return err
+	}
+	sf.cfg.Log.Apply()
+	return nil
+}
+
+// start brings up a host with a throwaway identity, finds peers on the topic
+// and joins it.
+func (sf *sessionFlags) start(ctx context.Context, subscribe bool) (*session, error) {
+	profileDir, err := node.ProfileDir()
+	if err != nil {
+		return nil, err
+	}
+	psk, err := sf.cfg.PrivateNet.Load(profileDir)
+	if err != nil {
+		return nil, err
+	}
+	blocklist, err := node.LoadBlocklist(filepath.Join(profileDir, node.BlocklistFile))
+	if err != nil {
+		return nil, err
+	}
+	opts, err := sf.cfg.HostOptions(0, psk)
+	if err != nil {
+		return nil, err
+	}
+	h, err := libp2p.New(append(opts, libp2p.ConnectionGater(blocklist))...)
+	if err != nil {
+		return nil, err
+	}
+	blocklist.Attach(h)
+
+	s := &session{h: h}
+	s.close = append(s.close, func() { h.Close() })
+	for _, a := range node.DialAddrs(h) {
+		log.Printf("listening on %s", a)
+	}
+	if err := s.join(ctx, sf, blocklist, subscribe); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *session) join(ctx context.Context, sf *sessionFlags, blocklist *node.Blocklist, subscribe bool) error {
+	cfg := sf.cfg
+	psOpts, err := cfg.PubSubOptions(s.h, nil)
+	if err != nil {
+		return err
+	}
+	s.ps, err = node.NewPubSub(ctx, s.h, cfg.Router, append(psOpts, pubsub.WithBlacklist(blocklist))...)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MDNS.Enabled {
+		lan, err := node.StartMDNS(ctx, s.h, cfg.MDNS)
+		if err != nil {
+			return err
+		}
+		s.close = append(s.close, lan.Close)
+	}
+	roomName, isRoom := strings.CutPrefix(sf.topic, node.TopicName(""))
+	if cfg.DHT.Enabled && isRoom {
+		rdv, err := node.StartDHT(ctx, s.h, cfg.DHT)
+		if err != nil {
+			return err
+		}
+		rdv.Advertise(roomName)
+		s.close = append(s.close, rdv.Close)
+	}
+	if err := connectAll(ctx, s.h, sf.connect); err != nil {
+		return err
+	}
+
+	if isRoom {
+		s.room, err = node.JoinChatRoom(ctx, s.ps, s.h.ID(), cfg.Chat.Nick, roomName, cfg)
+		if err != nil {
+			return err
+		}
+		s.close = append(s.close, func() { s.room.Leave() })
+		return nil
+	}
+
+	s.topic, err = s.ps.Join(sf.topic)
+	if err != nil {
+		return err
+	}
+	s.close = append(s.close, func() { s.topic.Close() })
+	if !subscribe {
+		return nil
+	}
+	s.sub, err = s.topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	s.close = append(s.close, s.sub.Cancel)
+	return nil
+}
+
+// Close undoes start in reverse.
+func (s *session) Close() {
+	for i := len(s.close) - 1; i >= 0; i-- {
+		s.close[i]()
+	}
+}
+
+// publish sends one message once at least minPeers mesh peers are there, or
+// straight away for 0.
+func (s *session) publish(ctx context.Context, msg string, minPeers int) error {
+	if s.room != nil {
+		return s.room.PublishReady(ctx, msg, minPeers)
+	}
+	var opts []pubsub.PubOpt
+	if minPeers > 0 {
+		opts = append(opts, pubsub.WithReadiness(pubsub.MinTopicSize(minPeers)))
+	}
+	return s.topic.Publish(ctx, []byte(msg), opts...)
+}
+
+func connectAll(ctx context.Context, h host.Host, addrs string) error {
+	var mas []multiaddr.Multiaddr
+	for _, s := range strings.Split(addrs, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		a, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return fmt.Errorf("bad multiaddr %q: %w", s, err)
+		}
+		mas = append(mas, a)
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(mas...)
+	if err != nil {
+		return err
+	}
+	for _, pi := range infos {
+		if err := h.Connect(ctx, pi); err != nil {
+			log.Printf("connecting to %s: %v", pi.ID, err)
+		}
+	}
+	return nil
+}
+
+func runPub(args []string) error {
+	fs := flag.NewFlagSet("pub", flag.ExitOnError)
+	sf, err := newSessionFlags(fs)
+	if err != nil {
+		return err
+	}
+	message := fs.String("message", "", "message to publish; without it every line on stdin is published")
+	minPeers := fs.Int("peers", 1, "wait for at least this many mesh peers before publishing, 0 to not wait")
+	wait := fs.Duration("wait", 30*time.Second, "how long to wait for mesh peers")
+	fs.Parse(args)
+	if err := sf.validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	s, err := sf.start(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	send := func(msg string) error {
+		waitCtx, cancel := context.WithTimeout(ctx, *wait)
+		defer cancel()
+		err := s.publish(waitCtx, msg, *minPeers)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("fewer than %d mesh peers on %s after %s", *minPeers, sf.topic, *wait)
+		}
+		return err
+	}
+
+	if *message != "" {
+		err = send(*message)
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for err == nil && scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			err = send(scanner.Text())
+		}
+		if err == nil {
+			err = scanner.Err()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(publishLinger)
+	return nil
+}
+
+// received is one message as sub prints it in JSON Lines.
+type received struct {
+	Time    time.Time `json:"time"`
+	Topic   string    `json:"topic"`
+	From    string    `json:"from"`
+	Nick    string    `json:"nick,omitempty"`
+	Message string    `json:"message"`
+}
+
+func runSub(args []string) error {
+	fs := flag.NewFlagSet("sub", flag.ExitOnError)
+	sf, err := newSessionFlags(fs)
+	if err != nil {
+		return err
+	}
+	format := fs.String("format", "text", "output format: text or jsonl")
+	count := fs.Int("count", 0, "exit after this many messages, 0 for no limit")
+	timeout := fs.Duration("timeout", 0, "exit after this long, 0 for no limit; an error if -count was not reached")
+	fs.Parse(args)
+	if err := sf.validate(); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "jsonl" {
+		return fmt.Errorf("unknown format %q, want text or jsonl", *format)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	s, err := sf.start(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(out)
+	for n := 0; *count == 0 || n < *count; n++ {
+		r, err := s.next(ctx)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) && *count == 0 {
+			return nil
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("got %d of %d messages before the %s timeout", n, *count, *timeout)
+		} else if err != nil {
+			return err
+		}
+		r.Topic = sf.topic
+
+		if *format == "jsonl" {
+			enc.Encode(r)
+		} else if r.Nick != "" {
+			fmt.Fprintf(out, "%s: %s\n", r.Nick, r.Message)
+		} else {
+			fmt.Fprintln(out, r.Message)
+		}
+		// Flush every message so pipes see it straight away.
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// next waits for the next message from someone else.
+func (s *session) next(ctx context.Context) (received, error) {
+	if s.room != nil {
+		select {
+		case cm, ok := <-s.room.Messages:
+			if !ok {
+				return received{}, errors.New("left the room")
+			}
+			return received{Time: time.Now(), From: cm.SenderID, Nick: cm.SenderNick, Message: cm.Message}, nil
+		case <-ctx.Done():
+			return received{}, ctx.Err()
+		}
+	}
+
+	for {
+		msg, err := s.sub.Next(ctx)
+		if err != nil {
+			return received{}, err
+		}
+		if msg.ReceivedFrom == s.h.ID() {
+			continue
+		}
+		return received{Time: time.Now(), From: msg.GetFrom().String(), Message: string(msg.Data)}, nil
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"IPFS_CHAT4/lineedit"
+	"IPFS_CHAT4/node"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// connectTimeout bounds /connect.
+const connectTimeout = 30 * time.Second
+
+// chatSession is everything the interactive chat and its slash commands work
+// on: the rooms we are in, the one we are typing into and our nickname.
+type chatSession struct {
+	ctx       context.Context
+	h         host.Host
+	ps        *pubsub.PubSub
+	cfg       node.Config
+	dir       *node.Directory
+	rdv       *node.Rendezvous
+	blocklist *node.Blocklist
+	commands  *node.Commands
+	// ed prints messages arriving while the user types without mangling the line.
+	ed *lineedit.Editor
+
+	mu      sync.Mutex
+	nick    string
+	rooms   map[string]*node.ChatRoom
+	current *node.ChatRoom
+	// exit is set by /exit to end startChatInterface.
+	exit bool
+}
+
+func newChatSession(ctx context.Context, h host.Host, ps *pubsub.PubSub, cfg node.Config, dir *node.Directory, rdv *node.Rendezvous, blocklist *node.Blocklist, ed *lineedit.Editor) *chatSession {
+	s := &chatSession{
+		ctx:       ctx,
+		h:         h,
+		ps:        ps,
+		cfg:       cfg,
+		dir:       dir,
+		rdv:       rdv,
+		blocklist: blocklist,
+		commands:  node.NewCommands(),
+		ed:        ed,
+		nick:      cfg.Chat.Nick,
+		rooms:     make(map[string]*node.ChatRoom),
+	}
+	s.registerBuiltins()
+	node.ServeDirect(h, s.printDirect)
+	return s
+}
+
+// Current is the room we are typing into, or nil.
+func (s *chatSession) Current() *node.ChatRoom {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Join enters roomName, or switches to it when we are already in it, and makes it current.
+func (s *chatSession) Join(roomName string) (*node.ChatRoom, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room, ok := s.rooms[roomName]; ok {
+		s.current = room
+		return room, nil
+	}
+
+	room, err := node.JoinChatRoom(s.ctx, s.ps, s.h.ID(), s.nick, roomName, s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := room.ProtectPeers(s.h.ConnManager()); err != nil {
+		printError(fmt.Errorf("protecting room peers: %w", err))
+	}
+	if s.dir != nil {
+		s.dir.Advertise(room)
+	}
+	if s.rdv != nil {
+		s.rdv.Advertise(roomName)
+	}
+	s.rooms[roomName] = room
+	s.current = room
+	go s.printMessages(room)
+	return room, nil
+}
+
+// Leave leaves roomName and moves on to another room we are in, if any.
+func (s *chatSession) Leave(roomName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[roomName]
+	if !ok {
+		return fmt.Errorf("not in %s", roomName)
+	}
+	delete(s.rooms, roomName)
+	if s.dir != nil {
+		s.dir.Withdraw(roomName)
+	}
+	if s.rdv != nil {
+		s.rdv.Withdraw(roomName)
+	}
+	if s.current == room {
+		s.current = nil
+		if names := s.roomNamesLocked(); len(names) > 0 {
+			s.current = s.rooms[names[0]]
+		}
+	}
+	return room.Leave()
+}
+
+// RoomNames lists the rooms we are in, sorted.
+func (s *chatSession) RoomNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.roomNamesLocked()
+}
+
+func (s *chatSession) roomNamesLocked() []string {
+	names := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Nick is the nickname we use in our rooms.
+func (s *chatSession) Nick() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nick
+}
+
+// SetNick changes our nickname in every room and for rooms joined later.
+func (s *chatSession) SetNick(nick string) error {
+	if err := node.ValidateNick(nick); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, room := range s.rooms {
+		if err := room.SetNick(nick); err != nil {
+			return err
+		}
+	}
+	s.nick = nick
+	return nil
+}
+
+// printMessages shows a room's messages until we leave it, tagged with the
+// room name whenever we are in more than one.
+func (s *chatSession) printMessages(room *node.ChatRoom) {
+	self := s.h.ID().String()
+	for msg := range room.Messages {
+		tagged := len(s.RoomNames()) > 1
+		s.ed.Printf("%s", formatMessage(room.Name(), msg.SenderNick, msg.Message, msg.Kind, msg.SenderID == self, tagged))
+	}
+}
+
+// printDirect shows a direct message with the sender's peer ID next to the
+// nick, since anyone can pick any nick.
+func (s *chatSession) printDirect(dm node.DirectMessage) {
+	s.ed.Printf("%s", formatDirect(dm.Nick, dm.From.ShortString(), dm.Message))
+}
+
+// resolvePeer turns a nickname from any of our rooms, or a peer ID, into a peer ID.
+func (s *chatSession) resolvePeer(target string) (peer.ID, error) {
+	var found []peer.ID
+	seen := make(map[peer.ID]bool)
+	s.mu.Lock()
+	for _, room := range s.rooms {
+		for _, e := range room.Roster() {
+			if e.Nick == target && !e.Self && !seen[e.ID] {
+				seen[e.ID] = true
+				found = append(found, e.ID)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	switch len(found) {
+	case 1:
+		return found[0], nil
+	case 0:
+		if id, err := peer.Decode(target); err == nil {
+			return id, nil
+		}
+		return "", fmt.Errorf("nobody called %s in our rooms", target)
+	default:
+		return "", fmt.Errorf("%d peers are called %s, use a peer ID", len(found), target)
+	}
+}
+
+// completeNicks offers the nicknames of everyone else in our rooms.
+func (s *chatSession) completeNicks(_ int, prefix string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	s.mu.Lock()
+	for _, room := range s.rooms {
+		for _, e := range room.Roster() {
+			if !e.Self && strings.HasPrefix(e.Nick, prefix) && !seen[e.Nick] {
+				seen[e.Nick] = true
+				out = append(out, e.Nick)
+			}
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(out)
+	return out
+}
+
+// completeJoined offers the rooms we are in.
+func (s *chatSession) completeJoined(_ int, prefix string) []string {
+	var out []string
+	for _, name := range s.RoomNames() {
+		if strings.HasPrefix(name, prefix) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// completeRooms offers the rooms we are in and those in the directory.
+func (s *chatSession) completeRooms(i int, prefix string) []string {
+	out := s.completeJoined(i, prefix)
+	if s.dir == nil {
+		return out
+	}
+	seen := make(map[string]bool)
+	for _, name := range out {
+		seen[name] = true
+	}
+	for _, r := range s.dir.Rooms() {
+		if !seen[r.Name] && strings.HasPrefix(r.Name, prefix) {
+			out = append(out, r.Name)
+		}
+	}
+	return out
+}
+
+// inRoom returns the current room or an error telling the user to join one.
+func (s *chatSession) inRoom() (*node.ChatRoom, error) {
+	room := s.Current()
+	if room == nil {
+		return nil, fmt.Errorf("not in a room, /join one first")
+	}
+	return room, nil
+}
+
+func (s *chatSession) registerBuiltins() {
+	c := s.commands
+	c.Register(node.Command{
+		Name: "help", Args: "[command]", Help: "list commands, or explain one",
+		MaxArgs: 1,
+		Complete: func(_ int, prefix string) []string {
+			var out []string
+			for _, cmd := range c.List() {
+				if strings.HasPrefix(cmd.Name, prefix) {
+					out = append(out, cmd.Name)
+				}
+			}
+			return out
+		},
+		Run: func(args []string) error {
+			if len(args) == 1 {
+				cmd, ok := c.Lookup(args[0])
+				if !ok {
+					return fmt.Errorf("no command /%s", strings.TrimPrefix(args[0], "/"))
+				}
+				printInfo("%s", cmd.Usage())
+				printInfo("    %s", cmd.Help)
+				return nil
+			}
+			printInfo("Commands (start a message with // to send a leading slash):")
+			for _, cmd := range c.List() {
+				printInfo("  %-28s %s", cmd.Usage(), cmd.Help)
+			}
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "nick", Args: "<name>", Help: "change your nickname in every room",
+		MinArgs: 1, MaxArgs: 1,
+		Run: func(args []string) error {
+			if err := s.SetNick(args[0]); err != nil {
+				return err
+			}
+			printInfo("You are now %s", args[0])
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "join", Args: "<room>", Help: "join a room, or switch to one you are in",
+		MinArgs: 1, MaxArgs: 1, Complete: s.completeRooms,
+		Run: func(args []string) error {
+			if _, err := s.Join(args[0]); err != nil {
+				return err
+			}
+			printInfo("Now talking in %s", args[0])
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "leave", Args: "[room]", Help: "leave a room, the current one by default",
+		MaxArgs: 1, Complete: s.completeJoined,
+		Run: func(args []string) error {
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			} else if room := s.Current(); room != nil {
+				name = room.Name()
+			} else {
+				return fmt.Errorf("not in a room")
+			}
+			if err := s.Leave(name); err != nil {
+				return err
+			}
+			printInfo("Left %s", name)
+			if room := s.Current(); room != nil {
+				printInfo("Now talking in %s", room.Name())
+			}
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "rooms", Help: "list the rooms you are in and the ones announced in the directory",
+		Run: func([]string) error {
+			current := s.Current()
+			printInfo("Your rooms:")
+			for _, name := range s.RoomNames() {
+				mark := " "
+				if current != nil && current.Name() == name {
+					mark = "*"
+				}
+				printInfo(" %s %s", mark, name)
+			}
+			if s.dir != nil {
+				printInfo("Directory:")
+				for _, r := range s.dir.Rooms() {
+					printInfo("   %-20s %3d members  %s", clean(r.Name), r.Members, clean(r.Description))
+				}
+			}
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "who", Args: "[room]", Help: "list who is in a room, the current one by default",
+		MaxArgs: 1, Complete: s.completeJoined,
+		Run: func(args []string) error {
+			room, err := s.inRoom()
+			if len(args) == 1 {
+				s.mu.Lock()
+				r, ok := s.rooms[args[0]]
+				s.mu.Unlock()
+				if !ok {
+					return fmt.Errorf("not in %s", args[0])
+				}
+				room, err = r, nil
+			}
+			if err != nil {
+				return err
+			}
+			printRoster(room)
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "me", Args: "<action>", Help: "say what you are doing, e.g. /me waves",
+		MinArgs: 1, MaxArgs: 1, Rest: true,
+		Run: func(args []string) error {
+			room, err := s.inRoom()
+			if err != nil {
+				return err
+			}
+			return room.PublishAction(args[0])
+		},
+	})
+	c.Register(node.Command{
+		Name: "msg", Args: "<nick|peer ID> <message>", Help: "send a private message straight to one peer",
+		MinArgs: 2, MaxArgs: 2, Rest: true, Complete: s.completeNicks,
+		Run: func(args []string) error {
+			to, err := s.resolvePeer(args[0])
+			if err != nil {
+				return err
+			}
+			s.mu.Lock()
+			nick := s.nick
+			s.mu.Unlock()
+			if err := node.SendDirect(s.ctx, s.h, to, nick, args[1]); err != nil {
+				return err
+			}
+			s.ed.Printf("%s", formatDirectSent(args[0], args[1]))
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "connect", Args: "<multiaddr>", Help: "dial a peer by its full /p2p/ address",
+		MinArgs: 1, MaxArgs: 1,
+		Run: func(args []string) error {
+			ma, err := multiaddr.NewMultiaddr(args[0])
+			if err != nil {
+				return err
+			}
+			info, err := peer.AddrInfoFromP2pAddr(ma)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(s.ctx, connectTimeout)
+			defer cancel()
+			if err := s.h.Connect(ctx, *info); err != nil {
+				return err
+			}
+			printInfo("Connected to %s", info.ID)
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "block", Args: "<nick|peer ID|CIDR>", Help: "refuse all connections from a peer or address range",
+		MinArgs: 1, MaxArgs: 1, Complete: s.completeNicks,
+		Run: func(args []string) error {
+			return s.changeBlocklist(args[0], s.blocklist.Block, "Blocked")
+		},
+	})
+	c.Register(node.Command{
+		Name: "unblock", Args: "<peer ID|CIDR>", Help: "lift a block",
+		MinArgs: 1, MaxArgs: 1,
+		Run: func(args []string) error {
+			return s.changeBlocklist(args[0], s.blocklist.Unblock, "Unblocked")
+		},
+	})
+	c.Register(node.Command{
+		Name: "blocklist", Args: "[reload]", Help: "list blocked peers and ranges, or reread the blocklist file",
+		MaxArgs: 1,
+		Complete: func(_ int, prefix string) []string {
+			if strings.HasPrefix("reload", prefix) {
+				return []string{"reload"}
+			}
+			return nil
+		},
+		Run: func(args []string) error {
+			if len(args) == 1 {
+				if args[0] != "reload" {
+					return fmt.Errorf("usage: /blocklist [reload]")
+				}
+				if err := s.blocklist.Reload(); err != nil {
+					return err
+				}
+			}
+			printBlocklist(s.blocklist)
+			return nil
+		},
+	})
+	c.Register(node.Command{
+		Name: "exit", Help: "go back to the menu",
+		Run: func([]string) error {
+			s.mu.Lock()
+			s.exit = true
+			s.mu.Unlock()
+			return nil
+		},
+	})
+}
+
+// exiting reports whether /exit was given, and resets it for the next chat.
+func (s *chatSession) exiting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exit := s.exit
+	s.exit = false
+	return exit
+}
+
+// changeBlocklist runs /block or /unblock on a nickname in our rooms, a peer ID, a CIDR or an IP address.
+func (s *chatSession) changeBlocklist(target string, change func(string) error, done string) error {
+	if id, err := s.resolvePeer(target); err == nil {
+		target = id.String()
+	}
+	if err := change(target); err != nil {
+		return err
+	}
+	printInfo("%s %s", done, target)
+	return nil
+}
+
+// printBlocklist lists the blocked peers and address ranges.
+func printBlocklist(blocklist *node.Blocklist) {
+	peers, cidrs := blocklist.Entries()
+	printInfo("Blocked: %d peer(s), %d range(s)", len(peers), len(cidrs))
+	for _, p := range peers {
+		printInfo(" - %s", p)
+	}
+	for _, c := range cidrs {
+		printInfo(" - %s", c)
+	}
+}
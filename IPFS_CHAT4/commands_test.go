@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"IPFS_CHAT4/node"
+)
+
+func TestPlainCommandOutput(t *testing.T) {
+	var buf bytes.Buffer
+	stdout, plain = &buf, true
+	defer func() { stdout, plain = os.Stdout, false }()
+
+	s := &chatSession{commands: node.NewCommands(), nick: "alice", rooms: make(map[string]*node.ChatRoom)}
+	s.registerBuiltins()
+	for _, line := range []string{"/help", "/help nick", "/nick bob", "/rooms"} {
+		if err := s.commands.Run(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	printError(s.commands.Run("/leave"))
+
+	// Attached to a daemon the same commands print the same way.
+	a := &attachSession{commands: node.NewCommands()}
+	a.registerCommands()
+	if err := a.commands.Run("/help"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if strings.ContainsAny(out, "\r\x1b") {
+		t.Fatalf("control characters in plain output:\n%q", out)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if !strings.HasPrefix(line, "info ") && !strings.HasPrefix(line, "error ") {
+			t.Errorf("line without a label: %q", line)
+		}
+	}
+	if !strings.Contains(out, "info You are now bob\n") {
+		t.Errorf("no nick change in:\n%s", out)
+	}
+}
+
+func TestSetNickWithoutRooms(t *testing.T) {
+	s := &chatSession{nick: "alice", rooms: make(map[string]*node.ChatRoom)}
+	for _, nick := range []string{"", strings.Repeat("x", node.MaxNickLength+1)} {
+		if err := s.SetNick(nick); err == nil {
+			t.Errorf("nick %q accepted", nick)
+		}
+	}
+	if s.Nick() != "alice" {
+		t.Fatalf("nick changed to %q", s.Nick())
+	}
+}
+
+func TestPlainMessageIsOneLine(t *testing.T) {
+	plain = true
+	defer func() { plain = false }()
+
+	got := formatMessage("lobby", "bo\x1b[31mb", "first\r\nsecond\x1b[2J\x1b[H", "", false, false)
+	if strings.ContainsAny(got, "\r\x1b") || strings.Count(got, "\n") != 1 {
+		t.Fatalf("got %q, want one line without control characters", got)
+	}
+	if want := `message lobby bo[31mb: first\nsecond[2J[H` + "\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got := formatDirect("eve\r", "…abc", "a\nb"); got != "direct from eve (…abc): a\\nb\n" {
+		t.Fatalf("direct message %q", got)
+	}
+}
@@ -0,0 +1,196 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// ErrClosed is returned by calls on a client whose connection has gone.
+var ErrClosed = errors.New("control connection closed")
+
+// Client is attached to a daemon. Closing it detaches without the daemon
+// leaving any room.
+type Client struct {
+	nc net.Conn
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]chan Response
+	err     error
+
+	messages chan Message
+}
+
+// Dial attaches to the daemon listening on the socket at path.
+func Dial(path string) (*Client, error) {
+	nc, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		nc:       nc,
+		pending:  make(map[string]chan Response),
+		messages: make(chan Message, connQueue),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Close detaches from the daemon.
+func (c *Client) Close() error {
+	return c.nc.Close()
+}
+
+// Messages delivers the messages of subscribed rooms. It is closed when the
+// connection ends.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Call runs method with params and decodes its result into result, which may be nil.
+func (c *Client) Call(method string, params, result interface{}) error {
+	req := Request{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = p
+	}
+
+	reply := make(chan Response, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := strconv.FormatInt(c.nextID, 10)
+	c.pending[id] = reply
+	req.ID = json.RawMessage(id)
+	b, err := json.Marshal(req)
+	if err == nil {
+		_, err = c.nc.Write(append(b, '\n'))
+	}
+	if err != nil {
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return err
+	}
+	c.mu.Unlock()
+
+	resp, ok := <-reply
+	if !ok {
+		return ErrClosed
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// Status describes the daemon's node.
+func (c *Client) Status() (Status, error) {
+	var st Status
+	err := c.Call(MethodStatus, nil, &st)
+	return st, err
+}
+
+// Peers lists the members of a room, or every connected peer when room is empty.
+func (c *Client) Peers(room string) ([]Peer, error) {
+	var peers []Peer
+	err := c.Call(MethodPeers, PeersParams{Room: room}, &peers)
+	return peers, err
+}
+
+// History returns recent messages of a room, see HistoryParams.
+func (c *Client) History(p HistoryParams) ([]Message, error) {
+	var history []Message
+	err := c.Call(MethodHistory, p, &history)
+	return history, err
+}
+
+// Join makes the daemon join a room. It stays there after we detach.
+func (c *Client) Join(room string) error {
+	return c.Call(MethodJoin, RoomParams{Room: room}, nil)
+}
+
+// Leave makes the daemon leave a room.
+func (c *Client) Leave(room string) error {
+	return c.Call(MethodLeave, RoomParams{Room: room}, nil)
+}
+
+// Subscribe starts delivering a room's messages on Messages.
+func (c *Client) Subscribe(room string) error {
+	return c.Call(MethodSubscribe, RoomParams{Room: room}, nil)
+}
+
+// Unsubscribe stops delivering a room's messages.
+func (c *Client) Unsubscribe(room string) error {
+	return c.Call(MethodUnsubscribe, RoomParams{Room: room}, nil)
+}
+
+// Publish sends a message, or a /me action, to a room.
+func (c *Client) Publish(room, message string, action bool) error {
+	return c.Call(MethodPublish, PublishParams{Room: room, Message: message, Action: action}, nil)
+}
+
+// SetNick changes the daemon's nickname in every room.
+func (c *Client) SetNick(nick string) error {
+	return c.Call(MethodNick, NickParams{Nick: nick}, nil)
+}
+
+// Shutdown stops the daemon.
+func (c *Client) Shutdown() error {
+	return c.Call(MethodShutdown, nil, nil)
+}
+
+func (c *Client) readLoop() {
+	scanner := bufio.NewScanner(c.nc)
+	scanner.Buffer(make([]byte, 0, 4096), maxRequestSize)
+	for scanner.Scan() {
+		// Responses carry an ID and no method; notifications the other way round.
+		var msg struct {
+			Response
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if msg.Method == NotifyMessage {
+			var m Message
+			if json.Unmarshal(msg.Params, &m) == nil {
+				select {
+				case c.messages <- m:
+				default:
+				}
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		reply, ok := c.pending[string(msg.ID)]
+		delete(c.pending, string(msg.ID))
+		c.mu.Unlock()
+		if ok {
+			reply <- msg.Response
+		}
+	}
+
+	c.mu.Lock()
+	c.err = ErrClosed
+	for id, reply := range c.pending {
+		close(reply)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	close(c.messages)
+}
@@ -0,0 +1,166 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"IPFS_CHAT4/node"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// startDaemon runs a node with a control server and returns its socket path.
+func startDaemon(t *testing.T, ctx context.Context, nick string) (*Server, string) {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	cfg := node.DefaultConfig()
+	cfg.Chat.Nick = nick
+	ps, err := node.NewPubSub(ctx, h, cfg.Router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(ctx, h, ps, cfg, nil, nil, "public")
+	path := filepath.Join(t.TempDir(), SocketFile)
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		l.Close()
+		srv.Close()
+	})
+	return srv, path
+}
+
+func dial(t *testing.T, path string) *Client {
+	t.Helper()
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestDaemon(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aliceSrv, alicePath := startDaemon(t, ctx, "alice")
+	bobSrv, bobPath := startDaemon(t, ctx, "bob")
+	if err := aliceSrv.h.Connect(ctx, peer.AddrInfo{ID: bobSrv.h.ID(), Addrs: bobSrv.h.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(alicePath); err == nil {
+		t.Fatal("a second daemon took over a live socket")
+	}
+
+	alice, bob := dial(t, alicePath), dial(t, bobPath)
+	for _, c := range []*Client{alice, bob} {
+		if err := c.Subscribe("lobby"); err == nil {
+			t.Fatal("subscribed to a room the daemon is not in")
+		}
+		if err := c.Join("lobby"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Subscribe("lobby"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Publish until the mesh has formed and alice hears bob.
+	deadline := time.After(20 * time.Second)
+	tick := time.NewTicker(300 * time.Millisecond)
+	defer tick.Stop()
+wait:
+	for {
+		select {
+		case m := <-alice.Messages():
+			if m.Room != "lobby" || m.Nick != "bob" || m.Text != "hi" || m.Self {
+				t.Fatalf("alice got %+v", m)
+			}
+			break wait
+		case <-tick.C:
+			if err := bob.Publish("lobby", "hi", false); err != nil {
+				t.Fatal(err)
+			}
+		case <-deadline:
+			t.Fatal("alice never heard bob")
+		}
+	}
+	// Bob's own client sees what went out through his daemon.
+	select {
+	case m := <-bob.Messages():
+		if !m.Self || m.Text != "hi" {
+			t.Fatalf("bob got %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob's client did not see its own message")
+	}
+
+	// Detaching leaves the daemon in its rooms.
+	alice.Close()
+	again := dial(t, alicePath)
+	st, err := again.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Nick != "alice" || len(st.Rooms) != 1 || st.Rooms[0].Name != "lobby" || st.Peers != 1 {
+		t.Fatalf("status after detaching %+v", st)
+	}
+	peers, err := again.Peers("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("lobby members %+v", peers)
+	}
+
+	if err := again.Call("bogus", nil, nil); err == nil || err.(*Error).Code != CodeMethodNotFound {
+		t.Fatalf("unknown method: %v", err)
+	}
+	if err := again.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-aliceSrv.Done():
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not signal Done")
+	}
+}
+
+func TestListenSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, SocketFile)
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode %s, want a 0600 socket", fi.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("%d entries next to the socket, want only the socket", len(entries))
+	}
+
+	l.Close()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("socket left behind after Close: %v", err)
+	}
+}
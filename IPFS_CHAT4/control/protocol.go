@@ -0,0 +1,146 @@
+// Package control is the local API of the chat daemon: JSON-RPC 2.0 over a
+// Unix socket, one JSON object per line. Clients call methods such as join
+// and publish, and receive the messages of the rooms they subscribe to as
+// "message" notifications. Clients come and go; the daemon stays in its
+// rooms and connected to the network.
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SocketFile is the control socket's name in the profile directory.
+const SocketFile = "daemon.sock"
+
+// Methods of the control API.
+const (
+	MethodStatus      = "status"
+	MethodPeers       = "peers"
+	MethodJoin        = "join"
+	MethodLeave       = "leave"
+	MethodHistory     = "history"
+	MethodPublish     = "publish"
+	MethodNick        = "nick"
+	MethodSubscribe   = "subscribe"
+	MethodUnsubscribe = "unsubscribe"
+	MethodShutdown    = "shutdown"
+
+	// NotifyMessage is the notification carrying a room message to subscribers.
+	NotifyMessage = "message"
+)
+
+// JSON-RPC error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	// CodeFailed is a call the daemon understood but could not carry out.
+	CodeFailed = -32000
+)
+
+// Request is a call or, without an ID, a notification.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response answers the Request with the same ID.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a failed call.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// NotInRoomError is a call about a room the daemon has not joined.
+type NotInRoomError struct {
+	Room string
+}
+
+func (e *NotInRoomError) Error() string {
+	return fmt.Sprintf("not in %s, join it first", e.Room)
+}
+
+// RoomParams names the room for join, leave, subscribe and unsubscribe.
+type RoomParams struct {
+	Room string `json:"room"`
+}
+
+// PublishParams is a message for a room we are in. Action sends it as /me.
+type PublishParams struct {
+	Room    string `json:"room"`
+	Message string `json:"message"`
+	Action  bool   `json:"action,omitempty"`
+}
+
+// HistoryParams asks for up to Limit recent messages of Room, all kept when
+// 0. Before pages back: only messages with a lower Seq are returned.
+type HistoryParams struct {
+	Room   string `json:"room"`
+	Limit  int    `json:"limit,omitempty"`
+	Before uint64 `json:"before,omitempty"`
+}
+
+// NickParams changes the nickname in every room.
+type NickParams struct {
+	Nick string `json:"nick"`
+}
+
+// PeersParams lists the members of Room, or every connected peer when empty.
+type PeersParams struct {
+	Room string `json:"room,omitempty"`
+}
+
+// Status describes the daemon's node.
+type Status struct {
+	ID      string       `json:"id"`
+	Addrs   []string     `json:"addrs"`
+	Network string       `json:"network"`
+	Nick    string       `json:"nick"`
+	Peers   int          `json:"peers"`
+	Rooms   []RoomStatus `json:"rooms"`
+	Started time.Time    `json:"started"`
+}
+
+// RoomStatus is one room the daemon is in.
+type RoomStatus struct {
+	Name  string `json:"name"`
+	Peers int    `json:"peers"`
+}
+
+// Peer is a connected peer, or a room member when listing a room.
+type Peer struct {
+	ID     string   `json:"id"`
+	Nick   string   `json:"nick,omitempty"`
+	Status string   `json:"status,omitempty"`
+	Addrs  []string `json:"addrs,omitempty"`
+}
+
+// Message is a room message sent to subscribers. Self marks messages
+// published through the daemon, so every attached client sees them. Seq
+// numbers a room's messages from 1 in the order the daemon saw them.
+type Message struct {
+	Seq  uint64    `json:"seq"`
+	Room string    `json:"room"`
+	From string    `json:"from"`
+	Nick string    `json:"nick"`
+	Kind string    `json:"kind,omitempty"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+	Self bool      `json:"self,omitempty"`
+}
@@ -0,0 +1,616 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"IPFS_CHAT4/node"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// maxRequestSize bounds one request line.
+const maxRequestSize = 1 << 20
+
+// HistorySize is how many recent messages the daemon keeps per room.
+const HistorySize = 200
+
+// connQueue is how many notifications may wait for a slow client before
+// further ones are dropped, so one stuck client never stalls a room.
+const connQueue = 256
+
+// Server runs the control API for a node that stays up while clients come and go.
+type Server struct {
+	ctx     context.Context
+	h       host.Host
+	ps      *pubsub.PubSub
+	cfg     node.Config
+	dir     *node.Directory
+	rdv     *node.Rendezvous
+	network string
+	started time.Time
+
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+
+	mu    sync.Mutex
+	nick  string
+	rooms map[string]*hub
+	conns map[*conn]struct{}
+}
+
+// hub is a room the daemon is in, its recent messages and the clients
+// subscribed to it. Server.mu guards everything but room.
+type hub struct {
+	room     *node.ChatRoom
+	subs     map[*conn]struct{}
+	watchers map[chan Message]struct{}
+	history  []Message
+	seq      uint64
+}
+
+// conn is one attached client.
+type conn struct {
+	nc  net.Conn
+	out chan []byte
+	// closed is closed when the client goes away.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer serves the node made of h and ps. dir and rdv may be nil;
+// network describes the network for status, e.g. PrivateNetConfig.Describe.
+func NewServer(ctx context.Context, h host.Host, ps *pubsub.PubSub, cfg node.Config, dir *node.Directory, rdv *node.Rendezvous, network string) *Server {
+	return &Server{
+		ctx:      ctx,
+		h:        h,
+		ps:       ps,
+		cfg:      cfg,
+		dir:      dir,
+		rdv:      rdv,
+		network:  network,
+		started:  time.Now(),
+		shutdown: make(chan struct{}),
+		nick:     cfg.Chat.Nick,
+		rooms:    make(map[string]*hub),
+		conns:    make(map[*conn]struct{}),
+	}
+}
+
+// Listen opens the control socket at path, readable by our user only.
+// A socket left behind by a daemon that died is replaced; a live one is an error.
+//
+// The socket is made in a fresh directory only we can enter and moved to
+// path once it is 0600, so nobody can connect while the umask decides its
+// mode. Closing the listener removes it.
+func Listen(path string) (net.Listener, error) {
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	private, err := os.MkdirTemp(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(private)
+	tmp := filepath.Join(private, filepath.Base(path))
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return socketListener{l, path}, nil
+}
+
+// socketListener removes the socket from where Listen moved it on Close.
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (l socketListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// Serve accepts clients on l until l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		c := &conn{nc: nc, out: make(chan []byte, connQueue), closed: make(chan struct{})}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.writeLoop()
+		go s.readLoop(c)
+	}
+}
+
+// Done is closed when a client asks the daemon to shut down.
+func (s *Server) Done() <-chan struct{} {
+	return s.shutdown
+}
+
+// Close detaches every client and leaves every room.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	names := s.roomNamesLocked()
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+	var firstErr error
+	for _, name := range names {
+		if err := s.Leave(name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Join enters a room and starts passing its messages to subscribers.
+// Joining a room we are in does nothing.
+func (s *Server) Join(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[name]; ok {
+		return nil
+	}
+
+	room, err := node.JoinChatRoom(s.ctx, s.ps, s.h.ID(), s.nick, name, s.cfg)
+	if err != nil {
+		return err
+	}
+	if err := room.ProtectPeers(s.h.ConnManager()); err != nil {
+		log.Println("Error protecting room peers:", err)
+	}
+	if s.dir != nil {
+		s.dir.Advertise(room)
+	}
+	if s.rdv != nil {
+		s.rdv.Advertise(name)
+	}
+	hb := &hub{room: room, subs: make(map[*conn]struct{}), watchers: make(map[chan Message]struct{})}
+	s.rooms[name] = hb
+	go s.fanOut(hb)
+	return nil
+}
+
+// Leave leaves a room. Its subscribers stop getting messages.
+func (s *Server) Leave(name string) error {
+	s.mu.Lock()
+	hb, ok := s.rooms[name]
+	if !ok {
+		s.mu.Unlock()
+		return &NotInRoomError{Room: name}
+	}
+	delete(s.rooms, name)
+	for w := range hb.watchers {
+		delete(hb.watchers, w)
+		close(w)
+	}
+	s.mu.Unlock()
+
+	if s.dir != nil {
+		s.dir.Withdraw(name)
+	}
+	if s.rdv != nil {
+		s.rdv.Withdraw(name)
+	}
+	return hb.room.Leave()
+}
+
+// fanOut passes a room's messages to its subscribers until the room is left.
+func (s *Server) fanOut(hb *hub) {
+	for msg := range hb.room.Messages {
+		s.broadcast(hb, Message{
+			Room: hb.room.Name(),
+			From: msg.SenderID,
+			Nick: msg.SenderNick,
+			Kind: msg.Kind,
+			Text: msg.Message,
+			Time: time.Now(),
+		})
+	}
+}
+
+func (s *Server) broadcast(hb *hub, m Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hb.seq++
+	m.Seq = hb.seq
+	b, err := notification(NotifyMessage, m)
+	if err != nil {
+		return
+	}
+	if hb.history = append(hb.history, m); len(hb.history) > HistorySize {
+		hb.history = hb.history[len(hb.history)-HistorySize:]
+	}
+	for c := range hb.subs {
+		c.send(b)
+	}
+	for w := range hb.watchers {
+		select {
+		case w <- m:
+		default:
+		}
+	}
+}
+
+// History returns up to p.Limit of a room's most recent messages before
+// p.Before, oldest first. A Limit <= 0 returns all HistorySize the daemon keeps.
+func (s *Server) History(p HistoryParams) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hb, ok := s.rooms[p.Room]
+	if !ok {
+		return nil, &NotInRoomError{Room: p.Room}
+	}
+	h := hb.history
+	if p.Before > 0 {
+		h = h[:sort.Search(len(h), func(i int) bool { return h[i].Seq >= p.Before })]
+	}
+	if p.Limit > 0 && len(h) > p.Limit {
+		h = h[len(h)-p.Limit:]
+	}
+	return append([]Message(nil), h...), nil
+}
+
+// Watch delivers a room's messages in process until stop is called or the
+// room is left, which closes the channel. Messages are dropped while the
+// channel is full.
+func (s *Server) Watch(roomName string) (messages <-chan Message, stop func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hb, ok := s.rooms[roomName]
+	if !ok {
+		return nil, nil, &NotInRoomError{Room: roomName}
+	}
+	w := make(chan Message, connQueue)
+	hb.watchers[w] = struct{}{}
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, ok := hb.watchers[w]; ok {
+				delete(hb.watchers, w)
+				close(w)
+			}
+		})
+	}
+	return w, stop, nil
+}
+
+func (s *Server) readLoop(c *conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		for _, hb := range s.rooms {
+			delete(hb.subs, c)
+		}
+		s.mu.Unlock()
+		c.close()
+	}()
+
+	scanner := bufio.NewScanner(c.nc)
+	scanner.Buffer(make([]byte, 0, 4096), maxRequestSize)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			c.reply(nil, nil, &Error{Code: CodeParseError, Message: err.Error()})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			c.reply(req.ID, nil, &Error{Code: CodeInvalidRequest, Message: "not a JSON-RPC 2.0 request"})
+			continue
+		}
+		result, rpcErr := s.call(c, req)
+		if req.ID != nil {
+			c.reply(req.ID, result, rpcErr)
+		}
+	}
+}
+
+// call runs one method for client c.
+func (s *Server) call(c *conn, req Request) (interface{}, *Error) {
+	switch req.Method {
+	case MethodStatus:
+		return s.Status(), nil
+
+	case MethodPeers:
+		var p PeersParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		peers, err := s.Peers(p.Room)
+		return peers, failed(err)
+
+	case MethodHistory:
+		var p HistoryParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		history, err := s.History(p)
+		return history, failed(err)
+
+	case MethodJoin, MethodLeave, MethodSubscribe, MethodUnsubscribe:
+		var p RoomParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		if p.Room == "" {
+			return nil, &Error{Code: CodeInvalidParams, Message: "room is required"}
+		}
+		var err error
+		switch req.Method {
+		case MethodJoin:
+			err = s.Join(p.Room)
+		case MethodLeave:
+			err = s.Leave(p.Room)
+		case MethodSubscribe:
+			err = s.subscribe(c, p.Room, true)
+		case MethodUnsubscribe:
+			err = s.subscribe(c, p.Room, false)
+		}
+		return nil, failed(err)
+
+	case MethodPublish:
+		var p PublishParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, failed(s.Publish(p))
+
+	case MethodNick:
+		var p NickParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, failed(s.SetNick(p.Nick))
+
+	case MethodShutdown:
+		s.shutdownOnce.Do(func() { close(s.shutdown) })
+		return nil, nil
+	}
+	return nil, &Error{Code: CodeMethodNotFound, Message: "no method " + req.Method}
+}
+
+// Status describes the node and the rooms it is in.
+func (s *Server) Status() Status {
+	st := Status{
+		ID:      s.h.ID().String(),
+		Addrs:   node.DialAddrs(s.h),
+		Network: s.network,
+		Peers:   len(s.h.Network().Peers()),
+		Started: s.started,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.Nick = s.nick
+	for _, name := range s.roomNamesLocked() {
+		st.Rooms = append(st.Rooms, RoomStatus{Name: name, Peers: len(s.rooms[name].room.ListPeers())})
+	}
+	return st
+}
+
+// Peers lists the members of a room, or every connected peer when roomName is empty.
+func (s *Server) Peers(roomName string) ([]Peer, error) {
+	var out []Peer
+	if roomName == "" {
+		for _, id := range s.h.Network().Peers() {
+			p := Peer{ID: id.String()}
+			for _, c := range s.h.Network().ConnsToPeer(id) {
+				p.Addrs = append(p.Addrs, c.RemoteMultiaddr().String())
+			}
+			out = append(out, p)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+		return out, nil
+	}
+
+	s.mu.Lock()
+	hb, ok := s.rooms[roomName]
+	s.mu.Unlock()
+	if !ok {
+		return nil, &NotInRoomError{Room: roomName}
+	}
+	for _, e := range hb.room.Roster() {
+		out = append(out, Peer{ID: e.ID.String(), Nick: e.Nick, Status: e.Status})
+	}
+	return out, nil
+}
+
+func (s *Server) subscribe(c *conn, roomName string, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hb, ok := s.rooms[roomName]
+	if !ok {
+		return &NotInRoomError{Room: roomName}
+	}
+	if on {
+		hb.subs[c] = struct{}{}
+	} else {
+		delete(hb.subs, c)
+	}
+	return nil
+}
+
+// Publish sends a message and shows it to the room's subscribers, since
+// the room itself never hands us back our own messages.
+func (s *Server) Publish(p PublishParams) error {
+	s.mu.Lock()
+	hb, ok := s.rooms[p.Room]
+	s.mu.Unlock()
+	if !ok {
+		return &NotInRoomError{Room: p.Room}
+	}
+	if p.Message == "" {
+		return errors.New("empty message")
+	}
+
+	kind := node.KindChat
+	publish := hb.room.Publish
+	if p.Action {
+		kind = node.KindAction
+		publish = hb.room.PublishAction
+	}
+	if err := publish(p.Message); err != nil {
+		return err
+	}
+	s.broadcast(hb, Message{
+		Room: p.Room,
+		From: s.h.ID().String(),
+		Nick: hb.room.Nick(),
+		Kind: kind,
+		Text: p.Message,
+		Time: time.Now(),
+		Self: true,
+	})
+	return nil
+}
+
+// SetNick changes our nickname in every room and for rooms joined later.
+func (s *Server) SetNick(nick string) error {
+	if err := node.ValidateNick(nick); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hb := range s.rooms {
+		if err := hb.room.SetNick(nick); err != nil {
+			return err
+		}
+	}
+	s.nick = nick
+	return nil
+}
+
+func (s *Server) roomNamesLocked() []string {
+	names := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *conn) send(b []byte) {
+	select {
+	case c.out <- b:
+	case <-c.closed:
+	default:
+		// The client is not keeping up; it misses this one.
+	}
+}
+
+func (c *conn) reply(id json.RawMessage, result interface{}, rpcErr *Error) {
+	resp := Response{JSONRPC: "2.0", ID: id, Error: rpcErr}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	if rpcErr == nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &Error{Code: CodeFailed, Message: err.Error()}
+		} else {
+			resp.Result = b
+		}
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	// Replies wait for room; only notifications may be dropped.
+	select {
+	case c.out <- append(b, '\n'):
+	case <-c.closed:
+	}
+}
+
+func (c *conn) writeLoop() {
+	for {
+		select {
+		case b := <-c.out:
+			if _, err := c.nc.Write(b); err != nil {
+				c.close()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.nc.Close()
+	})
+}
+
+func notification(method string, params interface{}) ([]byte, error) {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(Request{JSONRPC: "2.0", Method: method, Params: p})
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func decodeParams(raw json.RawMessage, v interface{}) *Error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// failed turns an error from carrying out a call into its JSON-RPC form.
+func failed(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: CodeFailed, Message: err.Error()}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"IPFS_CHAT4/control"
+	"IPFS_CHAT4/gateway"
+	"IPFS_CHAT4/node"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// defaultSocket is the control socket in the profile directory.
+func defaultSocket() string {
+	return filepath.Join(GetProfileDir(), control.SocketFile)
+}
+
+// defaultWebListen is where the web subcommand serves the UI unless the
+// config or -http says otherwise.
+const defaultWebListen = "127.0.0.1:8080"
+
+// runDaemon runs the node in the background of any UI: it stays in its rooms
+// while clients attach and detach over the control socket.
+//
+//	IPFS_CHAT4 daemon -join lobby &
+//	IPFS_CHAT4 attach
+//
+// With -http it also serves the gateway and web UI for browsers. web turns
+// the gateway on by default, for the web subcommand.
+func runDaemon(args []string, web bool) {
+	cfg, err := node.LoadConfig(GetConfigFile())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if web && cfg.Gateway.Listen == "" {
+		cfg.Gateway.Listen = defaultWebListen
+	}
+
+	name := "daemon"
+	if web {
+		name = "web"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	socket := fs.String("socket", defaultSocket(), "control socket path")
+	port := fs.Int("sp", 0, "source port number")
+	cfg.BindFlags(fs)
+	fs.Parse(args)
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	cfg.Log.Apply()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	l, err := control.Listen(*socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+
+	blocklist, err := node.LoadBlocklist(filepath.Join(GetProfileDir(), node.BlocklistFile))
+	if err != nil {
+		log.Fatal(err)
+	}
+	blocklist.ReloadOnHangup(ctx)
+
+	h, lan, err := MakeHost(ctx, *port, cfg, blocklist)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close()
+	if lan != nil {
+		defer lan.Close()
+	}
+
+	opts, err := cfg.PubSubOptions(h, node.NewScoreBoard(cfg.Scoring))
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, pubsub.WithBlacklist(blocklist))
+	ps, err := node.NewPubSub(ctx, h, cfg.Router, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Reconnect.Enabled {
+		book, err := node.StartPeerBook(ctx, h, ps, filepath.Join(GetProfileDir(), node.PeerBookFile), cfg.Reconnect)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer book.Close()
+	}
+
+	var dir *node.Directory
+	if cfg.Directory.Enabled {
+		dir, err = node.JoinDirectory(ctx, ps, h.ID(), cfg.Directory)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer dir.Close()
+	}
+
+	var rdv *node.Rendezvous
+	if cfg.DHT.Enabled {
+		rdv, err = node.StartDHT(ctx, h, cfg.DHT)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rdv.Close()
+	}
+
+	srv := control.NewServer(ctx, h, ps, cfg, dir, rdv, cfg.PrivateNet.Describe(GetProfileDir()))
+	defer srv.Close()
+	for _, roomName := range cfg.Chat.Rooms {
+		if err := srv.Join(roomName); err != nil {
+			log.Println("Error joining chat room:", err)
+		}
+	}
+	go func() {
+		if err := srv.Serve(l); err != nil {
+			log.Println("Error serving control socket:", err)
+			cancel()
+		}
+	}()
+
+	if cfg.Gateway.Listen != "" {
+		token, err := cfg.Gateway.LoadToken(GetProfileDir())
+		if err != nil {
+			log.Fatal(err)
+		}
+		hs := &http.Server{Addr: cfg.Gateway.Listen, Handler: gateway.New(srv, cfg.Gateway, token)}
+		defer hs.Close()
+		go func() {
+			if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("Error serving HTTP gateway:", err)
+				cancel()
+			}
+		}()
+		tokenFrom := cfg.Gateway.TokenPath(GetProfileDir())
+		if cfg.Gateway.Token != "" {
+			tokenFrom = "the config"
+		}
+		log.Printf("HTTP gateway on %s, token in %s", gatewayURL(cfg.Gateway.Listen), tokenFrom)
+		// The token rides in the fragment, which the browser keeps to itself.
+		log.Printf("Open the web UI at %s/#token=%s", gatewayURL(cfg.Gateway.Listen), url.QueryEscape(token))
+	}
+
+	log.Printf("Daemon %s on the %s network, control socket %s", h.ID(), cfg.PrivateNet.Describe(GetProfileDir()), *socket)
+	for _, a := range node.DialAddrs(h) {
+		log.Printf(" - %s", a)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-srv.Done():
+	}
+	log.Println("Daemon stopping")
+}
+
+// gatewayURL is how a browser on this machine reaches the gateway.
+func gatewayURL(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "http://" + listen
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// runCtl runs one control API call against the daemon, for scripts and bots.
+//
+//	IPFS_CHAT4 ctl publish lobby "hello from cron"
+//	IPFS_CHAT4 ctl -json subscribe lobby
+func runCtl(args []string) {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultSocket(), "control socket path")
+	asJSON := fs.Bool("json", false, "print results and messages as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: IPFS_CHAT4 ctl [flags] <command> [args]")
+		fmt.Fprintln(fs.Output(), "commands: status, peers [room], join <room>, leave <room>, publish <room> <message>,")
+		fmt.Fprintln(fs.Output(), "          me <room> <action>, nick <name>, subscribe <room>..., stop")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := control.Dial(*socket)
+	if err != nil {
+		log.Fatalf("no daemon on %s: %v", *socket, err)
+	}
+	defer c.Close()
+
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	need := func(n int) {
+		if len(rest) < n {
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+	printJSON := func(v interface{}) {
+		b, _ := json.MarshalIndent(v, "", "  ")
+		fmt.Println(string(b))
+	}
+
+	switch cmd {
+	case "status":
+		st, err := c.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *asJSON {
+			printJSON(st)
+			return
+		}
+		fmt.Println("Peer ID:", st.ID)
+		fmt.Println("Network:", st.Network)
+		fmt.Println("Nickname:", st.Nick)
+		fmt.Println("Connected peers:", st.Peers)
+		fmt.Println("Running since:", st.Started.Format("2006-01-02 15:04:05"))
+		fmt.Println("Rooms:")
+		for _, r := range st.Rooms {
+			fmt.Printf(" - %-20s %d peers\n", r.Name, r.Peers)
+		}
+
+	case "peers":
+		room := ""
+		if len(rest) > 0 {
+			room = rest[0]
+		}
+		peers, err := c.Peers(room)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *asJSON {
+			printJSON(peers)
+			return
+		}
+		for _, p := range peers {
+			fmt.Printf("%s %-16s %-8s %s\n", p.ID, p.Nick, p.Status, strings.Join(p.Addrs, " "))
+		}
+
+	case "join", "leave":
+		need(1)
+		call := c.Join
+		if cmd == "leave" {
+			call = c.Leave
+		}
+		if err := call(rest[0]); err != nil {
+			log.Fatal(err)
+		}
+
+	case "publish", "me":
+		need(2)
+		if err := c.Publish(rest[0], strings.Join(rest[1:], " "), cmd == "me"); err != nil {
+			log.Fatal(err)
+		}
+
+	case "nick":
+		need(1)
+		if err := c.SetNick(rest[0]); err != nil {
+			log.Fatal(err)
+		}
+
+	case "subscribe":
+		need(1)
+		for _, room := range rest {
+			if err := c.Subscribe(room); err != nil {
+				log.Fatal(err)
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for m := range c.Messages() {
+			if *asJSON {
+				enc.Encode(m)
+			} else if m.Kind == node.KindAction {
+				fmt.Printf("[%s] * %s %s\n", m.Room, m.Nick, m.Text)
+			} else {
+				fmt.Printf("[%s] %s: %s\n", m.Room, m.Nick, m.Text)
+			}
+		}
+		log.Fatal("daemon went away")
+
+	case "stop":
+		if err := c.Shutdown(); err != nil {
+			log.Fatal(err)
+		}
+
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,387 @@
+// Package gateway serves the daemon's rooms over HTTP so browser front-ends
+// can use the node as their backend. Everything outside /api/ is the
+// embedded web UI, see ui.go.
+//
+//	GET    /api/status                     the node, as control.Status
+//	GET    /api/rooms                      the rooms we are in
+//	POST   /api/rooms/{room}               join a room
+//	DELETE /api/rooms/{room}               leave a room
+//	GET    /api/rooms/{room}/peers         who is in a room
+//	GET    /api/rooms/{room}/history       recent messages, ?limit=n&before=seq
+//	POST   /api/rooms/{room}/messages      publish {"message": "...", "action": false}
+//	GET    /api/rooms/{room}/ws            WebSocket: streams control.Message,
+//	                                       accepts {"message", "action"} to publish
+//
+// Every API call needs the token, as "Authorization: Bearer <token>" or, for
+// WebSockets which browsers cannot add headers to, as ?token=<token>.
+// Errors come back as {"error": "..."}.
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"IPFS_CHAT4/control"
+	"IPFS_CHAT4/node"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxBodySize bounds a request body or WebSocket frame.
+const maxBodySize = 64 << 10
+
+const (
+	// writeWait is how long a WebSocket write may take.
+	writeWait = 10 * time.Second
+	// pongWait is how long a WebSocket may stay silent before it is dropped;
+	// we ping well within it.
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// Gateway is an http.Handler for a daemon's control server.
+type Gateway struct {
+	srv      *control.Server
+	token    string
+	origins  []string
+	upgrader websocket.Upgrader
+	ui       http.Handler
+}
+
+// New serves srv to clients presenting token, allowing browsers from the
+// origins in cfg besides the gateway's own, which the embedded UI uses.
+func New(srv *control.Server, cfg node.GatewayConfig, token string) *Gateway {
+	g := &Gateway{srv: srv, token: token, origins: cfg.AllowedOrigins, ui: uiHandler()}
+	g.upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || sameOrigin(r, origin) || g.originAllowed(origin)
+		},
+	}
+	return g
+}
+
+// Publish is the body of a message POST and a WebSocket frame from the client.
+type Publish struct {
+	Message string `json:"message"`
+	Action  bool   `json:"action,omitempty"`
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		g.ui.ServeHTTP(w, r)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && g.originAllowed(origin) {
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		h.Set("Access-Control-Max-Age", "600")
+	}
+	// Preflights never carry credentials.
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !g.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chat"`)
+		writeError(w, http.StatusUnauthorized, "missing or wrong token")
+		return
+	}
+	g.route(w, r)
+}
+
+func (g *Gateway) originAllowed(origin string) bool {
+	for _, o := range g.origins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether origin is the host the request was sent to, as
+// for a page served by the gateway itself.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (g *Gateway) authorized(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) == 1
+}
+
+// route dispatches /api/... by hand; room names are path-escaped.
+func (g *Gateway) route(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/api/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "status":
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, g.srv.Status())
+		}
+		return
+	case len(parts) == 1 && parts[0] == "rooms":
+		if allow(w, r, http.MethodGet) {
+			rooms := g.srv.Status().Rooms
+			if rooms == nil {
+				rooms = []control.RoomStatus{}
+			}
+			writeJSON(w, http.StatusOK, rooms)
+		}
+		return
+	case len(parts) < 2 || len(parts) > 3 || parts[0] != "rooms":
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	room, err := url.PathUnescape(parts[1])
+	if err != nil || room == "" {
+		writeError(w, http.StatusBadRequest, "bad room name")
+		return
+	}
+	if len(parts) == 2 {
+		g.room(w, r, room)
+		return
+	}
+	switch parts[2] {
+	case "peers":
+		if allow(w, r, http.MethodGet) {
+			peers, err := g.srv.Peers(room)
+			if peers == nil {
+				peers = []control.Peer{}
+			}
+			reply(w, peers, err)
+		}
+	case "history":
+		if allow(w, r, http.MethodGet) {
+			g.history(w, r, room)
+		}
+	case "messages":
+		if allow(w, r, http.MethodPost) {
+			g.publish(w, r, room)
+		}
+	case "ws":
+		if allow(w, r, http.MethodGet) {
+			g.stream(w, r, room)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (g *Gateway) room(w http.ResponseWriter, r *http.Request, room string) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := node.ValidateRoomName(room); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := g.srv.Join(room); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, control.RoomParams{Room: room})
+	case http.MethodDelete:
+		reply(w, control.RoomParams{Room: room}, g.srv.Leave(room))
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (g *Gateway) history(w http.ResponseWriter, r *http.Request, room string) {
+	p := control.HistoryParams{Room: room}
+	q := r.URL.Query()
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		p.Limit = n
+	}
+	if s := q.Get("before"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "before must be a message seq")
+			return
+		}
+		p.Before = n
+	}
+	history, err := g.srv.History(p)
+	if history == nil {
+		history = []control.Message{}
+	}
+	reply(w, history, err)
+}
+
+func (g *Gateway) publish(w http.ResponseWriter, r *http.Request, room string) {
+	var p Publish
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&p); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be {\"message\": \"...\"}")
+		return
+	}
+	if p.Message == "" {
+		writeError(w, http.StatusBadRequest, "empty message")
+		return
+	}
+	err := g.srv.Publish(control.PublishParams{Room: room, Message: p.Message, Action: p.Action})
+	if err == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	reply(w, nil, err)
+}
+
+// stream runs a room's WebSocket. ?history=n sends the last n messages first,
+// so a reloaded page picks up where it was.
+func (g *Gateway) stream(w http.ResponseWriter, r *http.Request, room string) {
+	backlog := 0
+	if s := r.URL.Query().Get("history"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "history must be a positive number")
+			return
+		}
+		backlog = n
+	}
+	messages, stop, err := g.srv.Watch(room)
+	if err != nil {
+		reply(w, nil, err)
+		return
+	}
+	defer stop()
+	var history []control.Message
+	if backlog > 0 {
+		if history, err = g.srv.History(control.HistoryParams{Room: room, Limit: backlog}); err != nil {
+			reply(w, nil, err)
+			return
+		}
+	}
+
+	ws, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered.
+		return
+	}
+	defer ws.Close()
+
+	// gorilla/websocket allows one writer at a time; the read loop reports
+	// publish errors through the same lock.
+	var wmu sync.Mutex
+	write := func(v interface{}) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		ws.SetWriteDeadline(time.Now().Add(writeWait))
+		return ws.WriteJSON(v)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.SetReadLimit(maxBodySize)
+		ws.SetReadDeadline(time.Now().Add(pongWait))
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(pongWait))
+		})
+		for {
+			var p Publish
+			if err := ws.ReadJSON(&p); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					write(errorBody{Error: "frames must be {\"message\": \"...\"}"})
+					continue
+				}
+				return
+			}
+			err := g.srv.Publish(control.PublishParams{Room: room, Message: p.Message, Action: p.Action})
+			if err != nil {
+				write(errorBody{Error: err.Error()})
+			}
+		}
+	}()
+
+	for _, m := range history {
+		if write(m) != nil {
+			return
+		}
+	}
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				// We left the room.
+				wmu.Lock()
+				ws.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "left "+room),
+					time.Now().Add(writeWait))
+				wmu.Unlock()
+				return
+			}
+			if write(m) != nil {
+				return
+			}
+		case <-ping.C:
+			wmu.Lock()
+			err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			wmu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// reply writes v, or err with 404 for rooms we are not in and 400 otherwise.
+func reply(w http.ResponseWriter, v interface{}, err error) {
+	var notIn *control.NotInRoomError
+	switch {
+	case errors.As(err, &notIn):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, errorBody{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"IPFS_CHAT4/control"
+	"IPFS_CHAT4/node"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p"
+)
+
+const token = "secret"
+
+func startGateway(t *testing.T, ctx context.Context) *httptest.Server {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	cfg := node.DefaultConfig()
+	cfg.Chat.Nick = "alice"
+	cfg.Gateway.AllowedOrigins = []string{"http://localhost:3000"}
+	ps, err := node.NewPubSub(ctx, h, cfg.Router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := control.NewServer(ctx, h, ps, cfg, nil, nil, "public")
+	t.Cleanup(func() { srv.Close() })
+
+	ts := httptest.NewServer(New(srv, cfg.Gateway, token))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, method, url, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	for k, v := range header {
+		if v == "" {
+			req.Header.Del(k)
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestGateway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := startGateway(t, ctx)
+	api := ts.URL + "/api"
+
+	for _, auth := range []string{"", "Bearer wrong"} {
+		if resp := do(t, "GET", api+"/status", "", map[string]string{"Authorization": auth}); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Authorization %q: got %s", auth, resp.Status)
+		}
+	}
+	if resp := do(t, "GET", api+"/status?token="+token, "", map[string]string{"Authorization": ""}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("token in query: got %s", resp.Status)
+	}
+
+	// Preflights pass without a token, and only allowed origins get CORS headers.
+	resp := do(t, "OPTIONS", api+"/rooms/lobby", "", map[string]string{"Authorization": "", "Origin": "http://localhost:3000"})
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Fatalf("preflight: %s, allow origin %q", resp.Status, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	resp = do(t, "GET", api+"/rooms", "", map[string]string{"Origin": "http://evil.example"})
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("disallowed origin got CORS header %q", got)
+	}
+
+	if resp := do(t, "GET", api+"/rooms/lobby/history", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("history of a room we are not in: got %s", resp.Status)
+	}
+	if resp := do(t, "POST", api+"/rooms/lobby", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("join: got %s", resp.Status)
+	}
+
+	header := http.Header{"Origin": {"http://evil.example"}}
+	if _, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api, "http")+"/rooms/lobby/ws?token="+token, header); err == nil {
+		t.Fatal("WebSocket from a disallowed origin was accepted")
+	}
+	if resp := do(t, "POST", api+"/rooms/lobby/messages", `{"message": "hello"}`, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("publish: got %s", resp.Status)
+	}
+
+	// Browsers always send Origin; the embedded UI's is the gateway's own.
+	header = http.Header{"Origin": {ts.URL}}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api, "http")+"/rooms/lobby/ws?history=10&token="+token, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var m control.Message
+	if err := ws.ReadJSON(&m); err != nil || m.Text != "hello" || m.Nick != "alice" {
+		t.Fatalf("backlog: got %+v, %v", m, err)
+	}
+	if err := ws.WriteJSON(Publish{Message: "waves", Action: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.ReadJSON(&m); err != nil || m.Text != "waves" || m.Kind != node.KindAction || !m.Self {
+		t.Fatalf("live: got %+v, %v", m, err)
+	}
+
+	var history []control.Message
+	resp = do(t, "GET", api+"/rooms/lobby/history?limit=1", "", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil || len(history) != 1 || history[0].Text != "waves" {
+		t.Fatalf("history: got %+v, %v", history, err)
+	}
+	resp = do(t, "GET", api+"/rooms/lobby/history?before="+strconv.FormatUint(history[0].Seq, 10), "", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil || len(history) != 1 || history[0].Text != "hello" {
+		t.Fatalf("history page before: got %+v, %v", history, err)
+	}
+
+	if resp := do(t, "DELETE", api+"/rooms/lobby", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("leave: got %s", resp.Status)
+	}
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("leaving did not close the WebSocket: %v", err)
+	}
+}
+
+func TestUI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := startGateway(t, ctx)
+
+	// The page itself needs no token, only the API behind it.
+	for _, path := range []string{"/", "/app.js", "/style.css"} {
+		resp := do(t, "GET", ts.URL+path, "", map[string]string{"Authorization": ""})
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Security-Policy") == "" {
+			t.Fatalf("%s: got %s, CSP %q", path, resp.Status, resp.Header.Get("Content-Security-Policy"))
+		}
+	}
+	if resp := do(t, "POST", ts.URL+"/", "", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /: got %s", resp.Status)
+	}
+}
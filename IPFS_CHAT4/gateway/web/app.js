@@ -0,0 +1,337 @@
+// Single page chat UI for the node's gateway. Everything goes through the
+// REST API and one WebSocket for the room on screen; see gateway.go.
+"use strict";
+
+const PAGE = 50;             // messages per history page
+const PEER_REFRESH = 10000;  // ms between peer list refreshes
+const RECONNECT = 3000;      // ms before reopening a dropped WebSocket
+
+const $ = (id) => document.getElementById(id);
+
+const state = {
+  token: localStorage.getItem("chat.token") || "",
+  rooms: [],
+  current: "",
+  ws: null,
+  oldest: 0,  // seq of the oldest message shown, 0 when none
+};
+
+// The daemon prints a link with the token in the fragment, which browsers
+// never send to servers. Keep it and tidy the address bar.
+if (location.hash.startsWith("#token=")) {
+  state.token = decodeURIComponent(location.hash.slice("#token=".length));
+  localStorage.setItem("chat.token", state.token);
+  history.replaceState(null, "", location.pathname);
+}
+
+class Unauthorized extends Error {}
+
+async function api(method, path, body) {
+  const opts = { method, headers: { Authorization: "Bearer " + state.token } };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const resp = await fetch("/api" + path, opts);
+  if (resp.status === 401) {
+    askToken("The node refused that token.");
+    throw new Unauthorized();
+  }
+  if (resp.status === 204) {
+    return null;
+  }
+  const data = await resp.json();
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+const roomPath = (room) => "/rooms/" + encodeURIComponent(room);
+
+function askToken(reason) {
+  $("login-reason").textContent = reason;
+  if (!$("login").open) {
+    $("login").showModal();
+  }
+}
+
+$("token-form").addEventListener("submit", () => {
+  state.token = $("token").value.trim();
+  localStorage.setItem("chat.token", state.token);
+  $("token").value = "";
+  start();
+});
+
+// Messages.
+
+function timeOf(m) {
+  const d = new Date(m.time);
+  return d.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+}
+
+function renderMessage(m) {
+  const li = document.createElement("li");
+  li.dataset.seq = m.seq;
+  if (m.self) {
+    li.classList.add("self");
+  }
+  const time = document.createElement("time");
+  time.dateTime = m.time;
+  time.textContent = timeOf(m);
+  li.append(time);
+
+  const nick = document.createElement("span");
+  nick.className = "nick";
+  nick.textContent = m.nick;
+  nick.title = m.from;
+  if (m.kind === "action") {
+    li.classList.add("action");
+    li.append("* ", nick, " " + m.text);
+  } else {
+    li.append(nick, ": " + m.text);
+  }
+  return li;
+}
+
+function note(text, cls) {
+  const li = document.createElement("li");
+  li.className = cls || "system";
+  li.textContent = text;
+  $("log").append(li);
+  scrollDown(true);
+}
+
+function nearBottom() {
+  const box = $("messages");
+  return box.scrollHeight - box.scrollTop - box.clientHeight < 40;
+}
+
+function scrollDown(force) {
+  if (force || nearBottom()) {
+    $("messages").scrollTop = $("messages").scrollHeight;
+  }
+}
+
+function append(m) {
+  const stick = nearBottom();
+  $("log").append(renderMessage(m));
+  if (!state.oldest || m.seq < state.oldest) {
+    state.oldest = m.seq;
+  }
+  $("older").hidden = state.oldest <= 1;
+  scrollDown(stick);
+}
+
+async function loadOlder() {
+  const room = state.current;
+  const older = await api("GET", `${roomPath(room)}/history?limit=${PAGE}&before=${state.oldest}`);
+  if (room !== state.current) {
+    return;
+  }
+  // Keep the view where it was while the page grows above it.
+  const box = $("messages");
+  const height = box.scrollHeight;
+  $("log").prepend(...older.map(renderMessage));
+  box.scrollTop += box.scrollHeight - height;
+  if (older.length > 0) {
+    state.oldest = older[0].seq;
+  }
+  // The node only keeps so much; a short page is the end of it.
+  $("older").hidden = older.length < PAGE || state.oldest <= 1;
+}
+
+$("older").addEventListener("click", () => loadOlder().catch(showError));
+
+// The room on screen streams over a WebSocket, which first replays a page
+// of history.
+
+function openStream(room) {
+  const proto = location.protocol === "https:" ? "wss:" : "ws:";
+  const url = `${proto}//${location.host}/api${roomPath(room)}/ws?history=${PAGE}&token=${encodeURIComponent(state.token)}`;
+  const ws = new WebSocket(url);
+  state.ws = ws;
+
+  ws.onmessage = (ev) => {
+    const m = JSON.parse(ev.data);
+    if (m.error) {
+      note(m.error, "error");
+    } else if (m.room === state.current) {
+      append(m);
+    }
+  };
+  ws.onclose = (ev) => {
+    if (state.ws !== ws) {
+      return;  // we switched rooms
+    }
+    state.ws = null;
+    if (ev.code === 1000) {
+      note(`Left ${room}.`);
+      return;
+    }
+    note("Lost the connection to the node, retrying…");
+    setTimeout(() => {
+      if (state.current === room && !state.ws) {
+        showRoom(room);
+      }
+    }, RECONNECT);
+  };
+}
+
+function showRoom(room) {
+  if (state.ws) {
+    const old = state.ws;
+    state.ws = null;
+    old.close();
+  }
+  state.current = room;
+  state.oldest = 0;
+  $("log").replaceChildren();
+  $("older").hidden = true;
+  $("room-name").textContent = room || "No room";
+  $("leave").hidden = !room;
+  for (const el of $("send").elements) {
+    el.disabled = !room;
+  }
+  renderRooms();
+  refreshPeers().catch(showError);
+  if (room) {
+    openStream(room);
+    $("text").focus();
+  } else {
+    note("Join a room to start chatting.");
+  }
+}
+
+// Rooms and peers.
+
+function renderRooms() {
+  const items = state.rooms.map((r) => {
+    const li = document.createElement("li");
+    const b = document.createElement("button");
+    b.textContent = r.name;
+    const count = document.createElement("span");
+    count.className = "count";
+    count.textContent = r.peers;
+    count.title = `${r.peers} peers`;
+    b.append(count);
+    b.setAttribute("aria-current", r.name === state.current);
+    b.addEventListener("click", () => showRoom(r.name));
+    li.append(b);
+    return li;
+  });
+  $("rooms").replaceChildren(...items);
+}
+
+async function refreshRooms() {
+  state.rooms = await api("GET", "/rooms");
+  renderRooms();
+}
+
+async function refreshPeers() {
+  const room = state.current;
+  if (!room) {
+    $("peers").replaceChildren();
+    return;
+  }
+  const peers = await api("GET", `${roomPath(room)}/peers`);
+  if (room !== state.current) {
+    return;
+  }
+  $("peers").replaceChildren(...peers.map((p) => {
+    const li = document.createElement("li");
+    li.textContent = p.nick || p.id.slice(-8);
+    li.title = p.id;
+    if (p.status) {
+      const s = document.createElement("span");
+      s.className = "status";
+      s.textContent = " " + p.status;
+      li.append(s);
+    }
+    return li;
+  }));
+}
+
+$("join").addEventListener("submit", async (ev) => {
+  ev.preventDefault();
+  const name = $("join-name").value.trim();
+  try {
+    await api("POST", roomPath(name));
+    $("join-name").value = "";
+    await refreshRooms();
+    showRoom(name);
+  } catch (err) {
+    showError(err);
+  }
+});
+
+$("leave").addEventListener("click", async () => {
+  try {
+    await api("DELETE", roomPath(state.current));
+    await refreshRooms();
+    showRoom(state.rooms.length ? state.rooms[0].name : "");
+  } catch (err) {
+    showError(err);
+  }
+});
+
+// Sending goes over the WebSocket when it is up, so errors come back in
+// order with the messages, and falls back to a POST.
+$("send").addEventListener("submit", async (ev) => {
+  ev.preventDefault();
+  const text = $("text").value;
+  if (!text.trim()) {
+    return;
+  }
+  const msg = { message: text };
+  if (text.startsWith("/me ")) {
+    msg.message = text.slice(4);
+    msg.action = true;
+  } else if (text.startsWith("//")) {
+    msg.message = text.slice(1);
+  }
+  $("text").value = "";
+  try {
+    if (state.ws && state.ws.readyState === WebSocket.OPEN) {
+      state.ws.send(JSON.stringify(msg));
+    } else {
+      await api("POST", `${roomPath(state.current)}/messages`, msg);
+    }
+  } catch (err) {
+    $("text").value = text;
+    showError(err);
+  }
+});
+
+function showError(err) {
+  if (!(err instanceof Unauthorized)) {
+    note(err.message, "error");
+  }
+}
+
+async function start() {
+  if (!state.token) {
+    askToken("This node needs its gateway token.");
+    return;
+  }
+  try {
+    const st = await api("GET", "/status");
+    $("status").textContent = `${st.nick} on the ${st.network} network, ${st.peers} peers`;
+    $("status").title = st.id;
+    await refreshRooms();
+    const keep = state.rooms.some((r) => r.name === state.current);
+    showRoom(keep ? state.current : (state.rooms.length ? state.rooms[0].name : ""));
+  } catch (err) {
+    if (!(err instanceof Unauthorized)) {
+      $("status").textContent = "cannot reach the node: " + err.message;
+    }
+  }
+}
+
+setInterval(() => {
+  if (state.token && !$("login").open) {
+    refreshRooms().then(refreshPeers).catch(() => {});
+  }
+}, PEER_REFRESH);
+
+start();
@@ -0,0 +1,484 @@
+// Package lineedit reads lines from a terminal with cursor movement,
+// persistent history, tab completion and multi-line input. When the input is
+// not a terminal it reads plain lines, so the chat apps still work in pipes.
+package lineedit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"IPFS_CHAT4/node"
+
+	"golang.org/x/term"
+)
+
+// ErrInterrupt is returned by ReadLine when the user presses Ctrl-C.
+var ErrInterrupt = errors.New("interrupted")
+
+// HistoryFile is the name of the input history in a profile directory.
+const HistoryFile = "history"
+
+// MaxHistory is how many entries are kept in memory and on disk.
+const MaxHistory = 1000
+
+// continuationPrompt is shown for the second and later lines of a multi-line entry.
+const continuationPrompt = "... "
+
+// Key codes the editor acts on.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCtrlK     = 11
+	keyCR        = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEsc       = 27
+	keyDelete    = 127
+)
+
+// Editor reads lines. Printf may be called from any goroutine, also while
+// ReadLine waits, and keeps the line being typed intact below the output.
+type Editor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// keys is set when in delivers keystrokes rather than lines. fd is then
+	// the terminal to switch to raw mode, or -1 when the caller already did.
+	keys bool
+	fd   int
+
+	// Complete returns the full lines the text typed so far could become.
+	// It is called on Tab and may be nil.
+	Complete func(line string) []string
+	// Plain reads whole lines as the terminal delivers them, without raw
+	// mode or redrawing, so screen readers only hear what was typed and
+	// printed. Tab completion and history keys are then off.
+	Plain bool
+
+	history     []string
+	historyFile *os.File
+
+	mu      sync.Mutex
+	reading bool
+	prompt  string
+	buf     []rune
+	pos     int
+}
+
+// New edits lines typed on in and echoes to out. History is loaded from and
+// appended to historyPath; an empty path keeps history in memory only.
+func New(in, out *os.File, historyPath string) (*Editor, error) {
+	keys := term.IsTerminal(int(in.Fd())) && term.IsTerminal(int(out.Fd()))
+	e := newEditor(in, out, keys, int(in.Fd()))
+	if historyPath == "" {
+		return e, nil
+	}
+
+	history, err := loadHistory(historyPath)
+	if err != nil {
+		return nil, err
+	}
+	e.history = history
+	f, err := os.OpenFile(historyPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	e.historyFile = f
+	return e, nil
+}
+
+func newEditor(in io.Reader, out io.Writer, keys bool, fd int) *Editor {
+	return &Editor{in: bufio.NewReader(in), out: out, keys: keys, fd: fd}
+}
+
+// Close closes the history file.
+func (e *Editor) Close() error {
+	if e.historyFile == nil {
+		return nil
+	}
+	return e.historyFile.Close()
+}
+
+// History returns the entries typed so far, oldest first.
+func (e *Editor) History() []string {
+	return append([]string(nil), e.history...)
+}
+
+// Printf writes above the line being edited and redraws it afterwards.
+func (e *Editor) Printf(format string, args ...interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	text := fmt.Sprintf(format, args...)
+	if !e.reading {
+		io.WriteString(e.out, text)
+		return
+	}
+	io.WriteString(e.out, "\r\x1b[K"+strings.ReplaceAll(text, "\n", "\r\n"))
+	e.redraw()
+}
+
+// ReadLine shows prompt and returns the line typed, without the newline.
+// Ending a line with a backslash, or pressing Alt-Enter, continues the entry
+// on the next line; the lines come back joined by "\n". Ctrl-C returns
+// ErrInterrupt and Ctrl-D on an empty line returns io.EOF.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	var lines []string
+	for {
+		line, more, err := e.readOne(prompt)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+		if !more {
+			break
+		}
+		prompt = continuationPrompt
+	}
+
+	entry := strings.Join(lines, "\n")
+	e.addHistory(entry)
+	return entry, nil
+}
+
+// readOne reads one physical line and reports whether the entry continues.
+func (e *Editor) readOne(prompt string) (string, bool, error) {
+	if !e.keys || e.Plain {
+		return e.readPlain(prompt)
+	}
+	if e.fd >= 0 {
+		state, err := term.MakeRaw(e.fd)
+		if err != nil {
+			return e.readPlain(prompt)
+		}
+		defer term.Restore(e.fd, state)
+	}
+
+	e.mu.Lock()
+	e.reading, e.prompt, e.buf, e.pos = true, prompt, nil, 0
+	e.redraw()
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.reading = false
+		e.mu.Unlock()
+	}()
+
+	return e.edit()
+}
+
+// readPlain reads a line without editing, for pipes and files.
+func (e *Editor) readPlain(prompt string) (string, bool, error) {
+	io.WriteString(e.out, prompt)
+	line, err := e.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasSuffix(line, `\`) {
+		return strings.TrimSuffix(line, `\`), true, nil
+	}
+	return line, false, nil
+}
+
+// Keys decoded from escape sequences, negative so they never clash with runes.
+const (
+	keyUp rune = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDeleteForward
+	keyAltEnter
+	keyUnknown
+)
+
+// edit handles keys until Enter, Ctrl-C or Ctrl-D. The caller holds the terminal in raw mode.
+func (e *Editor) edit() (string, bool, error) {
+	// histIdx is the history entry shown, len(history) for the line being typed.
+	histIdx := len(e.history)
+	var draft []rune
+
+	for {
+		// Read outside the lock so Printf keeps working while we wait for a key.
+		r, err := e.readKey()
+		if err != nil {
+			return "", false, err
+		}
+
+		e.mu.Lock()
+		switch r {
+		case keyCR, keyLF, keyAltEnter:
+			line := string(e.buf)
+			more := r == keyAltEnter || strings.HasSuffix(line, `\`)
+			if r != keyAltEnter {
+				line = strings.TrimSuffix(line, `\`)
+			}
+			e.pos = len(e.buf)
+			e.redraw()
+			io.WriteString(e.out, "\r\n")
+			e.mu.Unlock()
+			return line, more, nil
+
+		case keyCtrlC:
+			io.WriteString(e.out, "^C\r\n")
+			e.mu.Unlock()
+			return "", false, ErrInterrupt
+
+		case keyCtrlD:
+			if len(e.buf) == 0 {
+				io.WriteString(e.out, "\r\n")
+				e.mu.Unlock()
+				return "", false, io.EOF
+			}
+			e.deleteForward()
+
+		case keyBackspace, keyDelete:
+			if e.pos > 0 {
+				e.pos--
+				e.deleteForward()
+			}
+		case keyDeleteForward:
+			e.deleteForward()
+		case keyCtrlA, keyHome:
+			e.pos = 0
+		case keyCtrlE, keyEnd:
+			e.pos = len(e.buf)
+		case keyCtrlB, keyLeft:
+			e.move(-1)
+		case keyCtrlF, keyRight:
+			e.move(1)
+		case keyCtrlK:
+			e.buf = e.buf[:e.pos]
+		case keyCtrlU:
+			e.buf = append([]rune(nil), e.buf[e.pos:]...)
+			e.pos = 0
+		case keyCtrlW:
+			e.deleteWord()
+		case keyCtrlP, keyUp:
+			histIdx, draft = e.recall(histIdx-1, histIdx, draft)
+		case keyCtrlN, keyDown:
+			histIdx, draft = e.recall(histIdx+1, histIdx, draft)
+		case keyTab:
+			e.complete()
+
+		default:
+			if r > 0 && unicode.IsPrint(r) {
+				e.insert(r)
+			}
+		}
+		e.redraw()
+		e.mu.Unlock()
+	}
+}
+
+// readKey reads one key, decoding the escape sequences of arrows and friends.
+func (e *Editor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != keyEsc {
+		return r, err
+	}
+
+	b, err := e.in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch b {
+	case keyCR, keyLF:
+		return keyAltEnter, nil
+	case '[', 'O':
+	default:
+		return keyUnknown, nil
+	}
+
+	// CSI: parameter bytes up to a final byte in @ to ~.
+	var params []byte
+	for {
+		c, err := e.in.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c < 0x40 || c > 0x7e {
+			params = append(params, c)
+			continue
+		}
+		switch p := string(params); {
+		case c == 'A':
+			return keyUp, nil
+		case c == 'B':
+			return keyDown, nil
+		case c == 'C':
+			return keyRight, nil
+		case c == 'D':
+			return keyLeft, nil
+		case c == 'H', c == '~' && (p == "1" || p == "7"):
+			return keyHome, nil
+		case c == 'F', c == '~' && (p == "4" || p == "8"):
+			return keyEnd, nil
+		case c == '~' && p == "3":
+			return keyDeleteForward, nil
+		}
+		return keyUnknown, nil
+	}
+}
+
+func (e *Editor) insert(r rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = r
+	e.pos++
+}
+
+func (e *Editor) deleteForward() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+}
+
+// deleteWord deletes back to the start of the word before the cursor.
+func (e *Editor) deleteWord() {
+	start := e.pos
+	for start > 0 && e.buf[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && e.buf[start-1] != ' ' {
+		start--
+	}
+	e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+	e.pos = start
+}
+
+func (e *Editor) move(by int) {
+	if p := e.pos + by; p >= 0 && p <= len(e.buf) {
+		e.pos = p
+	}
+}
+
+// recall shows history entry idx, remembering what was being typed when
+// leaving the bottom of the history and restoring it on the way back.
+func (e *Editor) recall(idx, from int, draft []rune) (int, []rune) {
+	if idx < 0 || idx > len(e.history) {
+		return from, draft
+	}
+	if from == len(e.history) {
+		draft = append([]rune(nil), e.buf...)
+	}
+	if idx == len(e.history) {
+		e.buf = append([]rune(nil), draft...)
+	} else {
+		e.buf = []rune(e.history[idx])
+	}
+	e.pos = len(e.buf)
+	return idx, draft
+}
+
+// complete fills in as much of the line as all candidates agree on, and lists
+// them when that adds nothing.
+func (e *Editor) complete() {
+	if e.Complete == nil || e.pos != len(e.buf) {
+		return
+	}
+	line := string(e.buf)
+	candidates := e.Complete(line)
+	if len(candidates) == 0 {
+		return
+	}
+
+	prefix := node.CommonPrefix(candidates)
+	if len(prefix) > len(line) {
+		e.buf = []rune(prefix)
+		e.pos = len(e.buf)
+		return
+	}
+	io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+}
+
+// redraw repaints the prompt and the line and puts the cursor back.
+// The caller holds mu.
+func (e *Editor) redraw() {
+	shown := strings.ReplaceAll(string(e.buf), "\n", "↵")
+	io.WriteString(e.out, "\r\x1b[K"+e.prompt+shown)
+	if back := len(e.buf) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *Editor) addHistory(entry string) {
+	if strings.TrimSpace(entry) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == entry {
+		return
+	}
+	e.history = append(e.history, entry)
+	if len(e.history) > MaxHistory {
+		e.history = e.history[len(e.history)-MaxHistory:]
+	}
+	if e.historyFile != nil {
+		io.WriteString(e.historyFile, escapeEntry(entry)+"\n")
+	}
+}
+
+// loadHistory reads the last MaxHistory entries of the history file.
+func loadHistory(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var history []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			history = append(history, unescapeEntry(line))
+		}
+	}
+	if len(history) > MaxHistory {
+		history = history[len(history)-MaxHistory:]
+		// Keep the file from growing without bound.
+		var b strings.Builder
+		for _, h := range history {
+			b.WriteString(escapeEntry(h) + "\n")
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+			return nil, err
+		}
+	}
+	return history, nil
+}
+
+// escapeEntry keeps a multi-line entry on one line of the history file.
+func escapeEntry(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func unescapeEntry(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,149 @@
+package lineedit
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEditing(t *testing.T) {
+	for _, tc := range []struct {
+		name, keys string
+		want       []string
+		err        error
+	}{
+		{name: "plain", keys: "hello world\r", want: []string{"hello world"}},
+		{name: "cursor", keys: "helo\x1b[D\x1b[Dl\x01>\x05!\r", want: []string{">hello!"}},
+		{name: "home end", keys: "bc\x1b[Ha\x1b[F.\r", want: []string{"abc."}},
+		{name: "backspace delete", keys: "abxc\x7f\x7fc\x1b[D\x1b[3~d\r", want: []string{"abd"}},
+		{name: "kill", keys: "one two three\x17\x17x\x01\x0b\r", want: []string{""}},
+		{name: "kill to start", keys: "one two\x1b[D\x1b[D\x1b[D\x15\r", want: []string{"two"}},
+		{name: "history", keys: "first\rsecond\r\x1b[A\x1b[A!\r", want: []string{"first", "second", "first!"}},
+		{name: "history keeps draft", keys: "old\rdra\x1b[A\x1b[Bft\r", want: []string{"old", "draft"}},
+		{name: "continuation", keys: "line one\\\rline two\r", want: []string{"line one\nline two"}},
+		{name: "alt enter", keys: "a\x1b\rb\r", want: []string{"a\nb"}},
+		{name: "recall multi-line", keys: "a\\\rb\r\x1b[A\r", want: []string{"a\nb", "a\nb"}},
+		{name: "interrupt", keys: "typing\x03", err: ErrInterrupt},
+		{name: "eof on empty line", keys: "\x04", err: io.EOF},
+		{name: "ctrl-d deletes", keys: "ab\x01\x04\r", want: []string{"b"}},
+		{name: "unicode", keys: "héllo\x1b[D\x7f\r", want: []string{"hélo"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			e := newEditor(strings.NewReader(tc.keys), &out, true, -1)
+			var got []string
+			for range tc.keys {
+				line, err := e.ReadLine("> ")
+				if err != nil {
+					if tc.err != nil && !errors.Is(err, tc.err) {
+						t.Fatalf("error %v, want %v", err, tc.err)
+					}
+					if tc.err == nil && err != io.EOF {
+						t.Fatal(err)
+					}
+					break
+				}
+				got = append(got, line)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("read %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	var out strings.Builder
+	e := newEditor(strings.NewReader("/j\t\r/l\t\t\r"), &out, true, -1)
+	e.Complete = func(line string) []string {
+		var c []string
+		for _, s := range []string{"/join ", "/leave ", "/list "} {
+			if strings.HasPrefix(s, line) {
+				c = append(c, s)
+			}
+		}
+		return c
+	}
+
+	if line, _ := e.ReadLine("> "); line != "/join " {
+		t.Fatalf("single candidate completed to %q", line)
+	}
+	// Two candidates: nothing to fill in, so the second tab lists them.
+	if line, _ := e.ReadLine("> "); line != "/l" {
+		t.Fatalf("ambiguous completion changed the line to %q", line)
+	}
+	if !strings.Contains(out.String(), "/leave   /list ") {
+		t.Fatalf("candidates not listed:\n%q", out.String())
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HistoryFile)
+
+	e, err := New(os.Stdin, os.Stdout, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.addHistory("one")
+	e.addHistory("one")
+	e.addHistory(`two\lines` + "\n" + "here")
+	e.addHistory("  ")
+	e.Close()
+
+	e, err = New(os.Stdin, os.Stdout, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	want := []string{"one", `two\lines` + "\n" + "here"}
+	if got := e.History(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("history %q, want %q", got, want)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("history file mode %v", fi.Mode().Perm())
+	}
+}
+
+func TestPlainInput(t *testing.T) {
+	var out strings.Builder
+	e := newEditor(strings.NewReader("1\nhello there\ncontinued\\\nline\nlast"), &out, false, -1)
+	var got []string
+	for {
+		line, err := e.ReadLine("> ")
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, line)
+	}
+	want := []string{"1", "hello there", "continued\nline", "last"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("read %q, want %q", got, want)
+	}
+}
+
+func TestPlainMode(t *testing.T) {
+	var out strings.Builder
+	e := newEditor(strings.NewReader("hi\nthere\n"), &out, true, -1)
+	e.Plain = true
+	e.Printf("before\n")
+	for _, want := range []string{"hi", "there"} {
+		if line, err := e.ReadLine("> "); err != nil || line != want {
+			t.Fatalf("read %q, %v, want %q", line, err, want)
+		}
+	}
+	e.Printf("after\n")
+	if got := out.String(); got != "before\n> > after\n" {
+		t.Fatalf("output %q has more than the prompts and lines printed", got)
+	}
+}
@@ -5,20 +5,27 @@ import(
 	"context"
 
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
-	"encoding/json"
 	"github.com/multiformats/go-multiaddr"
 	"path/filepath"
-	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"time"
+	"IPFS_CHAT4/lineedit"
+	"IPFS_CHAT4/node"
 /*
  * FOR BROKEN ENCRYPTION / USER ACCOUNT AUTH LOGIC
 	"golang.org/x/crypto/ssh/terminal"
@@ -33,45 +40,171 @@ import(
 
 
 func main() {
+    if len(os.Args) > 1 && os.Args[1] == "relay" {
+        runRelay(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "keygen" {
+        runKeygen(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "config" {
+        runConfig(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "daemon" {
+        runDaemon(os.Args[2:], false)
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "web" {
+        runDaemon(os.Args[2:], true)
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "ctl" {
+        runCtl(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "attach" {
+        runAttach(os.Args[2:])
+        return
+    }
+
+    cfg, err := node.LoadConfig(GetConfigFile())
+    if err != nil {
+        log.Fatal(err)
+    }
+
     sourcePort := flag.Int("sp", 0, "Source port number")
+    cfg.BindFlags(flag.CommandLine)
     flag.Parse()
 
+    if err := cfg.Validate(); err != nil {
+        log.Fatal(err)
+    }
+    cfg.Log.Apply()
+    if err := setupOutput(cfg.Chat); err != nil {
+        log.Fatal(err)
+    }
+
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
-    h, err := MakeHost(*sourcePort)
+    blocklist, err := node.LoadBlocklist(filepath.Join(GetProfileDir(), node.BlocklistFile))
+    if err != nil {
+        log.Fatal(err)
+    }
+    blocklist.ReloadOnHangup(ctx)
+
+    h, lan, err := MakeHost(ctx, *sourcePort, cfg, blocklist)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if lan != nil {
+        defer lan.Close()
+    }
+
+    reach, err := node.WatchReachability(h)
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer reach.Close()
+
+    scores := node.NewScoreBoard(cfg.Scoring)
+    opts, err := cfg.PubSubOptions(h, scores)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    opts = append(opts, pubsub.WithBlacklist(blocklist))
+    ps, err := node.NewPubSub(ctx, h, cfg.Router, opts...)
     if err != nil {
         log.Fatal(err)
     }
 
-    ps, err := pubsub.NewGossipSub(ctx, h)
+    if cfg.Reconnect.Enabled {
+        book, err := node.StartPeerBook(ctx, h, ps, filepath.Join(GetProfileDir(), node.PeerBookFile), cfg.Reconnect)
+        if err != nil {
+            log.Fatal(err)
+        }
+        defer book.Close()
+    }
+
+    var dir *node.Directory
+    if cfg.Directory.Enabled {
+        dir, err = node.JoinDirectory(ctx, ps, h.ID(), cfg.Directory)
+        if err != nil {
+            log.Fatal(err)
+        }
+        defer dir.Close()
+    }
+
+    var rdv *node.Rendezvous
+    if cfg.DHT.Enabled {
+        rdv, err = node.StartDHT(ctx, h, cfg.DHT)
+        if err != nil {
+            log.Fatal(err)
+        }
+        defer rdv.Close()
+    }
+
+    ed, err := lineedit.New(os.Stdin, os.Stdout, filepath.Join(GetProfileDir(), lineedit.HistoryFile))
     if err != nil {
         log.Fatal(err)
     }
+    defer ed.Close()
+    ed.Plain = plain
+    if lan != nil {
+        go printLANEvents(lan, ed)
+    }
 
-    var chatRoom *ChatRoom
+    sess := newChatSession(ctx, h, ps, cfg, dir, rdv, blocklist, ed)
+    ed.Complete = sess.commands.Complete
+    for _, roomName := range cfg.Chat.Rooms {
+        if _, err := sess.Join(roomName); err != nil {
+            log.Println("Error joining chat room:", err)
+            continue
+        }
+        fmt.Println("Joined chat room:", roomName)
+    }
+
+    var network string
+    if cfg.PrivateNet.Enabled {
+        network = "Network: " + cfg.PrivateNet.Describe(GetProfileDir())
+    }
 
     // Display the main menu
     for {
-	ChatMenuDisplay()
+	ChatMenuDisplay(network)
 
-        var choice int
-        fmt.Print("Enter your choice: ")
-        fmt.Scanln(&choice)
+        line, err := ed.ReadLine("Enter your choice: ")
+        if err == lineedit.ErrInterrupt {
+            continue
+        } else if err != nil {
+            // Ctrl-D or the end of piped input
+            fmt.Println("Exiting application.")
+            return
+        }
+        choice, err := strconv.Atoi(strings.TrimSpace(line))
+        if err != nil {
+            choice = -1
+        }
 
         switch choice {
         case 1:
             // Join Chat room / subscribe to topic
-            fmt.Print("Enter chat room name: ")
-            var roomName string
-            fmt.Scanln(&roomName)
-
-            fmt.Print("Enter your nickname: ")
-            var nickname string
-            fmt.Scanln(&nickname)
-
-            chatRoom, err = JoinChatRoom(ctx, ps, h.ID(), nickname, roomName)
+            roomName, err := ed.ReadLine("Enter chat room name: ")
             if err != nil {
+                continue
+            }
+            roomName = strings.TrimSpace(roomName)
+            if err := node.ValidateRoomName(roomName); err != nil {
+                log.Println("Error joining chat room:", err)
+                continue
+            }
+            if err := askNick(sess, ed); err != nil {
+                continue
+            }
+            if _, err := sess.Join(roomName); err != nil {
                 log.Println("Error joining chat room:", err)
                 continue
             }
@@ -79,27 +212,51 @@ func main() {
 
         case 2:
             // Publish message
+            chatRoom := sess.Current()
             if chatRoom == nil {
                 fmt.Println("Please join a chat room first.")
                 continue
             }
 
-            fmt.Print("Enter message: ")
-            var message string
-            fmt.Scanln(&message)
-
-            err := chatRoom.Publish(message)
+            message, err := ed.ReadLine("Enter message: ")
             if err != nil {
+                continue
+            }
+            if err := chatRoom.Publish(message); err != nil {
                 log.Println("Error publishing message:", err)
             }
 
         case 3:
             // Start Interactive Chat
-            if chatRoom == nil {
-                fmt.Println("Please join a chat room first.")
+            startChatInterface(sess)
+
+        case 4:
+            // Show node status
+            printStatus(h, cfg, scores, reach, lan, rdv, sess.Current())
+
+        case 5:
+            // Browse the room directory and join one
+            if dir == nil {
+                fmt.Println("The room directory is disabled.")
+                continue
+            }
+            roomName := browseRooms(dir, ed)
+            if roomName == "" {
                 continue
             }
-            startChatInterface(ctx, chatRoom)
+
+            if err := askNick(sess, ed); err != nil {
+                continue
+            }
+            if _, err := sess.Join(roomName); err != nil {
+                log.Println("Error joining chat room:", err)
+                continue
+            }
+            fmt.Println("Joined chat room:", roomName)
+
+        case 6:
+            // Show connection and resource limits
+            printLimits(h, cfg.Limits)
 
         case 0:
             // Exit
@@ -112,32 +269,244 @@ func main() {
     }
 }
 
-func ChatMenuDisplay() {
+// menuOptions are the main menu's entries in the order shown.
+var menuOptions = []struct {
+    key, label string
+}{
+    {"1", "Join Chat Room"},
+    {"2", "Publish Message to Chat Room"},
+    {"3", "Interactive Chat (EXPERIMENTAL)"},
+    {"4", "Node Status"},
+    {"5", "Browse Rooms"},
+    {"6", "Limits"},
+    {"0", "Exit"},
+}
 
-    // Yellow lines
-    fmt.Println("\033[1;33m---------------------------------------------\033[0m")
-    // Bold white title
-    fmt.Println("\033[1;37mDangerous Net | LIBP2P Chat Application\033[0m")
-    // Yellow lines
-    fmt.Println("\033[1;33m=============================================\033[0m")
+func ChatMenuDisplay(network string) {
+    menuRule("-")
+    fmt.Println(theme.Render(node.RoleTitle, "Dangerous Net | LIBP2P Chat Application"))
+    if network != "" {
+        // In the warning colour so nobody mistakes which network they are on
+        fmt.Println(theme.Render(node.RoleWarning, network))
+    }
+    menuRule("=")
 
-    // Normal text for the options
-    fmt.Println("\033[1;32m>\033[0;32m 1.\033[0m Join Chat Room")
-    fmt.Println("\033[1;32m>\033[0;32m 2.\033[0m Publish Message to Chat Room")
-    fmt.Println("\033[1;32m>\033[0;32m 3.\033[0m Interactive Chat \033[1;32m(EXPERIMENTAL)\033[0m")
+    for _, o := range menuOptions {
+        if plain {
+            fmt.Printf("option %s: %s\n", o.key, o.label)
+            continue
+        }
+        fmt.Println(theme.Render(node.RoleMenu, "> "+o.key+".") + " " + o.label)
+    }
 
-    fmt.Println("\033[1;32m>\033[0;32m 0.\033[0m Exit")
+    menuRule("=")
+}
 
-    // Yellow lines
-    fmt.Println("\033[1;33m=============================================\033[0m")
+// askNick asks for a nickname, keeping the current one when the answer is empty.
+func askNick(sess *chatSession, ed *lineedit.Editor) error {
+    nickname, err := ed.ReadLine(fmt.Sprintf("Enter your nickname [%s]: ", sess.Nick()))
+    if err != nil {
+        return err
+    }
+    if nickname = strings.TrimSpace(nickname); nickname == "" {
+        return nil
+    }
+    if err := sess.SetNick(nickname); err != nil {
+        log.Println("Error setting nickname:", err)
+        return err
+    }
+    return nil
 }
 
-func GetConfigDir() string {
-    homeDir, err := os.UserHomeDir()
+// browseRooms lists the rooms in the directory and returns the one picked, or "" to go back.
+func browseRooms(dir *node.Directory, ed *lineedit.Editor) string {
+    rooms := dir.Rooms()
+    if len(rooms) == 0 {
+        fmt.Println("No rooms announced yet.")
+        return ""
+    }
+
+    for i, r := range rooms {
+        access := "public"
+        if !r.Public {
+            access = "private"
+        }
+        fmt.Printf("%2d. %-20s %3d members  %-7s %s\n", i+1, clean(r.Name), r.Members, access, clean(r.Description))
+    }
+
+    line, err := ed.ReadLine("Enter room number to join (0 to go back): ")
+    if err != nil {
+        return ""
+    }
+    choice, err := strconv.Atoi(strings.TrimSpace(line))
+    if err != nil || choice < 1 || choice > len(rooms) {
+        return ""
+    }
+    return rooms[choice-1].Name
+}
+
+// printRoster lists who is in the room, what they are doing and when we last heard from them.
+func printRoster(chatRoom *node.ChatRoom) {
+    printInfo("In %s:", chatRoom.Name())
+    for _, e := range chatRoom.Roster() {
+        seen := "now"
+        if !e.Self {
+            seen = time.Since(e.LastSeen).Round(time.Second).String() + " ago"
+        }
+        printInfo(" - %-16s %-8s %-10s %s", clean(e.Nick), clean(e.Status), seen, e.ID.ShortString())
+    }
+}
+
+// printLANEvents announces LAN peers coming and going for as long as discovery runs.
+func printLANEvents(lan *node.LANDiscovery, ed *lineedit.Editor) {
+    for ev := range lan.Events() {
+        ed.Printf("%s", formatNotice(fmt.Sprintf("LAN peer %s %s", ev.Peer.ID.ShortString(), ev.Kind)))
+    }
+}
+
+// printStatus shows who we are, the addresses other peers can dial us on and
+// which pubsub router is running.
+func printStatus(h host.Host, cfg node.Config, scores *node.ScoreBoard, reach *node.Reachability, lan *node.LANDiscovery, rdv *node.Rendezvous, chatRoom *node.ChatRoom) {
+    fmt.Println("Peer ID:", h.ID())
+    fmt.Println("Dial addresses, for /connect on another node:")
+    for _, a := range node.DialAddrs(h) {
+        fmt.Printf(" - %s\n", a)
+    }
+    fmt.Println("Connected peers:", len(h.Network().Peers()))
+    fmt.Println("Network:", cfg.PrivateNet.Describe(GetProfileDir()))
+    fmt.Println("Reachability:", reach.Describe())
+    if lan != nil {
+        fmt.Printf("LAN peers (mDNS %q):\n", cfg.MDNS.ServiceTag)
+        for _, pi := range lan.Peers() {
+            fmt.Printf(" - %s %v\n", pi.ID, pi.Addrs)
+        }
+    } else {
+        fmt.Println("LAN discovery: off")
+    }
+    if rdv != nil {
+        fmt.Printf("DHT: %s mode, %d peers in routing table\n", cfg.DHT.Mode, rdv.RoutingTableSize())
+    } else {
+        fmt.Println("DHT: off")
+    }
+    fmt.Println("Router:", cfg.Router.Describe())
+    if chatRoom != nil {
+        fmt.Printf("Chat room: %s (%d peers)\n", chatRoom.Name(), len(chatRoom.ListPeers()))
+    }
+
+    if !cfg.Scoring.Enabled() {
+        fmt.Println("Peer scoring: off")
+        return
+    }
+    fmt.Println("Peer scoring:", cfg.Scoring.Profile)
+    for _, ps := range scores.Scores() {
+        fmt.Printf(" - %s %8.2f %-10s %s\n", ps.ID, ps.Score, ps.Status, ps.Reason)
+    }
+}
+
+// printLimits shows the connection manager watermarks and resource use against the configured limits.
+func printLimits(h host.Host, limits node.LimitsConfig) {
+    fmt.Printf("Connections: %d (trim to %d above %d, grace %s)\n",
+        len(h.Network().Conns()), limits.LowWater, limits.HighWater, limits.GracePeriod)
+    fmt.Printf("%-28s %15s %15s %11s %21s\n", "Scope", "Conns", "Streams", "FD", "Memory")
+    for _, u := range limits.Usage(h) {
+        fmt.Printf("%-28s %15s %15s %11s %21s\n", u.Scope,
+            usedOf(int64(u.Conns), int64(u.ConnsLimit)),
+            usedOf(int64(u.Streams), int64(u.StreamsLimit)),
+            usedOf(int64(u.FD), int64(u.FDLimit)),
+            usedOf(u.Memory, u.MemoryLimit))
+    }
+}
+
+// usedOf formats a used/limit pair, where a limit of -1 means unlimited.
+func usedOf(used, limit int64) string {
+    if limit < 0 {
+        return fmt.Sprintf("%d/unlimited", used)
+    }
+    return fmt.Sprintf("%d/%d", used, limit)
+}
+
+// runKeygen writes a new swarm key for private network mode and says how to share it.
+func runKeygen(args []string) {
+    cfg, err := node.LoadConfig(GetConfigFile())
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+    out := fs.String("out", cfg.PrivateNet.KeyPath(GetProfileDir()), "where to write the swarm key")
+    force := fs.Bool("force", false, "replace an existing key, cutting off every node that still uses it")
+    fs.Parse(args)
+
+    psk, err := node.GenerateSwarmKey(*out, *force)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println("Wrote swarm key", *out)
+    fmt.Println("Fingerprint:", node.SwarmKeyFingerprint(psk))
+    fmt.Println("Copy this file over a secure channel to the profile directory of every node")
+    fmt.Println("on the network, then start them with -private.")
+}
+
+// runConfig prints the effective config and where each value came from:
+// the defaults, the config file, DNCHAT_* environment variables or flags.
+//
+//	IPFS_CHAT4 config show -gossip-d 8
+func runConfig(args []string) {
+    if len(args) == 0 || args[0] != "show" {
+        fmt.Fprintln(os.Stderr, "usage: IPFS_CHAT4 config show [flags]")
+        os.Exit(2)
+    }
+
+    cfg, layers, err := node.LoadLayers(GetConfigFile())
     if err != nil {
         log.Fatal(err)
     }
-    return filepath.Join(homeDir, ".config", "DangerousNet", "Chat", "Keys")
+    fs := flag.NewFlagSet("config show", flag.ExitOnError)
+    cfg.BindFlags(fs)
+    sourcePort := fs.Int("sp", 0, "Source port number, for the default listen addresses")
+    fs.Parse(args[1:])
+    layers.Flags(fs, &cfg)
+
+    if _, err := os.Stat(layers.Path); err == nil {
+        fmt.Println("Config file:", layers.Path)
+    } else {
+        fmt.Println("Config file:", layers.Path, "(not found)")
+    }
+    fmt.Println("Environment overrides are", node.EnvPrefix+"<KEY>, e.g.", node.EnvName("router.historyLength"))
+    fmt.Println()
+    for _, s := range layers.Settings(cfg) {
+        if s.Key == "listen.listen" && len(cfg.Listen.Listen) == 0 {
+            // No listen addresses means the defaults, so show those.
+            js, _ := json.Marshal(cfg.Listen.ListenAddrs(*sourcePort))
+            s.Value = string(js)
+        }
+        source := s.Source
+        if s.Origin != "" && s.Source != node.SourceFile {
+            source += " " + s.Origin
+        }
+        fmt.Printf("%-44s %-30s %s\n", s.Key, s.Value, source)
+    }
+    if err := cfg.Validate(); err != nil {
+        fmt.Println()
+        fmt.Println("Invalid:", err)
+        os.Exit(1)
+    }
+}
+
+func GetProfileDir() string {
+    profileDir, err := node.ProfileDir()
+    if err != nil {
+        log.Fatal(err)
+    }
+    return profileDir
+}
+
+func GetConfigDir() string {
+    return filepath.Join(GetProfileDir(), "Keys")
+}
+
+func GetConfigFile() string {
+    return node.ConfigPath(GetProfileDir())
 }
 
 /*
@@ -324,111 +693,42 @@ func LoadOrCreateKey(configDir string) (crypto.PrivKey, error) {
     return crypto.UnmarshalPrivateKey(keyBytes)
 }
 
-func MakeHost(port int) (host.Host, error) {
+// MakeHost creates a host with our persisted identity. port is used by the default
+// listen addresses when cfg lists none. When mDNS is enabled in cfg it also starts
+// LAN discovery, otherwise the returned discovery is nil.
+func MakeHost(ctx context.Context, port int, cfg node.Config, blocklist *node.Blocklist) (host.Host, *node.LANDiscovery, error) {
     configDir := GetConfigDir()
     prvKey, err := LoadOrCreateKey(configDir)
     if err != nil {
         log.Fatal(err)
     }
 
-    sourceMultiAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port))
-    return libp2p.New(
-        libp2p.ListenAddrs(sourceMultiAddr),
-        libp2p.Identity(prvKey),
-    )
-}
-
-
-const ChatRoomBufSize = 128  // Adjust as needed
-
-type ChatRoom struct {
-    ctx      context.Context
-    ps       *pubsub.PubSub
-    topic    *pubsub.Topic
-    sub      *pubsub.Subscription
-    self     peer.ID
-    nick     string
-    roomName string
-    Messages chan *ChatMessage
-}
-
-type ChatMessage struct {
-    Message    string
-    SenderID   string
-    SenderNick string
-}
-// topic handler
-func JoinChatRoom(ctx context.Context, ps *pubsub.PubSub, selfID peer.ID, nickname, roomName string) (*ChatRoom, error) {
-    topic, err := ps.Join(TopicName(roomName))
+    psk, err := cfg.PrivateNet.Load(GetProfileDir())
     if err != nil {
-        return nil, err
+        return nil, nil, err
     }
-
-    sub, err := topic.Subscribe()
+    opts, err := cfg.HostOptions(port, psk)
     if err != nil {
-        return nil, err
+        return nil, nil, err
     }
 
-    chatRoom := &ChatRoom{
-        ctx:      ctx,
-        ps:       ps,
-        topic:    topic,
-        sub:      sub,
-        self:     selfID,
-        nick:     nickname,
-        roomName: roomName,
-        Messages: make(chan *ChatMessage, ChatRoomBufSize),
+    h, err := libp2p.New(append(opts, libp2p.Identity(prvKey), libp2p.ConnectionGater(blocklist))...)
+    if err != nil {
+        return nil, nil, err
     }
-
-    go chatRoom.readLoop()
-    return chatRoom, nil
-}
-
-
-// message handler for chatrooms
-func (cr *ChatRoom) Publish(message string) error {
-    m := ChatMessage{
-        Message:    message,
-        SenderID:   cr.self.String(),
-        SenderNick: cr.nick,
+    blocklist.Attach(h)
+    if !cfg.MDNS.Enabled {
+        return h, nil, nil
     }
-    msgBytes, err := json.Marshal(m)
+
+    lan, err := node.StartMDNS(ctx, h, cfg.MDNS)
     if err != nil {
-        return err
+        h.Close()
+        return nil, nil, err
     }
-    return cr.topic.Publish(cr.ctx, msgBytes)
-}
-
-
-func (cr *ChatRoom) ListPeers() []peer.ID {
-    return cr.ps.ListPeers(TopicName(cr.roomName))
-}
-
-// readLoop pulls messages from the pubsub topic and pushes them onto the Messages channel.
-func (cr *ChatRoom) readLoop() {
-	for {
-		msg, err := cr.sub.Next(cr.ctx)
-		if err != nil {
-			close(cr.Messages)
-			return
-		}
-		// only forward messages delivered by others
-		if msg.ReceivedFrom == cr.self {
-			continue
-		}
-		cm := new(ChatMessage)
-		err = json.Unmarshal(msg.Data, cm)
-		if err != nil {
-			continue
-		}
-		// send valid messages onto the Messages channel
-		cr.Messages <- cm
-	}
+    return h, lan, nil
 }
 
-func TopicName(roomName string) string {
-	return "chat-room:" + roomName
-}
 
 func HandleStream(s network.Stream) {
 	log.Println("Got a new stream!")
@@ -451,9 +751,11 @@ func ReadData(rw *bufio.ReadWriter) {
 			return
 		}
 		if str != "\n" {
-			// Green console colour: 	\x1b[32m
-			// Reset console colour: 	\x1b[0m
-			fmt.Printf("\x1b[32m%s\x1b[0m> ", str)
+			if plain {
+				fmt.Printf("stream %s", str)
+				continue
+			}
+			fmt.Printf("%s\n> ", theme.Render(node.RoleNick, strings.TrimSuffix(str, "\n")))
 		}
 
 	}
@@ -482,22 +784,16 @@ func startPeer(ctx context.Context, h host.Host, streamHandler network.StreamHan
 	// Only applies on the receiving side.
 	h.SetStreamHandler("/chat/1.0.0", streamHandler)
 
-	// Let's get the actual TCP port from our listen multiaddr, in case we're using 0 (default; random available port).
-	var port string
-	for _, la := range h.Network().ListenAddresses() {
-		if p, err := la.ValueForProtocol(multiaddr.P_TCP); err == nil {
-			port = p
-			break
-		}
-	}
-
-	if port == "" {
-		log.Println("was not able to find actual local port")
+	addrs := node.DialAddrs(h)
+	if len(addrs) == 0 {
+		log.Println("was not able to find a dialable address")
 		return
 	}
 
-	log.Printf("Run './chat -d /ip4/127.0.0.1/tcp/%v/p2p/%s' on another console.\n", port, h.ID())
-	log.Println("You can replace 127.0.0.1 with public IP as well.")
+	log.Println("Run './chat -d <address>' on another console with one of:")
+	for _, a := range addrs {
+		log.Printf(" - %s\n", a)
+	}
 	log.Println("Waiting for incoming connection")
 	log.Println()
 }
@@ -545,36 +841,51 @@ func startPeerAndConnect(ctx context.Context, h host.Host, destination string) (
 
 
 
-func startChatInterface(ctx context.Context, chatRoom *ChatRoom) {
-    // Start a goroutine to handle incoming messages
-    go func() {
-        for msg := range chatRoom.Messages {
-            // Check if the message is from the current user
-            if msg.SenderID == chatRoom.self.String() {
-                continue // Skip the user's own messages
-            }
-            fmt.Printf("\r\x1b[32m%s\x1b[0m: %s\n> ", msg.SenderNick, msg.Message)
-        }
-    }()
-
-    // Main loop for sending messages
-    scanner := bufio.NewScanner(os.Stdin)
-    fmt.Print("> ")
-    for scanner.Scan() {
-        text := scanner.Text()
-        if text == "/exit" {
+// startChatInterface reads messages and slash commands until /exit or Ctrl-D.
+// Ctrl-C throws away the line being typed.
+func startChatInterface(sess *chatSession) {
+    if room := sess.Current(); room != nil {
+        fmt.Printf("Talking in %s. Type /help for commands, /exit or Ctrl-D for the menu.\n", room.Name())
+    }
+    if plain {
+        fmt.Println("End a line with \\ to continue a message on the next line.")
+    } else {
+        fmt.Println("End a line with \\ or press Alt-Enter to continue a message on the next line.")
+    }
+
+    // Main loop for sending messages and running commands
+    for {
+        text, err := sess.ed.ReadLine("> ")
+        if err == lineedit.ErrInterrupt {
+            continue
+        } else if err == io.EOF {
             fmt.Println("Exiting chat room...")
-            return // Exit command to leave the chat
+            return
+        } else if err != nil {
+            log.Println("Error reading from stdin:", err)
+            return
         }
 
-        // Send message
-        if err := chatRoom.Publish(text); err != nil {
-            fmt.Println("Error sending message:", err)
+        if node.IsCommand(text) {
+            if err := sess.commands.Run(text); err != nil {
+                printError(err)
+            }
+            if sess.exiting() {
+                fmt.Println("Exiting chat room...")
+                return
+            }
+            continue
+        }
+        if strings.TrimSpace(text) == "" {
+            continue
         }
-        fmt.Print("> ") // Prompt for next message
-    }
 
-    if err := scanner.Err(); err != nil {
-        log.Println("Error reading from stdin:", err)
+        // Send message, "//" escaping a leading slash
+        room := sess.Current()
+        if room == nil {
+            printError(errors.New("not in a room, /join one first"))
+        } else if err := room.Publish(strings.TrimPrefix(text, "/")); err != nil {
+            printError(fmt.Errorf("sending message: %w", err))
+        }
     }
 }
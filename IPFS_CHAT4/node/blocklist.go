@@ -0,0 +1,285 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// BlocklistFile is the name of the blocklist file in the profile directory.
+const BlocklistFile = "blocklist.json"
+
+// blocklistFile is the on-disk form of a Blocklist.
+type blocklistFile struct {
+	Peers []peer.ID `json:"peers"`
+	CIDRs []string  `json:"cidrs"`
+}
+
+// Blocklist refuses connections to and from blocked peer IDs and address ranges.
+// It is a libp2p connection gater and a pubsub blacklist, so blocked peers are
+// cut off at the transport and their messages are dropped even when another
+// peer forwards them.
+type Blocklist struct {
+	path string
+
+	mu    sync.RWMutex
+	h     host.Host
+	peers map[peer.ID]struct{}
+	nets  map[string]*net.IPNet
+}
+
+// LoadBlocklist reads the blocklist at path. A missing file is an empty blocklist.
+func LoadBlocklist(path string) (*Blocklist, error) {
+	b := &Blocklist{path: path}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Attach lets the blocklist close connections to h that become blocked.
+func (b *Blocklist) Attach(h host.Host) {
+	b.mu.Lock()
+	b.h = h
+	b.mu.Unlock()
+	b.enforce()
+}
+
+// Reload rereads the blocklist file and drops connections it now blocks.
+func (b *Blocklist) Reload() error {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		data = []byte("{}")
+	} else if err != nil {
+		return err
+	}
+
+	var f blocklistFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("reading %s: %w", b.path, err)
+	}
+	peers := make(map[peer.ID]struct{}, len(f.Peers))
+	for _, p := range f.Peers {
+		peers[p] = struct{}{}
+	}
+	nets := make(map[string]*net.IPNet, len(f.CIDRs))
+	for _, s := range f.CIDRs {
+		n, err := parseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", b.path, err)
+		}
+		nets[n.String()] = n
+	}
+
+	b.mu.Lock()
+	b.peers, b.nets = peers, nets
+	b.mu.Unlock()
+	b.enforce()
+	return nil
+}
+
+// ReloadOnHangup rereads the blocklist file whenever the process gets SIGHUP, until ctx ends.
+func (b *Blocklist) ReloadOnHangup(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				if err := b.Reload(); err != nil {
+					log.Println("blocklist: reloading", err)
+				} else {
+					log.Println("blocklist: reloaded", b.path)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Block adds a peer ID, CIDR or single IP address, saves the file and
+// disconnects whatever it matches.
+func (b *Blocklist) Block(entry string) error {
+	if p, err := peer.Decode(entry); err == nil {
+		b.mu.Lock()
+		b.peers[p] = struct{}{}
+		b.mu.Unlock()
+	} else {
+		n, err := parseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("%q is neither a peer ID nor an address range", entry)
+		}
+		b.mu.Lock()
+		b.nets[n.String()] = n
+		b.mu.Unlock()
+	}
+	b.enforce()
+	return b.Save()
+}
+
+// Unblock removes a peer ID, CIDR or single IP address and saves the file.
+func (b *Blocklist) Unblock(entry string) error {
+	b.mu.Lock()
+	if p, err := peer.Decode(entry); err == nil {
+		if _, ok := b.peers[p]; !ok {
+			b.mu.Unlock()
+			return fmt.Errorf("%s is not blocked", p)
+		}
+		delete(b.peers, p)
+	} else {
+		n, err := parseCIDR(entry)
+		if err != nil {
+			b.mu.Unlock()
+			return fmt.Errorf("%q is neither a peer ID nor an address range", entry)
+		}
+		if _, ok := b.nets[n.String()]; !ok {
+			b.mu.Unlock()
+			return fmt.Errorf("%s is not blocked", n)
+		}
+		delete(b.nets, n.String())
+	}
+	b.mu.Unlock()
+	return b.Save()
+}
+
+// Entries lists the blocked peer IDs and address ranges, sorted.
+func (b *Blocklist) Entries() (peers []peer.ID, cidrs []string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for p := range b.peers {
+		peers = append(peers, p)
+	}
+	for s := range b.nets {
+		cidrs = append(cidrs, s)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	sort.Strings(cidrs)
+	return peers, cidrs
+}
+
+// Save writes the blocklist file.
+func (b *Blocklist) Save() error {
+	var f blocklistFile
+	f.Peers, f.CIDRs = b.Entries()
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// Contains reports whether p is blocked. Together with Add it makes the
+// blocklist usable with pubsub.WithBlacklist.
+func (b *Blocklist) Contains(p peer.ID) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.peers[p]
+	return ok
+}
+
+// Add blocks p for pubsub.BlacklistPeer. It does not disconnect p, since
+// pubsub calls it from its event loop and drops the peer itself.
+func (b *Blocklist) Add(p peer.ID) bool {
+	b.mu.Lock()
+	b.peers[p] = struct{}{}
+	b.mu.Unlock()
+	return b.Save() == nil
+}
+
+func (b *Blocklist) addrBlocked(a multiaddr.Multiaddr) bool {
+	ip, err := manet.ToIP(a)
+	if err != nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, n := range b.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// enforce closes open connections that the blocklist now refuses.
+func (b *Blocklist) enforce() {
+	b.mu.RLock()
+	h := b.h
+	b.mu.RUnlock()
+	if h == nil {
+		return
+	}
+	for _, c := range h.Network().Conns() {
+		if b.Contains(c.RemotePeer()) || b.addrBlocked(c.RemoteMultiaddr()) {
+			c.Close()
+		}
+	}
+}
+
+// InterceptPeerDial refuses to dial blocked peers.
+func (b *Blocklist) InterceptPeerDial(p peer.ID) bool {
+	return !b.Contains(p)
+}
+
+// InterceptAddrDial refuses to dial addresses in blocked ranges.
+func (b *Blocklist) InterceptAddrDial(p peer.ID, a multiaddr.Multiaddr) bool {
+	return !b.Contains(p) && !b.addrBlocked(a)
+}
+
+// InterceptAccept refuses inbound connections from blocked ranges.
+func (b *Blocklist) InterceptAccept(c network.ConnMultiaddrs) bool {
+	return !b.addrBlocked(c.RemoteMultiaddr())
+}
+
+// InterceptSecured refuses connections once the remote peer turns out to be blocked.
+func (b *Blocklist) InterceptSecured(_ network.Direction, p peer.ID, c network.ConnMultiaddrs) bool {
+	return !b.Contains(p) && !b.addrBlocked(c.RemoteMultiaddr())
+}
+
+// InterceptUpgraded accepts everything that made it through InterceptSecured.
+func (b *Blocklist) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// parseCIDR accepts a CIDR or a single IP address, which becomes a /32 or /128.
+func parseCIDR(s string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("bad CIDR %q", s)
+	}
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
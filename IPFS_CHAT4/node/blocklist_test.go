@@ -0,0 +1,74 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+)
+
+func TestBlocklistGater(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), BlocklistFile)
+	bl, err := LoadBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.ConnectionGater(bl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	bl.Attach(alice)
+	bob := newTestHost(t)
+	bobInfo := peer.AddrInfo{ID: bob.ID(), Addrs: bob.Addrs()}
+
+	if err := alice.Connect(ctx, bobInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocking drops the open connection and refuses new ones both ways.
+	if err := bl.Block(bob.ID().String()); err != nil {
+		t.Fatal(err)
+	}
+	if alice.Network().Connectedness(bob.ID()) == network.Connected {
+		t.Fatal("still connected to a blocked peer")
+	}
+	if !bl.Contains(bob.ID()) {
+		t.Fatal("pubsub would not see bob as blacklisted")
+	}
+	// Bob's side may finish its handshake before alice refuses it, so only
+	// alice's side shows the refusal.
+	bob.Connect(ctx, peer.AddrInfo{ID: alice.ID(), Addrs: alice.Addrs()})
+	if len(alice.Network().ConnsToPeer(bob.ID())) > 0 {
+		t.Fatal("accepted a connection from a blocked peer")
+	}
+
+	if err := bl.Unblock(bob.ID().String()); err != nil {
+		t.Fatal(err)
+	}
+	alice.Network().(*swarm.Swarm).Backoff().Clear(bob.ID())
+	if err := alice.Connect(ctx, bobInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// A range in the file takes effect on reload.
+	if err := os.WriteFile(path, []byte(`{"cidrs": ["127.0.0.0/8"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := bl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if alice.Network().Connectedness(bob.ID()) == network.Connected {
+		t.Fatal("still connected to a blocked range")
+	}
+	if _, cidrs := bl.Entries(); len(cidrs) != 1 || cidrs[0] != "127.0.0.0/8" {
+		t.Fatalf("unexpected ranges %v", cidrs)
+	}
+}
@@ -0,0 +1,131 @@
+package node
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// ChatConfig holds who we are in chat rooms and where we go on start.
+type ChatConfig struct {
+	Nick string `json:"nick"`
+	// Rooms are joined as soon as the app starts.
+	Rooms []string `json:"rooms"`
+	// Theme names the palette to colour output with, built in or from Themes.
+	Theme  string             `json:"theme"`
+	Themes map[string]Palette `json:"themes"`
+	// Color is auto, always or never; see UseColor.
+	Color string `json:"color"`
+	// Plain prints one labelled line per event, without colours or redrawing
+	// the line being typed, for screen readers and logs.
+	Plain bool `json:"plain"`
+	// Highlight marks messages matching these besides the ones naming us:
+	// keywords, or regular expressions between slashes. See ParseHighlightRule.
+	Highlight []string `json:"highlight"`
+	// Bell rings the terminal bell when a message mentions us.
+	Bell bool `json:"bell"`
+}
+
+// DefaultChatConfig uses the login name as nickname and joins nothing.
+func DefaultChatConfig() ChatConfig {
+	nick := os.Getenv("USER")
+	if nick == "" {
+		nick = "anon"
+	}
+	return ChatConfig{Nick: nick, Theme: "default", Color: ColorAuto}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *ChatConfig) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Nick, "nick", c.Nick, "nickname used in chat rooms")
+	fs.Func("join", "comma separated rooms to join on start", func(s string) error {
+		c.Rooms = splitList(s)
+		return nil
+	})
+	c.BindOutputFlags(fs)
+	fs.Func("highlight", "comma separated keywords or /regexps/ to highlight besides your nickname", func(s string) error {
+		c.Highlight = splitList(s)
+		return nil
+	})
+	fs.BoolVar(&c.Bell, "bell", c.Bell, "ring the terminal bell when a message mentions you")
+}
+
+// BindOutputFlags registers the flags for how chat output looks, for
+// clients that take no other chat settings.
+func (c *ChatConfig) BindOutputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Theme, "theme", c.Theme, "colour theme: default, light, mono or one from the config file")
+	fs.StringVar(&c.Color, "color", c.Color, "colour output: auto, always or never")
+	fs.BoolVar(&c.Plain, "plain", c.Plain, "plain accessible output: labelled lines, no colours or cursor movement")
+}
+
+// Validate checks the nickname, room names, themes and highlight rules.
+func (c ChatConfig) Validate() error {
+	if err := ValidateNick(c.Nick); err != nil {
+		return err
+	}
+	for _, r := range c.Rooms {
+		if err := ValidateRoomName(r); err != nil {
+			return err
+		}
+	}
+	for name := range c.Themes {
+		if _, err := c.LoadTheme(name); err != nil {
+			return err
+		}
+	}
+	if _, err := c.LoadTheme(c.Theme); err != nil {
+		return err
+	}
+	switch c.Color {
+	case ColorAuto, ColorAlways, ColorNever:
+	default:
+		return fmt.Errorf("color must be auto, always or never, not %q", c.Color)
+	}
+	for _, rule := range c.Highlight {
+		if _, err := ParseHighlightRule(rule); err != nil {
+			return fmt.Errorf("highlight rule %q: %w", rule, err)
+		}
+	}
+	return nil
+}
+
+// LogConfig sets how much libp2p logs and where to. The chat apps' own
+// messages always go to the terminal.
+type LogConfig struct {
+	// Level is one of debug, info, warn, error, dpanic, panic or fatal.
+	Level string `json:"level"`
+	// File receives the logs instead of stderr when set.
+	File string `json:"file"`
+}
+
+// DefaultLogConfig only logs libp2p errors, to stderr.
+func DefaultLogConfig() LogConfig {
+	return LogConfig{Level: "error"}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *LogConfig) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Level, "log-level", c.Level, "libp2p log level: debug, info, warn or error")
+	fs.StringVar(&c.File, "log-file", c.File, "write libp2p logs to this file instead of stderr")
+}
+
+// Validate checks the level name.
+func (c LogConfig) Validate() error {
+	if _, err := logging.LevelFromString(c.Level); err != nil {
+		return fmt.Errorf("log level %q: %w", c.Level, err)
+	}
+	return nil
+}
+
+// Apply sets up the libp2p loggers.
+func (c LogConfig) Apply() {
+	level, _ := logging.LevelFromString(c.Level)
+	logging.SetupLogging(logging.Config{
+		Format: logging.PlaintextOutput,
+		Level:  level,
+		Stderr: c.File == "",
+		File:   c.File,
+	})
+}
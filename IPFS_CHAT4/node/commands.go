@@ -0,0 +1,200 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnknownCommand is returned by Commands.Run for a command nobody registered.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Command is one slash command of the interactive chat.
+type Command struct {
+	// Name is what follows the slash, e.g. "join".
+	Name string
+	// Args describes the arguments for help and usage errors, e.g. "<room>".
+	Args string
+	Help string
+	// MinArgs and MaxArgs bound the argument count. MaxArgs -1 allows any number.
+	MinArgs, MaxArgs int
+	// Rest makes the last of MaxArgs arguments take the rest of the line as
+	// typed, spaces and quotes included, for things like message text.
+	Rest bool
+	// Complete lists values for argument i that start with prefix. It may be nil.
+	Complete func(i int, prefix string) []string
+	Run      func(args []string) error
+}
+
+// Usage is the command with its arguments, e.g. "/join <room>".
+func (c Command) Usage() string {
+	if c.Args == "" {
+		return "/" + c.Name
+	}
+	return "/" + c.Name + " " + c.Args
+}
+
+// Commands is a registry of slash commands.
+type Commands struct {
+	byName map[string]*Command
+}
+
+// NewCommands returns an empty registry.
+func NewCommands() *Commands {
+	return &Commands{byName: make(map[string]*Command)}
+}
+
+// Register adds cmd. Registering a name twice is a programming error and panics.
+func (c *Commands) Register(cmd Command) {
+	if _, ok := c.byName[cmd.Name]; ok {
+		panic("command /" + cmd.Name + " registered twice")
+	}
+	if cmd.Rest && cmd.MaxArgs < 1 {
+		panic("command /" + cmd.Name + " takes the rest of the line but no arguments")
+	}
+	c.byName[cmd.Name] = &cmd
+}
+
+// List returns the registered commands sorted by name.
+func (c *Commands) List() []Command {
+	out := make([]Command, 0, len(c.byName))
+	for _, cmd := range c.byName {
+		out = append(out, *cmd)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Lookup finds a command by name, with or without the slash.
+func (c *Commands) Lookup(name string) (Command, bool) {
+	cmd, ok := c.byName[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return Command{}, false
+	}
+	return *cmd, true
+}
+
+// IsCommand reports whether line is a slash command. A doubled slash escapes
+// a message that starts with one.
+func IsCommand(line string) bool {
+	return strings.HasPrefix(line, "/") && !strings.HasPrefix(line, "//")
+}
+
+// Run parses line, checks the argument count and runs the command.
+func (c *Commands) Run(line string) error {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(line, "/"), " ")
+	cmd, ok := c.byName[name]
+	if !ok {
+		return fmt.Errorf("%w /%s, try /help", ErrUnknownCommand, name)
+	}
+
+	var args []string
+	if cmd.Rest {
+		args = splitArgsN(rest, cmd.MaxArgs)
+	} else {
+		args = splitArgs(rest)
+	}
+	if len(args) < cmd.MinArgs || cmd.MaxArgs >= 0 && len(args) > cmd.MaxArgs {
+		return fmt.Errorf("usage: %s", cmd.Usage())
+	}
+	return cmd.Run(args)
+}
+
+// Complete returns the full lines line could complete to: command names while
+// the name is typed, then the command's own suggestions for the last argument.
+func (c *Commands) Complete(line string) []string {
+	if !IsCommand(line) {
+		return nil
+	}
+	name, rest, typedArgs := strings.Cut(line[1:], " ")
+	if !typedArgs {
+		var out []string
+		for _, cmd := range c.List() {
+			if strings.HasPrefix(cmd.Name, name) {
+				out = append(out, "/"+cmd.Name+" ")
+			}
+		}
+		return out
+	}
+
+	cmd, ok := c.byName[name]
+	if !ok || cmd.Complete == nil {
+		return nil
+	}
+	// Complete the word under the cursor; everything before it stays as typed.
+	i := strings.LastIndexFunc(rest, unicode.IsSpace) + 1
+	done, prefix := rest[:i], rest[i:]
+	arg := len(splitArgs(done))
+	if cmd.Rest && arg >= cmd.MaxArgs-1 || cmd.MaxArgs >= 0 && arg >= cmd.MaxArgs {
+		return nil
+	}
+
+	var out []string
+	for _, v := range cmd.Complete(arg, prefix) {
+		out = append(out, "/"+name+" "+done+v+" ")
+	}
+	return out
+}
+
+// CommonPrefix is the longest prefix shared by every candidate, what a single
+// tab press can fill in when the candidates disagree.
+func CommonPrefix(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	p := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, p) {
+			_, size := utf8.DecodeLastRuneInString(p)
+			p = p[:len(p)-size]
+		}
+	}
+	return p
+}
+
+// splitArgs splits s on spaces, keeping "double" or 'single' quoted text together.
+func splitArgs(s string) []string {
+	args, _ := scanArgs(s, -1)
+	return args
+}
+
+// splitArgsN is splitArgs for at most n arguments, the last taking the rest of s as typed.
+func splitArgsN(s string, n int) []string {
+	args, rest := scanArgs(s, n-1)
+	if rest = strings.TrimSpace(rest); rest != "" {
+		args = append(args, rest)
+	}
+	return args
+}
+
+// scanArgs reads up to limit arguments (any number when limit < 0) and returns
+// whatever is left of s unread.
+func scanArgs(s string, limit int) (args []string, rest string) {
+	i := 0
+	for limit < 0 || len(args) < limit {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i == len(s) {
+			break
+		}
+
+		var b strings.Builder
+		var quote byte
+		for ; i < len(s) && (quote != 0 || s[i] != ' '); i++ {
+			switch ch := s[i]; {
+			case ch == quote:
+				quote = 0
+			case quote == 0 && (ch == '"' || ch == '\''):
+				quote = ch
+			default:
+				b.WriteByte(ch)
+			}
+		}
+		args = append(args, b.String())
+	}
+	return args, s[i:]
+}
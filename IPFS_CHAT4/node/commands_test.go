@@ -0,0 +1,84 @@
+package node
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	var got []string
+	record := func(args []string) error {
+		got = args
+		return nil
+	}
+
+	c := NewCommands()
+	c.Register(Command{Name: "join", Args: "<room>", MinArgs: 1, MaxArgs: 1, Run: record,
+		Complete: func(_ int, prefix string) []string {
+			var out []string
+			for _, r := range []string{"lobby", "lounge", "dev"} {
+				if strings.HasPrefix(r, prefix) {
+					out = append(out, r)
+				}
+			}
+			return out
+		}})
+	c.Register(Command{Name: "msg", Args: "<nick> <message>", MinArgs: 2, MaxArgs: 2, Rest: true, Run: record,
+		Complete: func(int, string) []string { return []string{"alice"} }})
+	c.Register(Command{Name: "who", MaxArgs: -1, Run: record})
+
+	for _, tc := range []struct {
+		line string
+		want []string
+		err  string
+	}{
+		{line: "/join lobby", want: []string{"lobby"}},
+		{line: `/join "lobby"`, want: []string{"lobby"}},
+		{line: "/join", err: "usage: /join <room>"},
+		{line: "/join a b", err: "usage: /join <room>"},
+		{line: `/msg alice  hi "there"  you`, want: []string{"alice", `hi "there"  you`}},
+		{line: "/msg alice", err: "usage: /msg <nick> <message>"},
+		{line: "/who a 'b c' d", want: []string{"a", "b c", "d"}},
+	} {
+		got = nil
+		err := c.Run(tc.line)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: error %v, want %q", tc.line, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: ran with %q (%v), want %q", tc.line, got, err, tc.want)
+		}
+	}
+
+	if err := c.Run("/nope"); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("unknown command gave %v", err)
+	}
+	if IsCommand("//not a command") || !IsCommand("/who") {
+		t.Error("IsCommand does not honour the // escape")
+	}
+
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{"/j", []string{"/join "}},
+		{"/", []string{"/join ", "/msg ", "/who "}},
+		{"/join lo", []string{"/join lobby ", "/join lounge "}},
+		{"/join lobby ", nil},
+		{"/msg al", []string{"/msg alice "}},
+		{"/msg alice he", nil},
+		{"hello", nil},
+	} {
+		if got := c.Complete(tc.line); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Complete(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+	if p := CommonPrefix(c.Complete("/join lo")); p != "/join lo" {
+		t.Errorf("common prefix %q", p)
+	}
+}
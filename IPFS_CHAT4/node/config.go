@@ -0,0 +1,157 @@
+package node
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// Config is the node section of the chat config file.
+type Config struct {
+	Chat          ChatConfig          `json:"chat"`
+	Listen        ListenConfig        `json:"listen"`
+	PrivateNet    PrivateNetConfig    `json:"privateNet"`
+	NAT           NATConfig           `json:"nat"`
+	Limits        LimitsConfig        `json:"limits"`
+	Router        RouterConfig        `json:"router"`
+	Scoring       ScoringConfig       `json:"scoring"`
+	MessageLimits MessageLimitsConfig `json:"messageLimits"`
+	TopicPolicy   TopicPolicyConfig   `json:"topicPolicy"`
+	Directory     DirectoryConfig     `json:"directory"`
+	MDNS          MDNSConfig          `json:"mdns"`
+	DHT           DHTConfig           `json:"dht"`
+	Reconnect     ReconnectConfig     `json:"reconnect"`
+	Gateway       GatewayConfig       `json:"gateway"`
+	Log           LogConfig           `json:"log"`
+}
+
+// DefaultConfig returns the settings used when no config file exists.
+func DefaultConfig() Config {
+	return Config{
+		Chat:          DefaultChatConfig(),
+		Listen:        DefaultListenConfig(),
+		PrivateNet:    DefaultPrivateNetConfig(),
+		NAT:           DefaultNATConfig(),
+		Limits:        DefaultLimitsConfig(),
+		Router:        DefaultRouterConfig(),
+		Scoring:       DefaultScoringConfig(),
+		MessageLimits: DefaultMessageLimitsConfig(),
+		TopicPolicy:   DefaultTopicPolicyConfig(),
+		Directory:     DefaultDirectoryConfig(),
+		MDNS:          DefaultMDNSConfig(),
+		DHT:           DefaultDHTConfig(),
+		Reconnect:     DefaultReconnectConfig(),
+		Gateway:       DefaultGatewayConfig(),
+		Log:           DefaultLogConfig(),
+	}
+}
+
+// BindFlags registers the command line overrides of every section.
+func (c *Config) BindFlags(fs *flag.FlagSet) {
+	c.Chat.BindFlags(fs)
+	c.Listen.BindFlags(fs)
+	c.PrivateNet.BindFlags(fs)
+	c.NAT.BindFlags(fs)
+	c.Limits.BindFlags(fs)
+	c.Router.BindFlags(fs)
+	c.Scoring.BindFlags(fs)
+	c.Directory.BindFlags(fs)
+	c.MDNS.BindFlags(fs)
+	c.DHT.BindFlags(fs)
+	c.Reconnect.BindFlags(fs)
+	c.Gateway.BindFlags(fs)
+	c.Log.BindFlags(fs)
+}
+
+// Validate checks each section and the combinations between them.
+func (c Config) Validate() error {
+	if err := c.Chat.Validate(); err != nil {
+		return err
+	}
+	if err := c.Listen.Validate(); err != nil {
+		return err
+	}
+	if err := c.PrivateNet.Validate(); err != nil {
+		return err
+	}
+	if err := c.NAT.Validate(); err != nil {
+		return err
+	}
+	if err := c.Limits.Validate(); err != nil {
+		return err
+	}
+	if err := c.Router.Validate(); err != nil {
+		return err
+	}
+	if err := c.Scoring.Validate(); err != nil {
+		return err
+	}
+	if err := c.MessageLimits.Validate(); err != nil {
+		return err
+	}
+	if err := c.TopicPolicy.Validate(); err != nil {
+		return err
+	}
+	if err := c.Directory.Validate(); err != nil {
+		return err
+	}
+	if err := c.MDNS.Validate(); err != nil {
+		return err
+	}
+	if err := c.DHT.Validate(); err != nil {
+		return err
+	}
+	if err := c.Reconnect.Validate(); err != nil {
+		return err
+	}
+	if err := c.Gateway.Validate(); err != nil {
+		return err
+	}
+	if err := c.Log.Validate(); err != nil {
+		return err
+	}
+	if c.PrivateNet.Enabled && len(c.Listen.Listen) > 0 && len(privateListenAddrs(c.Listen.Listen)) == 0 {
+		return fmt.Errorf("a private network needs a TCP or WebSocket listen address, QUIC and WebTransport are not supported")
+	}
+	if c.PrivateNet.Enabled && c.DHT.Enabled && len(c.DHT.BootstrapPeers) == 0 {
+		return fmt.Errorf("the DHT on a private network needs bootstrap peers on that network")
+	}
+	if c.Scoring.Enabled() && strings.ToLower(c.Router.Router) != RouterGossipSub {
+		return fmt.Errorf("peer scoring needs the gossipsub router, not %s", c.Router.Router)
+	}
+	return nil
+}
+
+// PubSubOptions builds the router options for c: the topic policy and, when enabled,
+// peer scoring reported to scores. scores may be nil.
+func (c Config) PubSubOptions(h host.Host, scores *ScoreBoard) ([]pubsub.Option, error) {
+	policy, err := NewTopicPolicy(c.TopicPolicy)
+	if err != nil {
+		return nil, err
+	}
+	policy.Watch(h)
+
+	opts := []pubsub.Option{pubsub.WithSubscriptionFilter(policy)}
+	return append(opts, c.Scoring.Options(scores)...), nil
+}
+
+// ProfileDir is where the chat apps keep their config and keys.
+func ProfileDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "DangerousNet", "Chat"), nil
+}
+
+// LoadConfig reads the config file at path over DefaultConfig, then applies
+// the environment overrides. A missing file is not an error.
+func LoadConfig(path string) (Config, error) {
+	cfg, _, err := LoadLayers(path)
+	return cfg, err
+}
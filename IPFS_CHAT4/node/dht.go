@@ -0,0 +1,281 @@
+package node
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// DHT modes, see dht.ModeOpt.
+const (
+	DHTModeAuto   = "auto"
+	DHTModeClient = "client"
+	DHTModeServer = "server"
+)
+
+// DefaultDHTNamespace prefixes every rendezvous key we advertise.
+const DefaultDHTNamespace = "dangerousnet-chat"
+
+// DHTConfig controls room rendezvous over the Kademlia DHT.
+type DHTConfig struct {
+	Enabled bool   `json:"enabled"`
+	Mode    string `json:"mode"`
+	// Namespace keeps our rendezvous keys apart from other apps sharing the DHT.
+	Namespace string `json:"namespace"`
+	// BootstrapPeers are /p2p multiaddrs. Empty means the public libp2p bootstrap nodes.
+	BootstrapPeers []string `json:"bootstrapPeers"`
+	// SearchInterval is how often we look for other advertisers of each joined room.
+	SearchInterval Duration `json:"searchInterval"`
+}
+
+// DefaultDHTConfig leaves the DHT off. When turned on it searches every minute.
+func DefaultDHTConfig() DHTConfig {
+	return DHTConfig{
+		Mode:           DHTModeAuto,
+		Namespace:      DefaultDHTNamespace,
+		SearchInterval: Duration(time.Minute),
+	}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *DHTConfig) BindFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "dht", c.Enabled, "find room members beyond the LAN through the Kademlia DHT")
+	fs.StringVar(&c.Mode, "dht-mode", c.Mode, "DHT mode: auto, client or server")
+	fs.Func("bootstrap", "comma separated bootstrap multiaddrs for the DHT (default: public libp2p nodes)", func(s string) error {
+		c.BootstrapPeers = splitList(s)
+		return nil
+	})
+}
+
+// Validate checks the mode, the search interval and every bootstrap address.
+func (c DHTConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, err := c.mode(); err != nil {
+		return err
+	}
+	if c.Namespace == "" {
+		return fmt.Errorf("dht namespace must not be empty")
+	}
+	if c.SearchInterval <= 0 {
+		return fmt.Errorf("dht search interval must be positive")
+	}
+	_, err := c.Bootstrap()
+	return err
+}
+
+func (c DHTConfig) mode() (dht.ModeOpt, error) {
+	switch strings.ToLower(c.Mode) {
+	case DHTModeAuto, "":
+		return dht.ModeAuto, nil
+	case DHTModeClient:
+		return dht.ModeClient, nil
+	case DHTModeServer:
+		return dht.ModeServer, nil
+	}
+	return 0, fmt.Errorf("unknown dht mode %q", c.Mode)
+}
+
+// Bootstrap parses BootstrapPeers, falling back to the public libp2p bootstrap nodes.
+func (c DHTConfig) Bootstrap() ([]peer.AddrInfo, error) {
+	if len(c.BootstrapPeers) == 0 {
+		return dht.GetDefaultBootstrapPeerAddrInfos(), nil
+	}
+
+	var addrs []multiaddr.Multiaddr
+	for _, s := range c.BootstrapPeers {
+		ma, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad bootstrap address %q: %w", s, err)
+		}
+		addrs = append(addrs, ma)
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, fmt.Errorf("bad bootstrap address: %w", err)
+	}
+	return infos, nil
+}
+
+// RoomKey is the rendezvous key a room is advertised under.
+func (c DHTConfig) RoomKey(roomName string) string {
+	return c.Namespace + "/" + TopicName(roomName)
+}
+
+// Rendezvous advertises our rooms on the DHT and connects to other nodes advertising them.
+type Rendezvous struct {
+	ctx  context.Context
+	stop context.CancelFunc
+	h    host.Host
+	cfg  DHTConfig
+	dht  *dht.IpfsDHT
+	disc *drouting.RoutingDiscovery
+
+	mu    sync.Mutex
+	rooms map[string]context.CancelFunc
+}
+
+// StartDHT joins the DHT through the configured bootstrap peers.
+// It returns once at least one bootstrap peer is connected, or all of them failed.
+func StartDHT(ctx context.Context, h host.Host, cfg DHTConfig) (*Rendezvous, error) {
+	bootstrap, err := cfg.Bootstrap()
+	if err != nil {
+		return nil, err
+	}
+	return startDHT(ctx, h, cfg, bootstrap)
+}
+
+func startDHT(ctx context.Context, h host.Host, cfg DHTConfig, bootstrap []peer.AddrInfo) (*Rendezvous, error) {
+	mode, err := cfg.mode()
+	if err != nil {
+		return nil, err
+	}
+
+	kad, err := dht.New(ctx, h, dht.Mode(mode), dht.BootstrapPeers(bootstrap...))
+	if err != nil {
+		return nil, err
+	}
+	if err := kad.Bootstrap(ctx); err != nil {
+		kad.Close()
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	var connected int
+	var mu sync.Mutex
+	for _, pi := range bootstrap {
+		pi := pi
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Connect(ctx, pi); err != nil {
+				log.Printf("dht: bootstrap %s: %v", pi.ID, err)
+				return
+			}
+			mu.Lock()
+			connected++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if connected == 0 && len(bootstrap) > 0 {
+		log.Println("dht: no bootstrap peer reachable, rooms will only be found once another peer connects")
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	return &Rendezvous{
+		ctx:   ctx,
+		stop:  stop,
+		h:     h,
+		cfg:   cfg,
+		dht:   kad,
+		disc:  drouting.NewRoutingDiscovery(kad),
+		rooms: make(map[string]context.CancelFunc),
+	}, nil
+}
+
+// Close stops advertising and searching and shuts the DHT down.
+func (r *Rendezvous) Close() {
+	r.stop()
+	r.dht.Close()
+}
+
+// RoutingTableSize is how many DHT peers we know.
+func (r *Rendezvous) RoutingTableSize() int {
+	return r.dht.RoutingTable().Size()
+}
+
+// Advertise announces that we are in roomName and starts looking for others who are.
+func (r *Rendezvous) Advertise(roomName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rooms[roomName]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.rooms[roomName] = cancel
+
+	key := r.cfg.RoomKey(roomName)
+	go r.advertiseLoop(ctx, key)
+	go r.searchLoop(ctx, key)
+}
+
+// Withdraw stops advertising roomName. The DHT forgets our record once it expires.
+func (r *Rendezvous) Withdraw(roomName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.rooms[roomName]; ok {
+		cancel()
+		delete(r.rooms, roomName)
+	}
+}
+
+// advertiseLoop keeps our record for key alive, retrying at the search interval
+// while the routing table is still empty.
+func (r *Rendezvous) advertiseLoop(ctx context.Context, key string) {
+	for {
+		wait := time.Duration(r.cfg.SearchInterval)
+		ttl, err := r.disc.Advertise(ctx, key)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("dht: advertising %s: %v", key, err)
+		} else {
+			wait = 7 * ttl / 8
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Rendezvous) searchLoop(ctx context.Context, key string) {
+	ticker := time.NewTicker(time.Duration(r.cfg.SearchInterval))
+	defer ticker.Stop()
+
+	for {
+		r.search(ctx, key)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// search connects to every advertiser of key we are not connected to yet.
+func (r *Rendezvous) search(ctx context.Context, key string) {
+	peers, err := r.disc.FindPeers(ctx, key)
+	if err != nil {
+		log.Printf("dht: searching %s: %v", key, err)
+		return
+	}
+	for pi := range peers {
+		if pi.ID == r.h.ID() || len(pi.Addrs) == 0 {
+			continue
+		}
+		if r.h.Network().Connectedness(pi.ID) == network.Connected {
+			continue
+		}
+		if err := r.h.Connect(ctx, pi); err != nil {
+			log.Printf("dht: connecting to %s from %s: %v", pi.ID, key, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+)
+
+func newTestHost(t *testing.T) host.Host {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func p2pAddr(h host.Host) string {
+	addrs, _ := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	return addrs[0].String()
+}
+
+// TestRendezvous runs a bootstrap node and three members on loopback.
+// Two of them join the same room and must find each other through the DHT alone.
+func TestRendezvous(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := DefaultDHTConfig()
+	cfg.Enabled = true
+	cfg.Mode = DHTModeServer
+	cfg.SearchInterval = Duration(200 * time.Millisecond)
+
+	boot := newTestHost(t)
+	bootRdv, err := startDHT(ctx, boot, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bootRdv.Close()
+
+	cfg.BootstrapPeers = []string{p2pAddr(boot)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rdvs []*Rendezvous
+	for i := 0; i < 3; i++ {
+		rdv, err := StartDHT(ctx, newTestHost(t), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rdv.Close()
+		rdvs = append(rdvs, rdv)
+	}
+	a, b, c := rdvs[0], rdvs[1], rdvs[2]
+	a.Advertise("lobby")
+	b.Advertise("lobby")
+
+	// Routing table refreshes may connect everyone anyway, so look at the
+	// advertisers a finds rather than at who it is connected to.
+	key := cfg.RoomKey("lobby")
+	var foundB bool
+	for {
+		found, err := dutil.FindPeers(ctx, a.disc, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pi := range found {
+			if pi.ID == c.h.ID() {
+				t.Fatal("found a node that never joined the room")
+			}
+			foundB = foundB || pi.ID == b.h.ID()
+		}
+		if foundB && a.h.Network().Connectedness(b.h.ID()) == network.Connected {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("room members never found each other")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// DirectProtocol carries private one-to-one messages over a direct stream
+// instead of a room topic, so only the recipient ever sees them.
+const DirectProtocol = protocol.ID("/dangerousnet/dm/1.0.0")
+
+// maxDirectSize bounds what we read of a direct message stream.
+const maxDirectSize = 16 << 10
+
+// directTimeout bounds how long sending or reading one direct message may take.
+const directTimeout = 30 * time.Second
+
+// DirectMessage is one private message. From is filled in from the stream
+// and never trusted from the wire.
+type DirectMessage struct {
+	From    peer.ID `json:"-"`
+	Nick    string  `json:"nick"`
+	Message string  `json:"message"`
+}
+
+// ServeDirect hands every direct message sent to h to handle until RemoveDirect.
+func ServeDirect(h host.Host, handle func(DirectMessage)) {
+	h.SetStreamHandler(DirectProtocol, func(s network.Stream) {
+		defer s.Close()
+		s.SetReadDeadline(time.Now().Add(directTimeout))
+
+		var dm DirectMessage
+		if err := json.NewDecoder(io.LimitReader(s, maxDirectSize)).Decode(&dm); err != nil {
+			s.Reset()
+			return
+		}
+		if dm.Message == "" || !utf8.ValidString(dm.Message) || !utf8.ValidString(dm.Nick) ||
+			utf8.RuneCountInString(dm.Nick) > MaxNickLength {
+			s.Reset()
+			return
+		}
+		dm.From = s.Conn().RemotePeer()
+		handle(dm)
+	})
+}
+
+// RemoveDirect stops accepting direct messages.
+func RemoveDirect(h host.Host) {
+	h.RemoveStreamHandler(DirectProtocol)
+}
+
+// SendDirect sends message to p, connecting first if needed.
+func SendDirect(ctx context.Context, h host.Host, p peer.ID, nick, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, directTimeout)
+	defer cancel()
+
+	// p may only be reachable through a circuit relay, and a direct message
+	// is small enough to send over one.
+	s, err := h.NewStream(network.WithUseTransient(ctx, "direct message"), p, DirectProtocol)
+	if err != nil {
+		return fmt.Errorf("opening a direct stream to %s: %w", p.ShortString(), err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(s).Encode(DirectMessage{Nick: nick, Message: message}); err != nil {
+		s.Reset()
+		return err
+	}
+	// The protocol is negotiated lazily, so only the reply tells us p took the message.
+	s.CloseWrite()
+	if _, err := io.Copy(io.Discard, io.LimitReader(s, 1)); err != nil {
+		s.Reset()
+		return fmt.Errorf("%s did not take the message: %w", p.ShortString(), err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDirectMessage(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := newTestHost(t), newTestHost(t)
+
+	got := make(chan DirectMessage, 1)
+	ServeDirect(bob, func(dm DirectMessage) { got <- dm })
+
+	alice.Peerstore().AddAddrs(bob.ID(), bob.Addrs(), time.Minute)
+	if err := SendDirect(ctx, alice, bob.ID(), "alice", "psst"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case dm := <-got:
+		if dm.From != alice.ID() || dm.Nick != "alice" || dm.Message != "psst" {
+			t.Fatalf("got %+v", dm)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no direct message")
+	}
+
+	RemoveDirect(bob)
+	if err := SendDirect(ctx, alice, bob.ID(), "alice", "again"); err == nil {
+		t.Fatal("sent to a peer that stopped serving direct messages")
+	}
+}
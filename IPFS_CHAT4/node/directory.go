@@ -0,0 +1,372 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DirectoryTopic is the well-known topic rooms are announced on.
+const DirectoryTopic = "chat-directory"
+
+// maxAnnouncementSize bounds a single directory announcement on the wire.
+const maxAnnouncementSize = 1024
+
+// An announcement may describe its room in at most MaxDescriptionLength
+// bytes and claim at most maxClaimedMembers members. The member count is
+// only the announcer's word, so the directory ranks rooms by how many
+// peers announce them before it looks at it.
+const (
+	MaxDescriptionLength = 200
+	maxClaimedMembers    = 10000
+)
+
+// The directory lists at most maxDirectoryRooms rooms and remembers at most
+// maxRoomAnnouncers announcers per room, so a flood of announcements cannot
+// grow it without bound. Announcements past either cap are dropped until
+// others expire.
+const (
+	maxDirectoryRooms = 1000
+	maxRoomAnnouncers = 100
+)
+
+// RoomMeta is what we say about a room we announce.
+type RoomMeta struct {
+	Description string `json:"description"`
+	Private     bool   `json:"private"`
+}
+
+// DirectoryConfig controls room announcements.
+type DirectoryConfig struct {
+	Enabled          bool     `json:"enabled"`
+	AnnounceInterval Duration `json:"announceInterval"`
+	// TTL is how long an announcement stays listed without being repeated.
+	TTL Duration `json:"ttl"`
+	// Rooms is keyed by room name.
+	Rooms map[string]RoomMeta `json:"rooms"`
+}
+
+// DefaultDirectoryConfig announces every 30 seconds and lists rooms for two minutes.
+func DefaultDirectoryConfig() DirectoryConfig {
+	return DirectoryConfig{
+		Enabled:          true,
+		AnnounceInterval: Duration(30 * time.Second),
+		TTL:              Duration(2 * time.Minute),
+	}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *DirectoryConfig) BindFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "directory", c.Enabled, "announce joined rooms and browse the room directory")
+}
+
+// Validate checks that announcements are repeated before they expire and
+// that every room we describe would pass other nodes' validation.
+func (c DirectoryConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.AnnounceInterval <= 0 || c.TTL <= c.AnnounceInterval {
+		return fmt.Errorf("directory ttl (%s) must be longer than the announce interval (%s)", c.TTL, c.AnnounceInterval)
+	}
+	for name, meta := range c.Rooms {
+		if err := ValidateRoomName(name); err != nil {
+			return fmt.Errorf("directory: %w", err)
+		}
+		if err := validateDescription(meta.Description); err != nil {
+			return fmt.Errorf("directory room %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validateDescription bounds a room description and keeps it to one line
+// of printable text.
+func validateDescription(desc string) error {
+	if len(desc) > MaxDescriptionLength {
+		return fmt.Errorf("descriptions are at most %d bytes", MaxDescriptionLength)
+	}
+	if hasControl(desc) {
+		return fmt.Errorf("control characters in description")
+	}
+	return nil
+}
+
+// RoomAnnouncement is published on DirectoryTopic for each room a node takes part in.
+type RoomAnnouncement struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Members     int    `json:"members"`
+	Public      bool   `json:"public"`
+	// TTL is in seconds.
+	TTL       int    `json:"ttl"`
+	Announcer string `json:"announcer"`
+}
+
+// RoomInfo is a directory entry merged from every live announcement of one room.
+type RoomInfo struct {
+	Name        string
+	Description string
+	Members     int
+	Public      bool
+	Announcers  int
+	Expires     time.Time
+}
+
+type announcement struct {
+	RoomAnnouncement
+	expires time.Time
+}
+
+// Directory announces our rooms and collects everyone else's.
+type Directory struct {
+	ctx   context.Context
+	stop  context.CancelFunc
+	ps    *pubsub.PubSub
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+	self  peer.ID
+	cfg   DirectoryConfig
+
+	mu    sync.Mutex
+	local map[string]*ChatRoom
+	seen  map[string]map[peer.ID]announcement
+}
+
+// JoinDirectory subscribes to the directory topic and starts announcing.
+func JoinDirectory(ctx context.Context, ps *pubsub.PubSub, selfID peer.ID, cfg DirectoryConfig) (*Directory, error) {
+	if err := ps.RegisterTopicValidator(DirectoryTopic, validateAnnouncement); err != nil {
+		return nil, err
+	}
+
+	topic, err := ps.Join(DirectoryTopic)
+	if err != nil {
+		ps.UnregisterTopicValidator(DirectoryTopic)
+		return nil, err
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		ps.UnregisterTopicValidator(DirectoryTopic)
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	d := &Directory{
+		ctx:   ctx,
+		stop:  stop,
+		ps:    ps,
+		topic: topic,
+		sub:   sub,
+		self:  selfID,
+		cfg:   cfg,
+		local: make(map[string]*ChatRoom),
+		seen:  make(map[string]map[peer.ID]announcement),
+	}
+
+	go d.readLoop()
+	go d.announceLoop()
+	return d, nil
+}
+
+// Close stops announcing and leaves the directory topic.
+func (d *Directory) Close() {
+	d.stop()
+	d.sub.Cancel()
+	d.topic.Close()
+	d.ps.UnregisterTopicValidator(DirectoryTopic)
+}
+
+// Advertise adds a joined room to our announcements and announces it straight away.
+func (d *Directory) Advertise(room *ChatRoom) {
+	d.mu.Lock()
+	d.local[room.Name()] = room
+	d.mu.Unlock()
+
+	if err := d.announce(room); err != nil {
+		log.Println("directory: announcing", room.Name(), err)
+	}
+}
+
+// Withdraw stops announcing a room. Other nodes drop it once its TTL runs out.
+func (d *Directory) Withdraw(roomName string) {
+	d.mu.Lock()
+	delete(d.local, roomName)
+	d.mu.Unlock()
+}
+
+// Rooms lists the rooms with live announcements, those announced by the most
+// peers first and then the busiest.
+func (d *Directory) Rooms() []RoomInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pruneLocked(time.Now())
+
+	var rooms []RoomInfo
+	for name, byPeer := range d.seen {
+		var info RoomInfo
+		var newest time.Time
+		for _, a := range byPeer {
+			info.Announcers++
+			if a.Members > info.Members {
+				info.Members = a.Members
+			}
+			if a.expires.After(info.Expires) {
+				info.Expires = a.expires
+			}
+			// The most recently refreshed announcement describes the room.
+			if a.expires.After(newest) {
+				newest = a.expires
+				info.Description = a.Description
+				info.Public = a.Public
+			}
+		}
+		info.Name = name
+		rooms = append(rooms, info)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Announcers != rooms[j].Announcers {
+			return rooms[i].Announcers > rooms[j].Announcers
+		}
+		if rooms[i].Members != rooms[j].Members {
+			return rooms[i].Members > rooms[j].Members
+		}
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms
+}
+
+// pruneLocked drops expired announcements and the rooms left without any.
+func (d *Directory) pruneLocked(now time.Time) {
+	for name, byPeer := range d.seen {
+		for id, a := range byPeer {
+			if now.After(a.expires) {
+				delete(byPeer, id)
+			}
+		}
+		if len(byPeer) == 0 {
+			delete(d.seen, name)
+		}
+	}
+}
+
+// record lists an announcement from a peer until ttl runs out. It reports
+// false when the directory or the room is full.
+func (d *Directory) record(from peer.ID, a RoomAnnouncement, ttl time.Duration, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	full := func() bool {
+		byPeer, ok := d.seen[a.Name]
+		if !ok {
+			return len(d.seen) >= maxDirectoryRooms
+		}
+		_, refresh := byPeer[from]
+		return !refresh && len(byPeer) >= maxRoomAnnouncers
+	}
+	if full() {
+		d.pruneLocked(now)
+		if full() {
+			return false
+		}
+	}
+	byPeer, ok := d.seen[a.Name]
+	if !ok {
+		byPeer = make(map[peer.ID]announcement)
+		d.seen[a.Name] = byPeer
+	}
+	byPeer[from] = announcement{a, now.Add(ttl)}
+	return true
+}
+
+func (d *Directory) announceLoop() {
+	ticker := time.NewTicker(time.Duration(d.cfg.AnnounceInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.mu.Lock()
+			d.pruneLocked(time.Now())
+			rooms := make([]*ChatRoom, 0, len(d.local))
+			for _, room := range d.local {
+				rooms = append(rooms, room)
+			}
+			d.mu.Unlock()
+
+			for _, room := range rooms {
+				if err := d.announce(room); err != nil {
+					log.Println("directory: announcing", room.Name(), err)
+				}
+			}
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Directory) announce(room *ChatRoom) error {
+	meta := d.cfg.Rooms[room.Name()]
+	a := RoomAnnouncement{
+		Name:        room.Name(),
+		Description: meta.Description,
+		Members:     len(room.ListPeers()) + 1,
+		Public:      !meta.Private,
+		TTL:         int(time.Duration(d.cfg.TTL) / time.Second),
+		Announcer:   d.self.String(),
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return d.topic.Publish(d.ctx, data)
+}
+
+func (d *Directory) readLoop() {
+	for {
+		msg, err := d.sub.Next(d.ctx)
+		if err != nil {
+			return
+		}
+
+		var a RoomAnnouncement
+		if err := json.Unmarshal(msg.Data, &a); err != nil {
+			continue
+		}
+
+		// Never trust an announcement to stay listed longer than we would list our own.
+		ttl := time.Duration(a.TTL) * time.Second
+		if max := time.Duration(d.cfg.TTL); ttl > max {
+			ttl = max
+		}
+
+		d.record(msg.GetFrom(), a, ttl, time.Now())
+	}
+}
+
+func validateAnnouncement(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	if len(msg.Data) > maxAnnouncementSize {
+		return pubsub.ValidationReject
+	}
+	var a RoomAnnouncement
+	if err := json.Unmarshal(msg.Data, &a); err != nil {
+		return pubsub.ValidationReject
+	}
+	if ValidateRoomName(a.Name) != nil || validateDescription(a.Description) != nil {
+		return pubsub.ValidationReject
+	}
+	if a.TTL <= 0 || a.Members < 0 || a.Members > maxClaimedMembers || a.Announcer != msg.GetFrom().String() {
+		return pubsub.ValidationReject
+	}
+	return pubsub.ValidationAccept
+}
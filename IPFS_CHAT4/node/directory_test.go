@@ -0,0 +1,100 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestDirectoryCaps(t *testing.T) {
+	d := &Directory{seen: make(map[string]map[peer.ID]announcement)}
+	now := time.Now()
+
+	for i := 0; i < maxRoomAnnouncers; i++ {
+		if !d.record(peer.ID(fmt.Sprint("p", i)), RoomAnnouncement{Name: "lobby"}, time.Minute, now) {
+			t.Fatalf("announcer %d refused", i)
+		}
+	}
+	if d.record("late", RoomAnnouncement{Name: "lobby"}, time.Minute, now) {
+		t.Fatal("room took more than maxRoomAnnouncers announcers")
+	}
+	if !d.record("p0", RoomAnnouncement{Name: "lobby", Members: 3}, time.Minute, now) {
+		t.Fatal("a known announcer could not refresh")
+	}
+	// Once the others expire there is room again.
+	if !d.record("late", RoomAnnouncement{Name: "lobby"}, time.Minute, now.Add(2*time.Minute)) {
+		t.Fatal("expired announcers were not pruned")
+	}
+
+	for i := len(d.seen); i < maxDirectoryRooms; i++ {
+		d.record("p", RoomAnnouncement{Name: fmt.Sprint("room", i)}, time.Hour, now)
+	}
+	if d.record("p", RoomAnnouncement{Name: "one-too-many"}, time.Minute, now) {
+		t.Fatal("directory took more than maxDirectoryRooms rooms")
+	}
+
+	d.mu.Lock()
+	d.pruneLocked(now.Add(2 * time.Hour))
+	n := len(d.seen)
+	d.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d rooms left after every announcement expired", n)
+	}
+}
+
+func TestValidateAnnouncement(t *testing.T) {
+	alice := peer.ID("alice")
+	ok := RoomAnnouncement{Name: "lobby", Description: "general chat", Members: 3, TTL: 60, Announcer: alice.String()}
+
+	tests := []struct {
+		name   string
+		modify func(a *RoomAnnouncement)
+		want   pubsub.ValidationResult
+	}{
+		{"valid", func(a *RoomAnnouncement) {}, pubsub.ValidationAccept},
+		{"empty name", func(a *RoomAnnouncement) { a.Name = "" }, pubsub.ValidationReject},
+		{"spaced name", func(a *RoomAnnouncement) { a.Name = "big room" }, pubsub.ValidationReject},
+		{"control name", func(a *RoomAnnouncement) { a.Name = "lobby\x1b[2J" }, pubsub.ValidationReject},
+		{"long description", func(a *RoomAnnouncement) { a.Description = strings.Repeat("x", MaxDescriptionLength+1) }, pubsub.ValidationReject},
+		{"control description", func(a *RoomAnnouncement) { a.Description = "hi\nthere" }, pubsub.ValidationReject},
+		{"negative members", func(a *RoomAnnouncement) { a.Members = -1 }, pubsub.ValidationReject},
+		{"inflated members", func(a *RoomAnnouncement) { a.Members = 1e9 }, pubsub.ValidationReject},
+		{"no ttl", func(a *RoomAnnouncement) { a.TTL = 0 }, pubsub.ValidationReject},
+		{"spoofed", func(a *RoomAnnouncement) { a.Announcer = "mallory" }, pubsub.ValidationReject},
+	}
+	for _, tt := range tests {
+		a := ok
+		tt.modify(&a)
+		data, err := json.Marshal(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &pubsub.Message{Message: &pb.Message{From: []byte(alice), Data: data}}
+		if got := validateAnnouncement(context.Background(), alice, msg); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDirectoryRanksByAnnouncers(t *testing.T) {
+	d := &Directory{seen: make(map[string]map[peer.ID]announcement)}
+	now := time.Now()
+
+	// One peer claiming a crowd does not outrank a room several peers announce.
+	d.record("mallory", RoomAnnouncement{Name: "spam", Members: maxClaimedMembers}, time.Minute, now)
+	for _, p := range []peer.ID{"alice", "bob", "carol"} {
+		d.record(p, RoomAnnouncement{Name: "lobby", Members: 3}, time.Minute, now)
+	}
+
+	rooms := d.Rooms()
+	if len(rooms) != 2 || rooms[0].Name != "lobby" || rooms[0].Announcers != 3 {
+		t.Fatalf("got %+v, want lobby first with 3 announcers", rooms)
+	}
+}
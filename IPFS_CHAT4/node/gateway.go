@@ -0,0 +1,101 @@
+package node
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GatewayTokenFile holds the generated gateway token in the profile directory.
+const GatewayTokenFile = "gateway.token"
+
+// GatewayConfig sets up the daemon's HTTP and WebSocket gateway, which lets
+// browser front-ends use the node as their backend.
+type GatewayConfig struct {
+	// Listen is the host:port to serve on. Empty turns the gateway off.
+	Listen string `json:"listen"`
+	// Token is the bearer token clients must send. When empty one is
+	// generated and kept in GatewayTokenFile.
+	Token string `json:"token"`
+	// AllowedOrigins may call the gateway from a browser, e.g.
+	// "http://localhost:3000". "*" allows any origin.
+	AllowedOrigins []string `json:"allowedOrigins"`
+}
+
+// DefaultGatewayConfig leaves the gateway off.
+func DefaultGatewayConfig() GatewayConfig {
+	return GatewayConfig{}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *GatewayConfig) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "http", c.Listen, "serve the HTTP gateway on this host:port, e.g. 127.0.0.1:8080")
+	fs.StringVar(&c.Token, "http-token", c.Token, "bearer token for the HTTP gateway, generated when empty")
+	fs.Func("http-origins", "comma separated origins allowed to use the HTTP gateway from a browser", func(s string) error {
+		c.AllowedOrigins = splitList(s)
+		return nil
+	})
+}
+
+// Validate checks the listen address and origins.
+func (c GatewayConfig) Validate() error {
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("gateway listen address %q: %w", c.Listen, err)
+		}
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("gateway origin %q must look like http://host[:port]", o)
+		}
+	}
+	return nil
+}
+
+// TokenPath is where the generated token is kept.
+func (c GatewayConfig) TokenPath(profileDir string) string {
+	return filepath.Join(profileDir, GatewayTokenFile)
+}
+
+// LoadToken returns the configured token, or the one in the profile
+// directory, generating it on first use.
+func (c GatewayConfig) LoadToken(profileDir string) (string, error) {
+	if c.Token != "" {
+		return c.Token, nil
+	}
+	path := c.TokenPath(profileDir)
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(b)); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("%s is empty, delete it to generate a new token", path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	if err := os.MkdirAll(profileDir, 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
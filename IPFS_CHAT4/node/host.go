@@ -0,0 +1,166 @@
+package node
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ListenConfig says where the host listens and which addresses it tells other peers about.
+type ListenConfig struct {
+	// Listen replaces DefaultListenAddrs when set.
+	Listen []string `json:"listen"`
+	// Announce replaces the addresses we advertise, e.g. a public IP behind port forwarding.
+	Announce []string `json:"announce"`
+	// AppendAnnounce is advertised in addition to the addresses the host finds itself.
+	AppendAnnounce []string `json:"appendAnnounce"`
+}
+
+// DefaultListenConfig listens on DefaultListenAddrs and announces what the host finds.
+func DefaultListenConfig() ListenConfig {
+	return ListenConfig{}
+}
+
+// DefaultListenAddrs covers TCP, QUIC-v1, WebTransport and WebSocket on IPv4 and IPv6.
+// QUIC and WebTransport share the UDP port. WebSocket needs its own TCP port,
+// so it takes port+1, or a random port like everything else when port is 0.
+func DefaultListenAddrs(port int) []string {
+	wsPort := 0
+	if port != 0 {
+		wsPort = port + 1
+	}
+
+	var addrs []string
+	for _, ip := range []string{"/ip4/0.0.0.0", "/ip6/::"} {
+		addrs = append(addrs,
+			fmt.Sprintf("%s/tcp/%d", ip, port),
+			fmt.Sprintf("%s/udp/%d/quic-v1", ip, port),
+			fmt.Sprintf("%s/udp/%d/quic-v1/webtransport", ip, port),
+			fmt.Sprintf("%s/tcp/%d/ws", ip, wsPort),
+		)
+	}
+	return addrs
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *ListenConfig) BindFlags(fs *flag.FlagSet) {
+	fs.Func("listen", "comma separated multiaddrs to listen on (default: TCP, QUIC, WebTransport and WebSocket on IPv4 and IPv6)", func(s string) error {
+		c.Listen = splitList(s)
+		return nil
+	})
+	fs.Func("announce", "comma separated multiaddrs to advertise instead of the ones the host finds", func(s string) error {
+		c.Announce = splitList(s)
+		return nil
+	})
+}
+
+// Validate checks that every address parses.
+func (c ListenConfig) Validate() error {
+	for _, list := range [][]string{c.Listen, c.Announce, c.AppendAnnounce} {
+		if _, err := parseAddrs(list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListenAddrs is Listen, or DefaultListenAddrs(port) when Listen is empty.
+func (c ListenConfig) ListenAddrs(port int) []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return DefaultListenAddrs(port)
+}
+
+// Options returns the listen and address announcement options for libp2p.New.
+func (c ListenConfig) Options(port int) ([]libp2p.Option, error) {
+	opts := []libp2p.Option{libp2p.ListenAddrStrings(c.ListenAddrs(port)...)}
+
+	announce, err := parseAddrs(c.Announce)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := parseAddrs(c.AppendAnnounce)
+	if err != nil {
+		return nil, err
+	}
+	if len(announce) == 0 && len(extra) == 0 {
+		return opts, nil
+	}
+
+	return append(opts, libp2p.AddrsFactory(func(found []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		out := found
+		if len(announce) > 0 {
+			out = announce
+		}
+		return append(out[:len(out):len(out)], extra...)
+	})), nil
+}
+
+// HostOptions builds the libp2p.New options for cfg, listening on port where
+// no explicit listen addresses are configured. A non-nil psk puts the host on
+// that private network and leaves out the transports it cannot use.
+func (c Config) HostOptions(port int, psk pnet.PSK) ([]libp2p.Option, error) {
+	listen := c.Listen
+	if psk != nil {
+		listen.Listen = privateListenAddrs(listen.ListenAddrs(port))
+	}
+	opts, err := listen.Options(port)
+	if err != nil {
+		return nil, err
+	}
+	if psk != nil {
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+	}
+	nat, err := c.NAT.Options()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := c.Limits.Options()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, nat...)
+	return append(opts, limits...), nil
+}
+
+// DialAddrs lists the full /p2p addresses other peers can dial h on.
+func DialAddrs(h host.Host) []string {
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	if err != nil {
+		return nil
+	}
+	out := make([]string, len(addrs))
+	for i, a := range addrs {
+		out[i] = a.String()
+	}
+	return out
+}
+
+func parseAddrs(list []string) ([]multiaddr.Multiaddr, error) {
+	var out []multiaddr.Multiaddr
+	for _, s := range list {
+		a, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad multiaddr %q: %w", s, err)
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,323 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config file names in the profile directory. YAML is a superset of JSON,
+// so a config.json from before YAML support still loads.
+const (
+	ConfigFile       = "config.yaml"
+	legacyConfigFile = "config.json"
+)
+
+// EnvPrefix starts the environment variables that override the config file.
+// The rest of the name is the setting's key in upper snake case, so
+// router.historyLength is DNCHAT_ROUTER_HISTORY_LENGTH. Lists are comma
+// separated and maps are JSON.
+const EnvPrefix = "DNCHAT_"
+
+// Where a setting came from, lowest priority first.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// ConfigPath is the config file in profileDir: config.yaml, or config.json
+// when only that one exists.
+func ConfigPath(profileDir string) string {
+	path := filepath.Join(profileDir, ConfigFile)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		legacy := filepath.Join(profileDir, legacyConfigFile)
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy
+		}
+	}
+	return path
+}
+
+// Setting is one effective config value and where it came from.
+type Setting struct {
+	// Key is the dotted path in the config file, e.g. "router.d".
+	Key   string
+	Value string
+	// Source is SourceDefault, SourceFile, SourceEnv or SourceFlag.
+	Source string
+	// Origin names the variable or flag that set the value, if any.
+	Origin string
+}
+
+// Layers remembers where each setting of a loaded Config came from:
+// defaults, then the file, then the environment, then flags.
+type Layers struct {
+	// Path is the config file read, whether or not it exists.
+	Path string
+
+	settings   map[string]Setting
+	beforeFlag Config
+}
+
+// LoadLayers reads the config file at path over DefaultConfig and applies
+// the environment overrides. A missing file is not an error.
+func LoadLayers(path string) (Config, *Layers, error) {
+	cfg := DefaultConfig()
+	l := &Layers{Path: path, settings: make(map[string]Setting)}
+	eachSetting(&cfg, func(key string, _ reflect.Value) {
+		l.settings[key] = Setting{Key: key, Source: SourceDefault}
+	})
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil, err
+	}
+	if len(data) > 0 {
+		if err := l.loadFile(&cfg, data); err != nil {
+			return cfg, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var envErr error
+	eachSetting(&cfg, func(key string, v reflect.Value) {
+		name := EnvName(key)
+		s, ok := os.LookupEnv(name)
+		if !ok || envErr != nil {
+			return
+		}
+		if err := setFromString(v, s); err != nil {
+			envErr = fmt.Errorf("%s: %w", name, err)
+			return
+		}
+		l.settings[key] = Setting{Key: key, Source: SourceEnv, Origin: name}
+	})
+	if envErr != nil {
+		return cfg, nil, envErr
+	}
+
+	l.beforeFlag = cfg
+	return cfg, l, nil
+}
+
+// loadFile decodes the YAML (or JSON) config over cfg and marks every key it
+// sets. Keys that are not settings, usually typos, are an error.
+func (l *Layers) loadFile(cfg *Config, data []byte) error {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if unknown := unknownKeys(raw, reflect.TypeOf(*cfg), ""); len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings %s", strings.Join(unknown, ", "))
+	}
+	// Going through JSON reuses the json tags and the Duration text format.
+	js, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return err
+	}
+
+	eachSetting(cfg, func(key string, _ reflect.Value) {
+		if lookupKey(raw, key) {
+			l.settings[key] = Setting{Key: key, Source: SourceFile, Origin: l.Path}
+		}
+	})
+	return nil
+}
+
+// Flags records the flags that were set on fs. cfg must be the Config
+// returned by LoadLayers, bound to fs and parsed.
+func (l *Layers) Flags(fs *flag.FlagSet, cfg *Config) {
+	byAddr := make(map[uintptr]string)
+	eachSetting(cfg, func(key string, v reflect.Value) {
+		byAddr[v.UnsafeAddr()] = key
+	})
+
+	// Most flags point straight at their setting; the rest are found by what changed.
+	var unresolved []string
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := byAddr[flagTarget(f.Value)]; ok {
+			l.settings[key] = Setting{Key: key, Source: SourceFlag, Origin: "-" + f.Name}
+			return
+		}
+		unresolved = append(unresolved, "-"+f.Name)
+	})
+	before := make(map[string]interface{})
+	eachSetting(&l.beforeFlag, func(key string, v reflect.Value) {
+		before[key] = v.Interface()
+	})
+	eachSetting(cfg, func(key string, v reflect.Value) {
+		if l.settings[key].Source == SourceFlag {
+			return
+		}
+		if !reflect.DeepEqual(v.Interface(), before[key]) {
+			s := Setting{Key: key, Source: SourceFlag}
+			if len(unresolved) == 1 {
+				s.Origin = unresolved[0]
+			}
+			l.settings[key] = s
+		}
+	})
+}
+
+// Settings lists every setting of cfg with where it came from, in file order.
+func (l *Layers) Settings(cfg Config) []Setting {
+	var out []Setting
+	eachSetting(&cfg, func(key string, v reflect.Value) {
+		s := l.settings[key]
+		s.Key = key
+		if s.Source == "" {
+			s.Source = SourceDefault
+		}
+		js, err := json.Marshal(v.Interface())
+		switch {
+		case v.Kind() == reflect.Slice && v.IsNil():
+			s.Value = "[]"
+		case v.Kind() == reflect.Map && v.IsNil():
+			s.Value = "{}"
+		case err != nil:
+			s.Value = fmt.Sprint(v.Interface())
+		default:
+			s.Value = string(js)
+		}
+		out = append(out, s)
+	})
+	return out
+}
+
+// EnvName is the environment variable overriding the setting key.
+func EnvName(key string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, part := range strings.Split(key, ".") {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		for j, r := range part {
+			if j > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(part[j-1])) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// configPkg is this package's path, to tell our config sections, which are
+// walked into, from structs of other packages, which are single settings.
+var configPkg = reflect.TypeOf(Config{}).PkgPath()
+
+// eachSetting calls fn with the key and addressable value of every setting in cfg.
+func eachSetting(cfg *Config, fn func(key string, v reflect.Value)) {
+	walkSettings(reflect.ValueOf(cfg).Elem(), "", fn)
+}
+
+func walkSettings(v reflect.Value, prefix string, fn func(string, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if f.Type.Kind() == reflect.Struct && f.Type.PkgPath() == configPkg {
+			walkSettings(v.Field(i), key+".", fn)
+			continue
+		}
+		fn(key, v.Field(i))
+	}
+}
+
+// unknownKeys lists the dotted keys in raw that are not settings of the
+// config section t. Settings that are maps, such as chat.themes, take any key.
+func unknownKeys(raw map[string]interface{}, t reflect.Type, prefix string) []string {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+	var out []string
+	for key, v := range raw {
+		ft, ok := fields[key]
+		if !ok {
+			out = append(out, prefix+key)
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok && ft.Kind() == reflect.Struct && ft.PkgPath() == configPkg {
+			out = append(out, unknownKeys(sub, ft, prefix+key+".")...)
+		}
+	}
+	return out
+}
+
+// lookupKey reports whether the decoded file sets the dotted key.
+func lookupKey(raw map[string]interface{}, key string) bool {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		v, ok := raw[part]
+		if !ok {
+			return false
+		}
+		if i == len(parts)-1 {
+			return true
+		}
+		if raw, ok = v.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return false
+}
+
+// setFromString sets a setting from an environment variable: strings as is,
+// lists comma separated unless written as JSON, everything else as JSON or text.
+func setFromString(v reflect.Value, s string) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "["):
+		v.Set(reflect.ValueOf(splitList(s)).Convert(v.Type()))
+		return nil
+	}
+
+	ptr := v.Addr().Interface()
+	if err := json.Unmarshal([]byte(s), ptr); err != nil {
+		// Durations and other text values are JSON strings.
+		if json.Unmarshal([]byte(strconv.Quote(s)), ptr) != nil {
+			return fmt.Errorf("%q is not a valid %s", s, v.Type())
+		}
+	}
+	return nil
+}
+
+// flagTarget is the address of the variable a flag writes to, or 0 when
+// that cannot be told, e.g. for flag.Func.
+func flagTarget(value flag.Value) uintptr {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return 0
+	}
+	if e := v.Elem(); e.Kind() == reflect.Struct && e.NumField() > 0 && e.Field(0).Kind() == reflect.Pointer {
+		// Wrappers such as durationFlag hold a pointer to the setting.
+		return e.Field(0).Pointer()
+	}
+	return v.Pointer()
+}
@@ -0,0 +1,123 @@
+package node
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigLayers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ConfigFile)
+	err := os.WriteFile(path, []byte(`
+chat:
+  nick: alice
+  rooms: [lobby, dev]
+router:
+  d: 8
+  dlo: 4
+  heartbeat: 700ms
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DNCHAT_ROUTER_DLO", "3")
+	t.Setenv("DNCHAT_MDNS_SERVICE_TAG", "test-tag")
+	t.Setenv("DNCHAT_LISTEN_LISTEN", "/ip4/127.0.0.1/tcp/0, /ip4/127.0.0.1/tcp/0/ws")
+
+	cfg, layers, err := LoadLayers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.BindFlags(fs)
+	if err := fs.Parse([]string{"-nick", "bob", "-gossip-heartbeat", "2s", "-join", "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	layers.Flags(fs, &cfg)
+
+	if cfg.Chat.Nick != "bob" || cfg.Router.D != 8 || cfg.Router.Dlo != 3 ||
+		time.Duration(cfg.Router.Heartbeat) != 2*time.Second || cfg.MDNS.ServiceTag != "test-tag" ||
+		!reflect.DeepEqual(cfg.Chat.Rooms, []string{"ops"}) || len(cfg.Listen.Listen) != 2 {
+		t.Fatalf("layers applied wrong: %+v", cfg)
+	}
+
+	want := map[string]string{
+		"chat.nick":        SourceFlag + " -nick",
+		"chat.rooms":       SourceFlag + " -join",
+		"router.d":         SourceFile,
+		"router.dlo":       SourceEnv + " DNCHAT_ROUTER_DLO",
+		"router.heartbeat": SourceFlag + " -gossip-heartbeat",
+		"mdns.serviceTag":  SourceEnv + " DNCHAT_MDNS_SERVICE_TAG",
+		"listen.listen":    SourceEnv + " DNCHAT_LISTEN_LISTEN",
+		"router.dhi":       SourceDefault,
+	}
+	for _, s := range layers.Settings(cfg) {
+		w, ok := want[s.Key]
+		if !ok {
+			continue
+		}
+		got := s.Source
+		if s.Origin != "" && s.Source != SourceFile {
+			got += " " + s.Origin
+		}
+		if got != w {
+			t.Errorf("%s came from %q, want %q", s.Key, got, w)
+		}
+		delete(want, s.Key)
+	}
+	if len(want) > 0 {
+		t.Errorf("settings missing: %v", want)
+	}
+
+	t.Setenv("DNCHAT_ROUTER_D", "many")
+	if _, _, err := LoadLayers(path); err == nil {
+		t.Fatal("bad environment value accepted")
+	}
+}
+
+func TestLegacyConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	if got := ConfigPath(dir); got != filepath.Join(dir, ConfigFile) {
+		t.Fatalf("config path %s", got)
+	}
+
+	legacy := filepath.Join(dir, legacyConfigFile)
+	if err := os.WriteFile(legacy, []byte(`{"router": {"router": "floodsub"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := ConfigPath(dir); got != legacy {
+		t.Fatalf("config path %s, want the existing %s", got, legacy)
+	}
+	cfg, err := LoadConfig(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Router.Router != RouterFloodSub {
+		t.Fatalf("router %q", cfg.Router.Router)
+	}
+}
+
+func TestUnknownConfigKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ConfigFile)
+	err := os.WriteFile(path, []byte(`
+chat:
+  nick: alice
+  themes:
+    mine: {nick: red}
+router:
+  histroyLength: 5
+colour: never
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = LoadLayers(path)
+	if err == nil || !strings.Contains(err.Error(), "colour, router.histroyLength") {
+		t.Fatalf("got %v, want both unknown keys reported", err)
+	}
+}
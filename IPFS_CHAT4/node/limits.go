@@ -0,0 +1,231 @@
+package node
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	bconnmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+// LimitsConfig bounds how many connections the node keeps and what resources
+// libp2p may use. Resource limits left at "default" are scaled to this machine.
+type LimitsConfig struct {
+	// The connection manager trims back to LowWater once HighWater is passed,
+	// sparing connections younger than GracePeriod and protected peers.
+	LowWater    int      `json:"lowWater"`
+	HighWater   int      `json:"highWater"`
+	GracePeriod Duration `json:"gracePeriod"`
+
+	System          rcmgr.ResourceLimits            `json:"system"`
+	ProtocolDefault rcmgr.ResourceLimits            `json:"protocolDefault"`
+	Protocol        map[string]rcmgr.ResourceLimits `json:"protocol"`
+	PeerDefault     rcmgr.ResourceLimits            `json:"peerDefault"`
+}
+
+// DefaultLimitsConfig keeps 100 to 400 connections and scales resource limits to the machine.
+func DefaultLimitsConfig() LimitsConfig {
+	return LimitsConfig{
+		LowWater:    100,
+		HighWater:   400,
+		GracePeriod: Duration(time.Minute),
+	}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *LimitsConfig) BindFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.LowWater, "conn-low", c.LowWater, "connection manager low watermark")
+	fs.IntVar(&c.HighWater, "conn-high", c.HighWater, "connection manager high watermark")
+}
+
+// Validate checks the watermarks.
+func (c LimitsConfig) Validate() error {
+	if c.LowWater < 0 || c.HighWater <= c.LowWater {
+		return fmt.Errorf("connection watermarks must satisfy 0 <= low (%d) < high (%d)", c.LowWater, c.HighWater)
+	}
+	if c.GracePeriod < 0 {
+		return fmt.Errorf("connection grace period must not be negative")
+	}
+	return nil
+}
+
+// Concrete fills every limit left at default from the libp2p defaults for this machine.
+func (c LimitsConfig) Concrete() rcmgr.ConcreteLimitConfig {
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+
+	partial := rcmgr.PartialLimitConfig{
+		System:          c.System,
+		ProtocolDefault: c.ProtocolDefault,
+		PeerDefault:     c.PeerDefault,
+	}
+	if len(c.Protocol) > 0 {
+		partial.Protocol = make(map[protocol.ID]rcmgr.ResourceLimits, len(c.Protocol))
+		for id, l := range c.Protocol {
+			partial.Protocol[protocol.ID(id)] = l
+		}
+	}
+	return partial.Build(scaling.AutoScale())
+}
+
+// Options returns the connection and resource manager options for libp2p.New.
+func (c LimitsConfig) Options() ([]libp2p.Option, error) {
+	cm, err := bconnmgr.NewConnManager(c.LowWater, c.HighWater, bconnmgr.WithGracePeriod(time.Duration(c.GracePeriod)))
+	if err != nil {
+		return nil, err
+	}
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(c.Concrete()))
+	if err != nil {
+		return nil, err
+	}
+	return []libp2p.Option{libp2p.ConnectionManager(cm), libp2p.ResourceManager(rm)}, nil
+}
+
+// ScopeUsage is the use of one resource manager scope next to its limits.
+// A limit of -1 means unlimited.
+type ScopeUsage struct {
+	Scope                 string
+	Conns, ConnsLimit     int
+	Streams, StreamsLimit int
+	FD, FDLimit           int
+	Memory, MemoryLimit   int64
+}
+
+// maxPeerUsage is how many of the busiest peers Usage reports.
+const maxPeerUsage = 5
+
+// Usage reports the system, transient and per-protocol scopes of h and its busiest peers.
+func (c LimitsConfig) Usage(h host.Host) []ScopeUsage {
+	rm := h.Network().ResourceManager()
+	limits := c.Concrete().ToPartialLimitConfig()
+
+	var out []ScopeUsage
+	rm.ViewSystem(func(s network.ResourceScope) error {
+		out = append(out, scopeUsage("system", s.Stat(), limits.System))
+		return nil
+	})
+	rm.ViewTransient(func(s network.ResourceScope) error {
+		out = append(out, scopeUsage("transient", s.Stat(), limits.Transient))
+		return nil
+	})
+
+	state, ok := rm.(rcmgr.ResourceManagerState)
+	if !ok {
+		return out
+	}
+
+	protos := state.ListProtocols()
+	sort.Slice(protos, func(i, j int) bool { return protos[i] < protos[j] })
+	for _, p := range protos {
+		l, ok := limits.Protocol[p]
+		if !ok {
+			l = limits.ProtocolDefault
+		}
+		rm.ViewProtocol(p, func(s network.ProtocolScope) error {
+			out = append(out, scopeUsage("protocol "+string(p), s.Stat(), l))
+			return nil
+		})
+	}
+
+	var peers []ScopeUsage
+	for _, p := range state.ListPeers() {
+		l, ok := limits.Peer[p]
+		if !ok {
+			l = limits.PeerDefault
+		}
+		rm.ViewPeer(p, func(s network.PeerScope) error {
+			peers = append(peers, scopeUsage("peer "+p.ShortString(), s.Stat(), l))
+			return nil
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].Streams != peers[j].Streams {
+			return peers[i].Streams > peers[j].Streams
+		}
+		return peers[i].Memory > peers[j].Memory
+	})
+	if len(peers) > maxPeerUsage {
+		peers = peers[:maxPeerUsage]
+	}
+	return append(out, peers...)
+}
+
+func scopeUsage(name string, st network.ScopeStat, l rcmgr.ResourceLimits) ScopeUsage {
+	b := l.Build(rcmgr.BaseLimit{})
+	return ScopeUsage{
+		Scope:        name,
+		Conns:        st.NumConnsInbound + st.NumConnsOutbound,
+		ConnsLimit:   limitOrUnlimited(b.Conns),
+		Streams:      st.NumStreamsInbound + st.NumStreamsOutbound,
+		StreamsLimit: limitOrUnlimited(b.Streams),
+		FD:           st.NumFD,
+		FDLimit:      limitOrUnlimited(b.FD),
+		Memory:       st.Memory,
+		MemoryLimit:  int64(limitOrUnlimited(int(b.Memory))),
+	}
+}
+
+func limitOrUnlimited(n int) int {
+	if n == math.MaxInt || n == math.MaxInt64 {
+		return -1
+	}
+	return n
+}
+
+// roomProtectTag is the connection manager tag protecting peers in a room.
+func roomProtectTag(roomName string) string {
+	return "room:" + roomName
+}
+
+// ProtectPeers keeps the connection manager from trimming anyone in the room
+// for as long as we stay in it. Call it at most once per room.
+func (cr *ChatRoom) ProtectPeers(cm connmgr.ConnManager) error {
+	events, err := cr.topic.EventHandler()
+	if err != nil {
+		return err
+	}
+	cr.protecting = make(chan struct{})
+
+	tag := roomProtectTag(cr.roomName)
+	protected := make(map[peer.ID]struct{})
+	for _, p := range cr.ListPeers() {
+		cm.Protect(p, tag)
+		protected[p] = struct{}{}
+	}
+
+	go func() {
+		defer close(cr.protecting)
+		defer events.Cancel()
+		defer func() {
+			for p := range protected {
+				cm.Unprotect(p, tag)
+			}
+		}()
+
+		for {
+			ev, err := events.NextPeerEvent(cr.ctx)
+			if err != nil {
+				return
+			}
+			switch ev.Type {
+			case pubsub.PeerJoin:
+				cm.Protect(ev.Peer, tag)
+				protected[ev.Peer] = struct{}{}
+			case pubsub.PeerLeave:
+				cm.Unprotect(ev.Peer, tag)
+				delete(protected, ev.Peer)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,68 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestProtectRoomPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := DefaultConfig()
+	limits, err := cfg.Limits.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := libp2p.New(append(limits, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	bob := newTestHost(t)
+
+	var rooms []*ChatRoom
+	for i, h := range []host.Host{alice, bob} {
+		ps, err := NewPubSub(ctx, h, cfg.Router)
+		if err != nil {
+			t.Fatal(err)
+		}
+		room, err := JoinChatRoom(ctx, ps, h.ID(), []string{"alice", "bob"}[i], "lobby", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rooms = append(rooms, room)
+	}
+	if err := rooms[0].ProtectPeers(alice.ConnManager()); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Connect(ctx, peer.AddrInfo{ID: bob.ID(), Addrs: bob.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+
+	tag := roomProtectTag("lobby")
+	deadline := time.Now().Add(10 * time.Second)
+	for !alice.ConnManager().IsProtected(bob.ID(), tag) {
+		if time.Now().After(deadline) {
+			t.Fatal("bob was never protected")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	usage := cfg.Limits.Usage(alice)
+	if len(usage) < 2 || usage[0].Scope != "system" || usage[0].Conns == 0 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	if err := rooms[0].Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if alice.ConnManager().IsProtected(bob.ID(), tag) {
+		t.Fatal("bob still protected after leaving the room")
+	}
+}
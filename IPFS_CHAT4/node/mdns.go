@@ -0,0 +1,253 @@
+package node
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// DefaultMDNSServiceTag is the tag publisher.go and subscriber.go advertise,
+// so the chat app and the standalone examples find each other on a LAN.
+const DefaultMDNSServiceTag = "librum-pubsub"
+
+// mdnsConnectTimeout bounds a single auto-connect to a LAN peer.
+const mdnsConnectTimeout = 10 * time.Second
+
+// MDNSConfig controls LAN peer discovery over multicast DNS.
+type MDNSConfig struct {
+	Enabled    bool   `json:"enabled"`
+	ServiceTag string `json:"serviceTag"`
+	// ConnectRate is how many LAN peers per second we start connecting to, after an initial ConnectBurst.
+	ConnectRate  float64 `json:"connectRate"`
+	ConnectBurst int     `json:"connectBurst"`
+}
+
+// DefaultMDNSConfig discovers LAN peers and connects to at most one a second after the first five.
+func DefaultMDNSConfig() MDNSConfig {
+	return MDNSConfig{
+		Enabled:      true,
+		ServiceTag:   DefaultMDNSServiceTag,
+		ConnectRate:  1,
+		ConnectBurst: 5,
+	}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *MDNSConfig) BindFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "mdns", c.Enabled, "discover and connect to peers on the local network")
+	fs.StringVar(&c.ServiceTag, "mdns-tag", c.ServiceTag, "mDNS service tag; only peers using the same tag are found")
+}
+
+// Validate checks the service tag and the connect rate.
+func (c MDNSConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.ServiceTag == "" {
+		return fmt.Errorf("mdns service tag must not be empty")
+	}
+	if c.ConnectRate <= 0 || c.ConnectBurst < 1 {
+		return fmt.Errorf("mdns connect rate (%v) and burst (%d) must be positive", c.ConnectRate, c.ConnectBurst)
+	}
+	return nil
+}
+
+// LANEventKind says whether a LAN peer came or went.
+type LANEventKind int
+
+const (
+	LANPeerFound LANEventKind = iota
+	LANPeerLost
+)
+
+func (k LANEventKind) String() string {
+	if k == LANPeerLost {
+		return "lost"
+	}
+	return "found"
+}
+
+// LANEvent reports a LAN peer we connected to through mDNS, or one whose last connection closed.
+type LANEvent struct {
+	Kind LANEventKind
+	Peer peer.AddrInfo
+	At   time.Time
+}
+
+// LANDiscovery runs mDNS and connects to the peers it finds, no faster than the configured rate.
+type LANDiscovery struct {
+	ctx    context.Context
+	stop   context.CancelFunc
+	h      host.Host
+	cfg    MDNSConfig
+	svc    mdns.Service
+	notify *network.NotifyBundle
+	queue  chan peer.AddrInfo
+	events chan LANEvent
+
+	mu      sync.Mutex
+	pending map[peer.ID]struct{}
+	lan     map[peer.ID]peer.AddrInfo
+}
+
+// StartMDNS starts advertising h on the local network and auto-connecting to peers with the same service tag.
+func StartMDNS(ctx context.Context, h host.Host, cfg MDNSConfig) (*LANDiscovery, error) {
+	ctx, stop := context.WithCancel(ctx)
+	d := &LANDiscovery{
+		ctx:     ctx,
+		stop:    stop,
+		h:       h,
+		cfg:     cfg,
+		queue:   make(chan peer.AddrInfo, 64),
+		events:  make(chan LANEvent, 32),
+		pending: make(map[peer.ID]struct{}),
+		lan:     make(map[peer.ID]peer.AddrInfo),
+	}
+	d.notify = &network.NotifyBundle{DisconnectedF: d.disconnected}
+	h.Network().Notify(d.notify)
+
+	d.svc = mdns.NewMdnsService(h, cfg.ServiceTag, d)
+	if err := d.svc.Start(); err != nil {
+		h.Network().StopNotify(d.notify)
+		stop()
+		return nil, err
+	}
+
+	go d.connectLoop()
+	return d, nil
+}
+
+// Close stops advertising and discovering. Connections already made stay open.
+func (d *LANDiscovery) Close() {
+	d.stop()
+	d.svc.Close()
+	d.h.Network().StopNotify(d.notify)
+}
+
+// Events delivers LAN peers coming and going. Events are dropped if nobody keeps up.
+func (d *LANDiscovery) Events() <-chan LANEvent {
+	return d.events
+}
+
+// Peers lists the LAN peers we are connected to.
+func (d *LANDiscovery) Peers() []peer.AddrInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := make([]peer.AddrInfo, 0, len(d.lan))
+	for _, pi := range d.lan {
+		out = append(out, pi)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// HandlePeerFound queues a peer mDNS found for connecting. A peer we are
+// already connected to, say one that dialled us first, is a LAN peer
+// straight away without another dial.
+func (d *LANDiscovery) HandlePeerFound(pi peer.AddrInfo) {
+	if pi.ID == d.h.ID() {
+		return
+	}
+	if d.h.Network().Connectedness(pi.ID) == network.Connected {
+		d.found(pi)
+		return
+	}
+
+	d.mu.Lock()
+	if _, ok := d.pending[pi.ID]; ok {
+		d.mu.Unlock()
+		return
+	}
+	d.pending[pi.ID] = struct{}{}
+	d.mu.Unlock()
+
+	select {
+	case d.queue <- pi:
+	default:
+		// mDNS announces again, so the peer gets another chance later.
+		d.mu.Lock()
+		delete(d.pending, pi.ID)
+		d.mu.Unlock()
+	}
+}
+
+func (d *LANDiscovery) connectLoop() {
+	b := &bucket{tokens: float64(d.cfg.ConnectBurst), last: time.Now()}
+	wait := time.Duration(float64(time.Second) / d.cfg.ConnectRate)
+
+	for {
+		select {
+		case pi := <-d.queue:
+			for !b.take(time.Now(), d.cfg.ConnectRate, float64(d.cfg.ConnectBurst)) {
+				select {
+				case <-time.After(wait):
+				case <-d.ctx.Done():
+					return
+				}
+			}
+			d.connect(pi)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *LANDiscovery) connect(pi peer.AddrInfo) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, pi.ID)
+		d.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(d.ctx, mdnsConnectTimeout)
+	defer cancel()
+	if err := d.h.Connect(ctx, pi); err != nil {
+		log.Printf("mdns: connecting to %s: %v", pi.ID, err)
+		return
+	}
+	d.found(pi)
+}
+
+// found records a connected LAN peer and reports it the first time.
+func (d *LANDiscovery) found(pi peer.AddrInfo) {
+	d.mu.Lock()
+	_, known := d.lan[pi.ID]
+	d.lan[pi.ID] = pi
+	d.mu.Unlock()
+	if !known {
+		d.emit(LANEvent{Kind: LANPeerFound, Peer: pi, At: time.Now()})
+	}
+}
+
+// disconnected reports a LAN peer as lost once its last connection closes.
+func (d *LANDiscovery) disconnected(n network.Network, c network.Conn) {
+	id := c.RemotePeer()
+	if n.Connectedness(id) == network.Connected {
+		return
+	}
+
+	d.mu.Lock()
+	pi, ok := d.lan[id]
+	delete(d.lan, id)
+	d.mu.Unlock()
+	if ok {
+		d.emit(LANEvent{Kind: LANPeerLost, Peer: pi, At: time.Now()})
+	}
+}
+
+func (d *LANDiscovery) emit(ev LANEvent) {
+	select {
+	case d.events <- ev:
+	default:
+	}
+}
@@ -0,0 +1,48 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestLANPeerAlreadyConnected(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := newTestHost(t), newTestHost(t)
+
+	cfg := DefaultMDNSConfig()
+	cfg.ServiceTag = "test-" + alice.ID().String()
+	lan, err := StartMDNS(ctx, alice, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lan.Close()
+
+	// Bob dialled us first, so the announcement needs no dial of our own.
+	bobInfo := peer.AddrInfo{ID: bob.ID(), Addrs: bob.Addrs()}
+	if err := bob.Connect(ctx, peer.AddrInfo{ID: alice.ID(), Addrs: alice.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	lan.HandlePeerFound(bobInfo)
+
+	select {
+	case ev := <-lan.Events():
+		if ev.Kind != LANPeerFound || ev.Peer.ID != bob.ID() {
+			t.Fatalf("got %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no found event for a connected peer")
+	}
+	if peers := lan.Peers(); len(peers) != 1 || peers[0].ID != bob.ID() {
+		t.Fatalf("LAN peers %v, want bob", peers)
+	}
+
+	lan.HandlePeerFound(bobInfo)
+	select {
+	case ev := <-lan.Events():
+		t.Fatalf("second event %+v for the same peer", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,71 @@
+package node
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// notWord is what may surround a nickname or keyword for it to count as a
+// word of its own, in any script.
+const notWord = `[^\pL\pN_]`
+
+// Highlighter picks out the messages meant for us: those naming our
+// nickname, as @nick or as a word of its own, and those matching the user's
+// highlight rules. It is not safe for concurrent use.
+type Highlighter struct {
+	nick  *regexp.Regexp
+	rules []*regexp.Regexp
+}
+
+// NewHighlighter matches nick and the given rules, see ParseHighlightRule.
+func NewHighlighter(nick string, rules []string) (*Highlighter, error) {
+	h := &Highlighter{}
+	for _, r := range rules {
+		re, err := ParseHighlightRule(r)
+		if err != nil {
+			return nil, err
+		}
+		h.rules = append(h.rules, re)
+	}
+	h.SetNick(nick)
+	return h, nil
+}
+
+// ParseHighlightRule turns a rule into its pattern. A rule between slashes is
+// a regular expression, e.g. "/deploy(ed|s)?/"; anything else is a keyword
+// matched as a whole word, ignoring case.
+func ParseHighlightRule(rule string) (*regexp.Regexp, error) {
+	if len(rule) > 2 && strings.HasPrefix(rule, "/") && strings.HasSuffix(rule, "/") {
+		return regexp.Compile(rule[1 : len(rule)-1])
+	}
+	if strings.TrimSpace(rule) == "" {
+		return nil, errors.New("empty highlight rule")
+	}
+	return wordPattern("", rule), nil
+}
+
+// SetNick follows a nickname change.
+func (h *Highlighter) SetNick(nick string) {
+	h.nick = nil
+	if nick != "" {
+		h.nick = wordPattern("@?", nick)
+	}
+}
+
+// Match reports whether text mentions us or matches a highlight rule.
+func (h *Highlighter) Match(text string) bool {
+	if h.nick != nil && h.nick.MatchString(text) {
+		return true
+	}
+	for _, re := range h.rules {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+func wordPattern(prefix, word string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(^|` + notWord + `)` + prefix + regexp.QuoteMeta(word) + `($|` + notWord + `)`)
+}
@@ -0,0 +1,40 @@
+package node
+
+import "testing"
+
+func TestHighlighter(t *testing.T) {
+	h, err := NewHighlighter("Bob", []string{"deploy", "/ticket-[0-9]+/", "café"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string]bool{
+		"hi @bob":                 true,
+		"bob, lunch?":             true,
+		"BOB!":                    true,
+		"bobby is here":           false,
+		"email bob@example.com":   true,
+		"sent to @bobcat":         false,
+		"we deploy at five":       true,
+		"redeploy later":          false,
+		"see TICKET-12":           false,
+		"see ticket-12":           true,
+		"meet at the Café":        true,
+		"cafés are closed":        false,
+		"nothing for us in here.": false,
+	} {
+		if got := h.Match(text); got != want {
+			t.Errorf("Match(%q) = %v, want %v", text, got, want)
+		}
+	}
+
+	h.SetNick("alice")
+	if h.Match("hi bob") || !h.Match("hi @alice") {
+		t.Error("SetNick did not follow the new nickname")
+	}
+
+	for _, bad := range []string{"", "  ", "/(unclosed/"} {
+		if _, err := NewHighlighter("bob", []string{bad}); err == nil {
+			t.Errorf("rule %q was accepted", bad)
+		}
+	}
+}
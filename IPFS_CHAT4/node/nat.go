@@ -0,0 +1,178 @@
+package node
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Reachability overrides accepted by NATConfig.Reachability.
+const (
+	ReachabilityAuto    = ""
+	ReachabilityPublic  = "public"
+	ReachabilityPrivate = "private"
+)
+
+// NATConfig controls how the node gets through NATs: AutoNAT to learn whether
+// it is reachable, circuit relay v2 when it is not, and DCUtR hole punching to
+// turn relayed connections into direct ones. gossipsub ignores relayed
+// connections, so two NATed peers only share rooms once DCUtR has given them
+// a direct connection; until then only direct messages reach each other.
+type NATConfig struct {
+	// AutoNATService answers other peers' reachability probes.
+	AutoNATService bool `json:"autoNatService"`
+	// PortMapping asks the router for a mapping over UPnP or NAT-PMP.
+	PortMapping bool `json:"portMapping"`
+	// RelayClient reserves a slot on StaticRelays while we are not publicly reachable.
+	RelayClient  bool     `json:"relayClient"`
+	StaticRelays []string `json:"staticRelays"`
+	// RelayService lets other peers relay through us once we are publicly reachable.
+	RelayService bool `json:"relayService"`
+	HolePunching bool `json:"holePunching"`
+	// Reachability skips AutoNAT detection when set to public or private.
+	Reachability string `json:"reachability"`
+}
+
+// DefaultNATConfig maps ports and punches holes. Relaying needs a relay, so it is off.
+func DefaultNATConfig() NATConfig {
+	return NATConfig{
+		PortMapping:  true,
+		HolePunching: true,
+	}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *NATConfig) BindFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.AutoNATService, "autonat-service", c.AutoNATService, "help other peers find out whether they are reachable")
+	fs.BoolVar(&c.RelayService, "relay-service", c.RelayService, "relay connections for peers behind NAT (circuit relay v2)")
+	fs.BoolVar(&c.HolePunching, "holepunch", c.HolePunching, "upgrade relayed connections to direct ones (DCUtR)")
+	fs.StringVar(&c.Reachability, "reachability", c.Reachability, "force reachability to public or private instead of asking AutoNAT")
+	fs.Func("relays", "comma separated relay multiaddrs to reserve a slot on when behind NAT", func(s string) error {
+		c.StaticRelays = splitList(s)
+		c.RelayClient = len(c.StaticRelays) > 0
+		return nil
+	})
+}
+
+// Validate checks the reachability override and the relay addresses.
+func (c NATConfig) Validate() error {
+	switch strings.ToLower(c.Reachability) {
+	case ReachabilityAuto, ReachabilityPublic, ReachabilityPrivate:
+	default:
+		return fmt.Errorf("unknown reachability %q, want public or private", c.Reachability)
+	}
+	if c.RelayClient && len(c.StaticRelays) == 0 {
+		return fmt.Errorf("relay client needs at least one static relay")
+	}
+	_, err := c.relays()
+	return err
+}
+
+func (c NATConfig) relays() ([]peer.AddrInfo, error) {
+	addrs, err := parseAddrs(c.StaticRelays)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, fmt.Errorf("bad relay address: %w", err)
+	}
+	return infos, nil
+}
+
+// Options returns the libp2p.New options for c.
+func (c NATConfig) Options() ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+	if c.AutoNATService {
+		opts = append(opts, libp2p.EnableNATService())
+	}
+	if c.PortMapping {
+		opts = append(opts, libp2p.NATPortMap())
+	}
+	if c.RelayClient {
+		relays, err := c.relays()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.EnableAutoRelayWithStaticRelays(relays))
+	}
+	if c.RelayService {
+		opts = append(opts, libp2p.EnableRelayService())
+	}
+	if c.HolePunching {
+		opts = append(opts, libp2p.EnableHolePunching())
+	}
+	switch strings.ToLower(c.Reachability) {
+	case ReachabilityPublic:
+		opts = append(opts, libp2p.ForceReachabilityPublic())
+	case ReachabilityPrivate:
+		opts = append(opts, libp2p.ForceReachabilityPrivate())
+	}
+	return opts, nil
+}
+
+// Reachability follows what AutoNAT, or a forced override, says about the host.
+type Reachability struct {
+	h   host.Host
+	sub event.Subscription
+
+	mu    sync.Mutex
+	state network.Reachability
+}
+
+// WatchReachability starts following h's reachability until Close.
+func WatchReachability(h host.Host) (*Reachability, error) {
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reachability{h: h, sub: sub}
+	go func() {
+		for ev := range sub.Out() {
+			r.mu.Lock()
+			r.state = ev.(event.EvtLocalReachabilityChanged).Reachability
+			r.mu.Unlock()
+		}
+	}()
+	return r, nil
+}
+
+// Close stops following reachability changes.
+func (r *Reachability) Close() {
+	r.sub.Close()
+}
+
+// Current is the latest reachability, Unknown until AutoNAT has an answer.
+func (r *Reachability) Current() network.Reachability {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// RelayAddrs lists the relayed addresses we currently advertise.
+func (r *Reachability) RelayAddrs() []string {
+	var out []string
+	for _, a := range DialAddrs(r.h) {
+		if strings.Contains(a, "/p2p-circuit") {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// Describe is a one line summary for status views, e.g. "Private, relayed via 1 address".
+func (r *Reachability) Describe() string {
+	s := r.Current().String()
+	if relayed := len(r.RelayAddrs()); relayed > 0 {
+		s += fmt.Sprintf(", relayed via %d address(es)", relayed)
+	}
+	return s
+}
@@ -0,0 +1,147 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func newNATHost(t *testing.T, nat NATConfig) host.Host {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Listen.Listen = []string{"/ip4/127.0.0.1/tcp/0"}
+	cfg.NAT = nat
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := cfg.HostOptions(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// TestRelayedChat puts two nodes that believe they are behind NAT on either
+// side of a circuit relay v2 and checks that direct messages get through the
+// relay alone while rooms do not. DCUtR only punches public addresses, so it
+// cannot run on loopback: the test stands in for the hole punch by forcing a
+// direct dial, then checks that rooms work over the connection that leaves.
+func TestRelayedChat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	relay := newNATHost(t, NATConfig{RelayService: true, Reachability: ReachabilityPublic})
+	relayAddr := DialAddrs(relay)[0]
+
+	natted := NATConfig{RelayClient: true, StaticRelays: []string{relayAddr}, HolePunching: true, Reachability: ReachabilityPrivate}
+	alice := newNATHost(t, natted)
+	bob := newNATHost(t, natted)
+
+	reach, err := WatchReachability(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reach.Close()
+
+	got := make(chan DirectMessage, 1)
+	ServeDirect(bob, func(dm DirectMessage) { got <- dm })
+
+	// Alice only knows bob's relayed address, as she would across a NAT. The
+	// dial fails until bob holds a reservation, and autorelay does not advertise
+	// loopback relays, so build the address and retry.
+	ma, err := multiaddr.NewMultiaddr(relayAddr + "/p2p-circuit/p2p/" + bob.ID().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := peer.AddrInfoFromP2pAddr(ma)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for alice.Connect(ctx, *info) != nil {
+		// A failed dial puts bob in dial backoff, which would outlast the wait.
+		alice.Network().(*swarm.Swarm).Backoff().Clear(bob.ID())
+		select {
+		case <-ctx.Done():
+			t.Fatal("bob never became reachable through the relay")
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	if got := reach.Current(); got != network.ReachabilityPrivate {
+		t.Errorf("bob reachability = %s, want Private", got)
+	}
+
+	// What /msg does.
+	if err := SendDirect(ctx, alice, bob.ID(), "alice", "psst"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case dm := <-got:
+		if dm.From != alice.ID() || dm.Message != "psst" {
+			t.Fatalf("got %+v", dm)
+		}
+	case <-ctx.Done():
+		t.Fatal("no direct message over the relay")
+	}
+	for _, c := range alice.Network().ConnsToPeer(bob.ID()) {
+		if !strings.Contains(c.RemoteMultiaddr().String(), "/p2p-circuit") {
+			t.Fatalf("alice has a direct connection to bob over %s before any hole punch", c.RemoteMultiaddr())
+		}
+	}
+
+	cfg := DefaultConfig()
+	roomA, roomB := joinTestRoom(t, alice, cfg), joinTestRoom(t, bob, cfg)
+	// gossipsub does not use relayed connections, so there is no mesh yet.
+	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := roomA.PublishReady(waitCtx, "over the relay", 1); err == nil {
+		t.Fatal("room message went out over a relayed connection")
+	}
+
+	// Stand in for DCUtR: a forced direct dial leaves the same connection a
+	// successful hole punch would.
+	alice.Peerstore().AddAddrs(bob.ID(), bob.Addrs(), time.Minute)
+	if _, err := alice.Network().DialPeer(network.WithForceDirectDial(ctx, "test"), bob.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := roomA.PublishReady(ctx, "direct", 1); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case m := <-roomB.Messages:
+			if m.Message == "direct" {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("no room message over the direct connection")
+		}
+	}
+}
+
+func joinTestRoom(t *testing.T, h host.Host, cfg Config) *ChatRoom {
+	t.Helper()
+	ctx := context.Background()
+	ps, err := NewPubSub(ctx, h, cfg.Router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err := JoinChatRoom(ctx, ps, h.ID(), "nat", "nat", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { room.Leave() })
+	return room
+}
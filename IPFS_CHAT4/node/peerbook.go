@@ -0,0 +1,377 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// PeerBookFile is the name of the known peers file in the profile directory.
+const PeerBookFile = "peers.json"
+
+// peerBookTag protects frequently used peers in the connection manager.
+const peerBookTag = "peerbook"
+
+// peerBookSaveInterval is how often the peer book is written while running.
+const peerBookSaveInterval = time.Minute
+
+// chatProtocols are the protocols that make a peer worth remembering even
+// when it shares none of our rooms: direct messages and the stream chat.
+var chatProtocols = []protocol.ID{DirectProtocol, "/chat/1.0.0"}
+
+// ReconnectConfig controls the persisted peer book and redialing on startup.
+type ReconnectConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxAge drops peers we have not connected to for this long.
+	MaxAge Duration `json:"maxAge"`
+	// RecentWindow limits redialing to peers we connected to this recently.
+	RecentWindow Duration `json:"recentWindow"`
+	// MaxRedial is how many of the most recent peers are redialed on startup.
+	MaxRedial int `json:"maxRedial"`
+	// BackoffMin and BackoffMax bound the wait between attempts, which doubles each time.
+	BackoffMin  Duration `json:"backoffMin"`
+	BackoffMax  Duration `json:"backoffMax"`
+	MaxAttempts int      `json:"maxAttempts"`
+	// ProtectAfter protects a peer from connection trimming once we have connected to it this many times.
+	ProtectAfter int `json:"protectAfter"`
+}
+
+// DefaultReconnectConfig remembers peers for a week and redials up to 20 seen in the last day.
+func DefaultReconnectConfig() ReconnectConfig {
+	return ReconnectConfig{
+		Enabled:      true,
+		MaxAge:       Duration(7 * 24 * time.Hour),
+		RecentWindow: Duration(24 * time.Hour),
+		MaxRedial:    20,
+		BackoffMin:   Duration(5 * time.Second),
+		BackoffMax:   Duration(5 * time.Minute),
+		MaxAttempts:  6,
+		ProtectAfter: 3,
+	}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *ReconnectConfig) BindFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "reconnect", c.Enabled, "remember peers across runs and redial recent ones on startup")
+}
+
+// Validate checks the windows and the backoff bounds.
+func (c ReconnectConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxAge <= 0 || c.RecentWindow <= 0 || c.RecentWindow > c.MaxAge {
+		return fmt.Errorf("reconnect recent window (%s) must be positive and within max age (%s)", c.RecentWindow, c.MaxAge)
+	}
+	if c.BackoffMin <= 0 || c.BackoffMax < c.BackoffMin {
+		return fmt.Errorf("reconnect backoff must satisfy 0 < min (%s) <= max (%s)", c.BackoffMin, c.BackoffMax)
+	}
+	if c.MaxRedial < 0 || c.MaxAttempts < 1 || c.ProtectAfter < 1 {
+		return fmt.Errorf("reconnect max redial, attempts and protect after must be positive")
+	}
+	return nil
+}
+
+// KnownPeer is one entry of the peer book file.
+type KnownPeer struct {
+	ID            peer.ID   `json:"id"`
+	Addrs         []string  `json:"addrs"`
+	Protocols     []string  `json:"protocols"`
+	LastConnected time.Time `json:"lastConnected"`
+	Connections   int       `json:"connections"`
+}
+
+// PeerBook persists the chat peers we have connected to, redials them and
+// protects the regulars. A chat peer shares one of our topics or speaks the
+// direct message or stream chat protocol; DHT servers, relays and the like
+// are left out.
+type PeerBook struct {
+	ctx    context.Context
+	stop   context.CancelFunc
+	h      host.Host
+	ps     *pubsub.PubSub
+	path   string
+	cfg    ReconnectConfig
+	notify *network.NotifyBundle
+
+	mu    sync.Mutex
+	peers map[peer.ID]*KnownPeer
+	// candidates are connected peers not yet seen to be chat peers.
+	candidates map[peer.ID]*KnownPeer
+}
+
+// StartPeerBook loads the peer book at path into h's peerstore, starts redialing
+// recently good peers and keeps the file up to date until Close. ps tells
+// which peers share our topics; without it only the protocols count.
+func StartPeerBook(ctx context.Context, h host.Host, ps *pubsub.PubSub, path string, cfg ReconnectConfig) (*PeerBook, error) {
+	peers, err := loadPeerBook(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	b := &PeerBook{
+		ctx:        ctx,
+		stop:       stop,
+		h:          h,
+		ps:         ps,
+		path:       path,
+		cfg:        cfg,
+		peers:      make(map[peer.ID]*KnownPeer),
+		candidates: make(map[peer.ID]*KnownPeer),
+	}
+
+	now := time.Now()
+	for _, kp := range peers {
+		if kp.ID == h.ID() || now.Sub(kp.LastConnected) > time.Duration(cfg.MaxAge) {
+			continue
+		}
+		kp := kp
+		b.peers[kp.ID] = &kp
+		b.restore(&kp)
+	}
+
+	b.notify = &network.NotifyBundle{ConnectedF: b.connected}
+	h.Network().Notify(b.notify)
+
+	for _, kp := range b.recent(now) {
+		go b.redial(kp)
+	}
+	go b.saveLoop()
+	return b, nil
+}
+
+// Close stops redialing and writes the peer book one last time.
+func (b *PeerBook) Close() error {
+	b.stop()
+	b.h.Network().StopNotify(b.notify)
+	return b.Save()
+}
+
+// Peers lists the known peers, most recently connected first.
+func (b *PeerBook) Peers() []KnownPeer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make([]KnownPeer, 0, len(b.peers))
+	for _, kp := range b.peers {
+		out = append(out, *kp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastConnected.After(out[j].LastConnected) })
+	return out
+}
+
+// Save writes the peer book, refreshing addresses and protocols from the peerstore.
+func (b *PeerBook) Save() error {
+	b.sweep()
+	ps := b.h.Peerstore()
+	peers := b.Peers()
+	for i := range peers {
+		kp := &peers[i]
+		if addrs := ps.Addrs(kp.ID); len(addrs) > 0 {
+			kp.Addrs = kp.Addrs[:0]
+			for _, a := range addrs {
+				kp.Addrs = append(kp.Addrs, a.String())
+			}
+		}
+		if protos, err := ps.GetProtocols(kp.ID); err == nil && len(protos) > 0 {
+			kp.Protocols = protocol.ConvertToStrings(protos)
+		}
+	}
+
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+func loadPeerBook(path string) ([]KnownPeer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var peers []KnownPeer
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return peers, nil
+}
+
+// restore puts a saved peer back into the peerstore.
+func (b *PeerBook) restore(kp *KnownPeer) {
+	ps := b.h.Peerstore()
+	for _, s := range kp.Addrs {
+		a, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			continue
+		}
+		ps.AddAddr(kp.ID, a, peerstore.RecentlyConnectedAddrTTL)
+	}
+	if len(kp.Protocols) > 0 {
+		ps.AddProtocols(kp.ID, protocol.ConvertFromStrings(kp.Protocols)...)
+	}
+	b.protect(kp)
+}
+
+func (b *PeerBook) protect(kp *KnownPeer) {
+	if kp.Connections >= b.cfg.ProtectAfter {
+		b.h.ConnManager().Protect(kp.ID, peerBookTag)
+	}
+}
+
+// connected records a successful connection. A peer new to the book is a
+// candidate until sweep finds it is a chat peer: neither its protocols nor
+// its topics are known this early.
+func (b *PeerBook) connected(n network.Network, c network.Conn) {
+	id := c.RemotePeer()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	kp, known := b.peers[id]
+	if !known {
+		if kp = b.candidates[id]; kp == nil {
+			kp = &KnownPeer{ID: id}
+			b.candidates[id] = kp
+		}
+	}
+	// Count sessions, not the extra connections a peer may open alongside the first.
+	if len(n.ConnsToPeer(id)) <= 1 {
+		kp.Connections++
+	}
+	kp.LastConnected = time.Now()
+	if known {
+		b.protect(kp)
+	}
+}
+
+// sweep moves the candidates that turned out to be chat peers into the book
+// and forgets the others once they disconnect.
+func (b *PeerBook) sweep() {
+	b.mu.Lock()
+	ids := make([]peer.ID, 0, len(b.candidates))
+	for id := range b.candidates {
+		ids = append(ids, id)
+	}
+	b.mu.Unlock()
+
+	for _, id := range ids {
+		chat := b.isChatPeer(id)
+		if !chat && b.h.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+		b.mu.Lock()
+		if kp, ok := b.candidates[id]; ok {
+			delete(b.candidates, id)
+			if chat {
+				b.peers[id] = kp
+				b.protect(kp)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// isChatPeer reports whether id speaks a chat protocol or is in one of our topics.
+func (b *PeerBook) isChatPeer(id peer.ID) bool {
+	if protos, err := b.h.Peerstore().SupportsProtocols(id, chatProtocols...); err == nil && len(protos) > 0 {
+		return true
+	}
+	if b.ps == nil {
+		return false
+	}
+	for _, topic := range b.ps.GetTopics() {
+		for _, p := range b.ps.ListPeers(topic) {
+			if p == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recent picks the peers to redial, most recently connected first.
+func (b *PeerBook) recent(now time.Time) []KnownPeer {
+	var out []KnownPeer
+	for _, kp := range b.Peers() {
+		if len(out) == b.cfg.MaxRedial {
+			break
+		}
+		if now.Sub(kp.LastConnected) <= time.Duration(b.cfg.RecentWindow) {
+			out = append(out, kp)
+		}
+	}
+	return out
+}
+
+func (b *PeerBook) redial(kp KnownPeer) {
+	wait := time.Duration(b.cfg.BackoffMin)
+	for attempt := 1; ; attempt++ {
+		if b.h.Network().Connectedness(kp.ID) == network.Connected {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(b.ctx, wait)
+		err := b.h.Connect(ctx, peer.AddrInfo{ID: kp.ID})
+		cancel()
+		if err == nil || b.ctx.Err() != nil {
+			return
+		}
+		if attempt == b.cfg.MaxAttempts {
+			log.Printf("peerbook: giving up on %s after %d attempts: %v", kp.ID, attempt, err)
+			return
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-b.ctx.Done():
+			return
+		}
+		if wait *= 2; wait > time.Duration(b.cfg.BackoffMax) {
+			wait = time.Duration(b.cfg.BackoffMax)
+		}
+	}
+}
+
+func (b *PeerBook) saveLoop() {
+	ticker := time.NewTicker(peerBookSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Save(); err != nil {
+				log.Println("peerbook: saving", err)
+			}
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TestPeerBookRedial connects to a chat peer and a peer that is not one,
+// saves the peer book and checks that a fresh host loading it finds its way
+// back to the chat peer without being told any address.
+func TestPeerBookRedial(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), PeerBookFile)
+	cfg := DefaultReconnectConfig()
+	cfg.BackoffMin = Duration(100 * time.Millisecond)
+	cfg.ProtectAfter = 1
+
+	remote, other := newTestHost(t), newTestHost(t)
+	ServeDirect(remote, func(DirectMessage) {})
+	first := newTestHost(t)
+	book, err := StartPeerBook(ctx, first, nil, path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []host.Host{remote, other} {
+		if err := first.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := book.Close(); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	second := newTestHost(t)
+	book, err = StartPeerBook(ctx, second, nil, path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+
+	if got := book.Peers(); len(got) != 1 || got[0].ID != remote.ID() || got[0].Connections != 1 {
+		t.Fatalf("loaded %+v, want one entry for %s", got, remote.ID())
+	}
+	if !second.ConnManager().IsProtected(remote.ID(), peerBookTag) {
+		t.Error("regular peer is not protected")
+	}
+	for second.Network().Connectedness(remote.ID()) != network.Connected {
+		select {
+		case <-ctx.Done():
+			t.Fatal("never redialed the known peer")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package node
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// SwarmKeyFile is the name of the private network key in the profile directory.
+const SwarmKeyFile = "swarm.key"
+
+// PrivateNetConfig puts the node on a private network: every connection is
+// encrypted with a pre-shared key, so only nodes holding the same swarm key
+// can talk to each other. libp2p only supports this over TCP and WebSocket,
+// so QUIC and WebTransport are not used while it is on.
+type PrivateNetConfig struct {
+	Enabled bool `json:"enabled"`
+	// KeyFile overrides SwarmKeyFile in the profile directory.
+	KeyFile string `json:"keyFile"`
+}
+
+// DefaultPrivateNetConfig joins the public network.
+func DefaultPrivateNetConfig() PrivateNetConfig {
+	return PrivateNetConfig{}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *PrivateNetConfig) BindFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "private", c.Enabled, "only connect to nodes holding the same swarm key")
+	fs.StringVar(&c.KeyFile, "swarm-key", c.KeyFile, "swarm key file (default: "+SwarmKeyFile+" in the profile directory)")
+}
+
+// Validate has nothing to check on its own; see Config.Validate.
+func (c PrivateNetConfig) Validate() error {
+	return nil
+}
+
+// KeyPath is where the swarm key lives for the given profile directory.
+func (c PrivateNetConfig) KeyPath(profileDir string) string {
+	if c.KeyFile != "" {
+		return c.KeyFile
+	}
+	return filepath.Join(profileDir, SwarmKeyFile)
+}
+
+// Load reads the swarm key when the private network is enabled and returns
+// nil when it is not. A missing key is an error rather than a silent fall
+// back to the public network.
+func (c PrivateNetConfig) Load(profileDir string) (pnet.PSK, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	return LoadSwarmKey(c.KeyPath(profileDir))
+}
+
+// Describe says which network the node is on for status views, e.g.
+// "private (swarm key 1a2b3c4d)".
+func (c PrivateNetConfig) Describe(profileDir string) string {
+	if !c.Enabled {
+		return "public"
+	}
+	psk, err := c.Load(profileDir)
+	if err != nil {
+		return "private (swarm key unreadable)"
+	}
+	return fmt.Sprintf("private (swarm key %s)", SwarmKeyFingerprint(psk))
+}
+
+// LoadSwarmKey reads a swarm key in the go-ipfs swarm.key format.
+func LoadSwarmKey(path string) (pnet.PSK, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("private network is on but %s does not exist; generate one with keygen", path)
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading swarm key %s: %w", path, err)
+	}
+	return psk, nil
+}
+
+// GenerateSwarmKey writes a new random swarm key to path. It refuses to
+// replace an existing key unless force is set, since every node sharing the
+// old key would be cut off.
+func GenerateSwarmKey(path string, force bool) (pnet.PSK, error) {
+	psk := make(pnet.PSK, 32)
+	if _, err := rand.Read(psk); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("/key/swarm/psk/1.0.0/\n/base16/\n")
+	buf.WriteString(hex.EncodeToString(psk) + "\n")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%s already exists", path)
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return psk, f.Close()
+}
+
+// SwarmKeyFingerprint identifies a swarm key without revealing it, so users
+// can check they hold the same one.
+func SwarmKeyFingerprint(psk pnet.PSK) string {
+	sum := sha256.Sum256(psk)
+	return hex.EncodeToString(sum[:4])
+}
+
+// privateListenAddrs drops the addresses libp2p cannot use on a private
+// network: everything over UDP, i.e. QUIC and WebTransport.
+func privateListenAddrs(addrs []string) []string {
+	var out []string
+	for _, a := range addrs {
+		if !strings.Contains(a, "/udp/") {
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func newPrivateHost(t *testing.T, psk pnet.PSK) host.Host {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.NAT = NATConfig{}
+	opts, err := cfg.HostOptions(0, psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, SwarmKeyFile)
+	psk, err := GenerateSwarmKey(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateSwarmKey(path, false); err == nil {
+		t.Fatal("overwrote an existing swarm key")
+	}
+	loaded, err := PrivateNetConfig{Enabled: true}.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(psk, loaded) {
+		t.Fatal("swarm key did not round trip")
+	}
+	other, err := GenerateSwarmKey(filepath.Join(dir, "other.key"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := newPrivateHost(t, psk)
+	for _, a := range alice.Addrs() {
+		if _, err := a.ValueForProtocol(multiaddr.P_UDP); err == nil {
+			t.Fatalf("private host listens over UDP on %s", a)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		psk  pnet.PSK
+		ok   bool
+	}{
+		{"same key", psk, true},
+		{"other key", other, false},
+		{"public", nil, false},
+	} {
+		h := newPrivateHost(t, tc.psk)
+		err := h.Connect(ctx, peer.AddrInfo{ID: alice.ID(), Addrs: alice.Addrs()})
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%s: connected %v, want %v (%v)", tc.name, ok, tc.ok, err)
+		}
+	}
+}
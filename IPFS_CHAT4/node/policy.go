@@ -0,0 +1,147 @@
+package node
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TopicPolicyConfig limits which topics the node joins and which remote subscriptions it tracks.
+// With no patterns at all every topic is allowed.
+type TopicPolicyConfig struct {
+	// Allow holds path.Match patterns such as "chat-room:*".
+	Allow []string `json:"allow"`
+	// AllowRegex holds regular expressions matched against the whole topic name.
+	AllowRegex []string `json:"allowRegex"`
+	// MaxPeerSubscriptions caps how many allowed topics one peer may subscribe to, 0 for no cap.
+	MaxPeerSubscriptions int `json:"maxPeerSubscriptions"`
+}
+
+// DefaultTopicPolicyConfig allows chat rooms and the room directory only, and at most 100 topics per peer.
+// "?*" keeps out the topic of the empty room name.
+func DefaultTopicPolicyConfig() TopicPolicyConfig {
+	return TopicPolicyConfig{
+		Allow:                []string{TopicName("?*"), DirectoryTopic},
+		MaxPeerSubscriptions: 100,
+	}
+}
+
+// Validate checks that every pattern compiles.
+func (c TopicPolicyConfig) Validate() error {
+	_, err := NewTopicPolicy(c)
+	return err
+}
+
+// TopicPolicy is a pubsub.SubscriptionFilter built from a TopicPolicyConfig.
+// It logs the subscriptions it refuses, at most once a minute per peer.
+type TopicPolicy struct {
+	globs   []string
+	regexps []*regexp.Regexp
+	max     int
+	rejects *rejectLog
+
+	mu   sync.Mutex
+	subs map[peer.ID]map[string]struct{}
+}
+
+var _ pubsub.SubscriptionFilter = (*TopicPolicy)(nil)
+
+// NewTopicPolicy compiles the configured patterns.
+func NewTopicPolicy(c TopicPolicyConfig) (*TopicPolicy, error) {
+	p := &TopicPolicy{
+		globs:   c.Allow,
+		max:     c.MaxPeerSubscriptions,
+		rejects: newRejectLog(),
+		subs:    make(map[peer.ID]map[string]struct{}),
+	}
+	for _, g := range c.Allow {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad topic pattern %q: %w", g, err)
+		}
+	}
+	for _, expr := range c.AllowRegex {
+		rx, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("bad topic regex %q: %w", expr, err)
+		}
+		p.regexps = append(p.regexps, rx)
+	}
+	return p, nil
+}
+
+// Watch forgets a peer's subscriptions once its last connection closes,
+// since it will announce them again in full when it reconnects.
+func (p *TopicPolicy) Watch(h host.Host) {
+	h.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			if n.Connectedness(c.RemotePeer()) == network.Connected {
+				return
+			}
+			p.mu.Lock()
+			delete(p.subs, c.RemotePeer())
+			p.mu.Unlock()
+		},
+	})
+}
+
+// CanSubscribe reports whether topic matches one of the allowed patterns.
+func (p *TopicPolicy) CanSubscribe(topic string) bool {
+	if len(p.globs) == 0 && len(p.regexps) == 0 {
+		return true
+	}
+	for _, g := range p.globs {
+		if ok, _ := path.Match(g, topic); ok {
+			return true
+		}
+	}
+	for _, rx := range p.regexps {
+		if rx.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterIncomingSubscriptions drops subscriptions to topics we don't allow and
+// any that would take the peer over its cap. Unsubscriptions always pass.
+func (p *TopicPolicy) FilterIncomingSubscriptions(from peer.ID, subs []*pb.RPC_SubOpts) ([]*pb.RPC_SubOpts, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	mine := p.subs[from]
+	out := subs[:0:0]
+	for _, sub := range subs {
+		topic := sub.GetTopicid()
+
+		if !sub.GetSubscribe() {
+			delete(mine, topic)
+			out = append(out, sub)
+			continue
+		}
+
+		if !p.CanSubscribe(topic) {
+			p.rejects.Printf(from, time.Now(), "topic policy: rejected subscription from %s to %q", from, topic)
+			continue
+		}
+		if _, ok := mine[topic]; !ok && p.max > 0 && len(mine) >= p.max {
+			p.rejects.Printf(from, time.Now(), "topic policy: rejected subscription from %s to %q, over %d subscriptions", from, topic, p.max)
+			continue
+		}
+
+		if mine == nil {
+			mine = make(map[string]struct{})
+			p.subs[from] = mine
+		}
+		mine[topic] = struct{}{}
+		out = append(out, sub)
+	}
+	return out, nil
+}
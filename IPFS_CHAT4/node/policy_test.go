@@ -0,0 +1,114 @@
+package node
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestTopicPolicyPatterns(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   TopicPolicyConfig
+		topic string
+		want  bool
+	}{
+		{"room", DefaultTopicPolicyConfig(), "chat-room:lobby", true},
+		{"directory", DefaultTopicPolicyConfig(), "chat-directory", true},
+		{"empty room", DefaultTopicPolicyConfig(), "chat-room:", false},
+		{"glob prefix", DefaultTopicPolicyConfig(), "xchat-room:a", false},
+		{"glob suffix", DefaultTopicPolicyConfig(), "chat-directoryX", false},
+		{"regex", TopicPolicyConfig{AllowRegex: []string{"chat-room:[a-z]+"}}, "chat-room:abc", true},
+		{"regex prefix", TopicPolicyConfig{AllowRegex: []string{"chat-room:[a-z]+"}}, "xchat-room:abc", false},
+		{"regex suffix", TopicPolicyConfig{AllowRegex: []string{"chat-room:[a-z]+"}}, "chat-room:abc1", false},
+		{"regex alternation", TopicPolicyConfig{AllowRegex: []string{"a|b"}}, "ab", false},
+		{"no patterns", TopicPolicyConfig{}, "anything", true},
+	}
+	for _, tt := range tests {
+		p, err := NewTopicPolicy(tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := p.CanSubscribe(tt.topic); got != tt.want {
+			t.Errorf("%s: CanSubscribe(%q) = %v, want %v", tt.name, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func subOpts(subscribe bool, topics ...string) []*pb.RPC_SubOpts {
+	var out []*pb.RPC_SubOpts
+	for _, topic := range topics {
+		topic := topic
+		out = append(out, &pb.RPC_SubOpts{Subscribe: &subscribe, Topicid: &topic})
+	}
+	return out
+}
+
+func TestTopicPolicyCap(t *testing.T) {
+	p, err := NewTopicPolicy(TopicPolicyConfig{MaxPeerSubscriptions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.rejects.logf = func(string, ...interface{}) {}
+	alice := peer.ID("alice")
+
+	filter := func(subscribe bool, topics ...string) int {
+		t.Helper()
+		out, err := p.FilterIncomingSubscriptions(alice, subOpts(subscribe, topics...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(out)
+	}
+
+	if n := filter(true, "a", "b", "c"); n != 2 {
+		t.Fatalf("%d of 3 subscriptions passed a cap of 2", n)
+	}
+	// Subscribing again to a topic we already count takes no new slot.
+	if n := filter(true, "a"); n != 1 {
+		t.Fatal("a repeated subscription was refused")
+	}
+	if n := filter(true, "c"); n != 0 {
+		t.Fatal("a subscription over the cap passed")
+	}
+	// Unsubscribing frees a slot, and resubscribing takes it again.
+	if n := filter(false, "a"); n != 1 {
+		t.Fatal("an unsubscription was dropped")
+	}
+	if n := filter(true, "c"); n != 1 {
+		t.Fatal("the slot freed by unsubscribing was not reused")
+	}
+	if n := filter(true, "a"); n != 0 {
+		t.Fatal("resubscribing went over the cap")
+	}
+	// Other peers have their own count.
+	out, _ := p.FilterIncomingSubscriptions("bob", subOpts(true, "a", "b"))
+	if len(out) != 2 {
+		t.Fatal("one peer's subscriptions counted against another")
+	}
+}
+
+func TestRejectLog(t *testing.T) {
+	var lines []string
+	l := newRejectLog()
+	l.logf = func(format string, args ...interface{}) {
+		lines = append(lines, args[0].(string))
+	}
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		l.Printf("alice", now.Add(time.Duration(i)*time.Millisecond), "bad %d", i)
+	}
+	l.Printf("bob", now, "bad")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines for two peers within an interval, want 2", len(lines))
+	}
+
+	l.Printf("alice", now.Add(rejectLogInterval), "bad again")
+	if len(lines) != 3 || !strings.Contains(lines[2], "and 99 more") {
+		t.Fatalf("got %q, want the 99 skipped rejections counted", lines)
+	}
+}
@@ -0,0 +1,153 @@
+package node
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Message kinds carried on a room topic. Chat messages leave Kind empty.
+// Actions are /me messages, shown as "* nick waves".
+const (
+	KindChat     = ""
+	KindAction   = "action"
+	KindPresence = "presence"
+)
+
+// Presence statuses. Offline is never sent, it is what a silent peer becomes.
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusLeft    = "left"
+	StatusOffline = "offline"
+)
+
+// PresenceInterval is how often a room member repeats its presence heartbeat.
+const PresenceInterval = 30 * time.Second
+
+// presenceTimeout is how long a member stays listed as present without a heartbeat.
+const presenceTimeout = 3 * PresenceInterval
+
+// RosterEntry is what we know about one member of a room.
+type RosterEntry struct {
+	ID       peer.ID
+	Nick     string
+	Status   string
+	LastSeen time.Time
+	Self     bool
+}
+
+// roster tracks the latest nickname and presence of everyone heard in a room.
+// Every entry comes from a pubsub message signed by its author and checked
+// against SenderID by the room validator, so a peer can only set its own nick.
+type roster struct {
+	mu      sync.Mutex
+	members map[peer.ID]*RosterEntry
+}
+
+func newRoster() *roster {
+	return &roster{members: make(map[peer.ID]*RosterEntry)}
+}
+
+func (r *roster) seen(id peer.ID, nick, status string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.members[id]
+	if !ok {
+		e = &RosterEntry{ID: id}
+		r.members[id] = e
+	}
+	e.Nick = nick
+	e.LastSeen = at
+	if status != "" {
+		e.Status = status
+	} else if e.Status == "" || e.Status == StatusLeft {
+		// Talking brings a member back even if we missed its heartbeat.
+		e.Status = StatusOnline
+	}
+}
+
+func (r *roster) list(now time.Time) []RosterEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]RosterEntry, 0, len(r.members))
+	for _, e := range r.members {
+		c := *e
+		if !c.Self && c.Status != StatusLeft && now.Sub(c.LastSeen) > presenceTimeout {
+			c.Status = StatusOffline
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Nick != out[j].Nick {
+			return out[i].Nick < out[j].Nick
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Roster lists every member heard in the room, including ourselves, by nickname.
+func (cr *ChatRoom) Roster() []RosterEntry {
+	return cr.roster.list(time.Now())
+}
+
+// SetStatus changes our presence status and announces it straight away.
+func (cr *ChatRoom) SetStatus(status string) error {
+	cr.statusMu.Lock()
+	cr.status = status
+	cr.statusMu.Unlock()
+	return cr.sendPresence(status)
+}
+
+// SetNick changes the nickname we publish under and announces it straight away.
+func (cr *ChatRoom) SetNick(nick string) error {
+	if err := ValidateNick(nick); err != nil {
+		return err
+	}
+	cr.statusMu.Lock()
+	cr.nick = nick
+	status := cr.status
+	cr.statusMu.Unlock()
+	return cr.sendPresence(status)
+}
+
+func (cr *ChatRoom) sendPresence(status string) error {
+	nick := cr.Nick()
+	cr.roster.seen(cr.self, nick, status, time.Now())
+
+	m := ChatMessage{
+		Kind:       KindPresence,
+		Status:     status,
+		SenderID:   cr.self.String(),
+		SenderNick: nick,
+	}
+	msgBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return cr.topic.Publish(cr.ctx, msgBytes)
+}
+
+// presenceLoop repeats our heartbeat until the room is left.
+func (cr *ChatRoom) presenceLoop() {
+	ticker := time.NewTicker(PresenceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cr.statusMu.Lock()
+			status := cr.status
+			cr.statusMu.Unlock()
+			cr.sendPresence(status)
+		case <-cr.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// rejectLogInterval is how often a rejectLog logs about any one peer.
+const rejectLogInterval = time.Minute
+
+// rejectLog logs what we reject from a peer at most once per interval and
+// counts the rest, so a peer flooding us with bad messages or subscriptions
+// cannot flood the log too. The next line about the peer says how many were
+// left out.
+type rejectLog struct {
+	interval time.Duration
+	logf     func(format string, args ...interface{})
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*rejectCount
+	lastSweep time.Time
+}
+
+type rejectCount struct {
+	logged  time.Time
+	skipped int
+}
+
+func newRejectLog() *rejectLog {
+	return &rejectLog{
+		interval: rejectLogInterval,
+		logf:     log.Printf,
+		peers:    make(map[peer.ID]*rejectCount),
+	}
+}
+
+// Printf logs a rejection from p unless one was logged within the interval.
+func (l *rejectLog) Printf(p peer.ID, now time.Time, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A peer quiet for a whole interval starts afresh; what it had skipped
+	// since its last line goes unreported.
+	if now.Sub(l.lastSweep) > l.interval {
+		for id, c := range l.peers {
+			if now.Sub(c.logged) > l.interval {
+				delete(l.peers, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.peers[p]
+	if ok && now.Sub(c.logged) < l.interval {
+		c.skipped++
+		return
+	}
+	if !ok {
+		c = &rejectCount{}
+		l.peers[p] = c
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if c.skipped > 0 {
+		msg += fmt.Sprintf(" (and %d more since %s)", c.skipped, c.logged.Format("15:04:05"))
+	}
+	l.logf("%s", msg)
+	c.logged = now
+	c.skipped = 0
+}
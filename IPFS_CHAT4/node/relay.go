@@ -0,0 +1,182 @@
+package node
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// RelayTopics joins each topic in relay mode: we take part in the mesh and
+// forward messages without subscribing to them. Chat room topics get the same
+// validators and score params as a normal JoinChatRoom so abusers are not
+// forwarded. self is the relay's peer ID. The returned func stops relaying
+// all of them.
+func RelayTopics(ps *pubsub.PubSub, self peer.ID, topics []string, cfg Config) (func(), error) {
+	// Undo in reverse so relays are cancelled before their topics are closed.
+	var cancels []func()
+	stop := func() {
+		for i := len(cancels) - 1; i >= 0; i-- {
+			cancels[i]()
+		}
+	}
+
+	for _, name := range topics {
+		name := name
+		if roomName, ok := strings.CutPrefix(name, TopicName("")); ok {
+			if err := RegisterRoomValidator(ps, self, name, cfg.MessageLimits.For(roomName)); err != nil {
+				stop()
+				return nil, err
+			}
+			cancels = append(cancels, func() { ps.UnregisterTopicValidator(name) })
+		}
+
+		topic, err := ps.Join(name)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		cancels = append(cancels, func() { topic.Close() })
+
+		if err := ApplyTopicScore(topic, cfg.Scoring); err != nil {
+			stop()
+			return nil, err
+		}
+
+		cancel, err := topic.Relay()
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		cancels = append(cancels, cancel)
+	}
+
+	return stop, nil
+}
+
+// TopicStats counts the traffic seen on one relayed topic.
+type TopicStats struct {
+	Received       uint64
+	Duplicates     uint64
+	Rejected       uint64
+	Forwarded      uint64
+	ForwardedBytes uint64
+	MeshPeers      int
+}
+
+// RelayStats is a pubsub.RawTracer that counts traffic on a fixed set of topics.
+// Install it with pubsub.WithRawTracer.
+type RelayStats struct {
+	Started time.Time
+
+	mu     sync.Mutex
+	topics map[string]*TopicStats
+	mesh   map[string]map[peer.ID]struct{}
+}
+
+var _ pubsub.RawTracer = (*RelayStats)(nil)
+
+// NewRelayStats tracks the given topics and ignores all others.
+func NewRelayStats(topics []string) *RelayStats {
+	s := &RelayStats{
+		Started: time.Now(),
+		topics:  make(map[string]*TopicStats, len(topics)),
+		mesh:    make(map[string]map[peer.ID]struct{}, len(topics)),
+	}
+	for _, t := range topics {
+		s.topics[t] = new(TopicStats)
+		s.mesh[t] = make(map[peer.ID]struct{})
+	}
+	return s
+}
+
+// Snapshot copies the current counters.
+func (s *RelayStats) Snapshot() map[string]TopicStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]TopicStats, len(s.topics))
+	for name, ts := range s.topics {
+		c := *ts
+		c.MeshPeers = len(s.mesh[name])
+		out[name] = c
+	}
+	return out
+}
+
+// Topics returns the tracked topic names in order.
+func (s *RelayStats) Topics() []string {
+	names := make([]string, 0, len(s.topics))
+	for name := range s.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *RelayStats) count(topic string, fn func(*TopicStats)) {
+	s.mu.Lock()
+	if ts, ok := s.topics[topic]; ok {
+		fn(ts)
+	}
+	s.mu.Unlock()
+}
+
+func (s *RelayStats) DeliverMessage(msg *pubsub.Message) {
+	s.count(msg.GetTopic(), func(ts *TopicStats) { ts.Received++ })
+}
+
+func (s *RelayStats) DuplicateMessage(msg *pubsub.Message) {
+	s.count(msg.GetTopic(), func(ts *TopicStats) { ts.Duplicates++ })
+}
+
+func (s *RelayStats) RejectMessage(msg *pubsub.Message, reason string) {
+	s.count(msg.GetTopic(), func(ts *TopicStats) { ts.Rejected++ })
+}
+
+func (s *RelayStats) SendRPC(rpc *pubsub.RPC, p peer.ID) {
+	for _, msg := range rpc.GetPublish() {
+		size := uint64(len(msg.GetData()))
+		s.count(msg.GetTopic(), func(ts *TopicStats) {
+			ts.Forwarded++
+			ts.ForwardedBytes += size
+		})
+	}
+}
+
+func (s *RelayStats) Graft(p peer.ID, topic string) {
+	s.mu.Lock()
+	if m, ok := s.mesh[topic]; ok {
+		m[p] = struct{}{}
+	}
+	s.mu.Unlock()
+}
+
+func (s *RelayStats) Prune(p peer.ID, topic string) {
+	s.mu.Lock()
+	if m, ok := s.mesh[topic]; ok {
+		delete(m, p)
+	}
+	s.mu.Unlock()
+}
+
+func (s *RelayStats) RemovePeer(p peer.ID) {
+	s.mu.Lock()
+	for _, m := range s.mesh {
+		delete(m, p)
+	}
+	s.mu.Unlock()
+}
+
+func (s *RelayStats) AddPeer(p peer.ID, proto protocol.ID)     {}
+func (s *RelayStats) Join(topic string)                        {}
+func (s *RelayStats) Leave(topic string)                       {}
+func (s *RelayStats) ValidateMessage(msg *pubsub.Message)      {}
+func (s *RelayStats) ThrottlePeer(p peer.ID)                   {}
+func (s *RelayStats) RecvRPC(rpc *pubsub.RPC)                  {}
+func (s *RelayStats) DropRPC(rpc *pubsub.RPC, p peer.ID)       {}
+func (s *RelayStats) UndeliverableMessage(msg *pubsub.Message) {}
@@ -0,0 +1,218 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const ChatRoomBufSize = 128 // Adjust as needed
+
+// MaxRoomNameLength bounds room names.
+const MaxRoomNameLength = 64
+
+// ValidateRoomName checks that name is one word of 1 to MaxRoomNameLength
+// characters without control characters or slashes, so it can be typed as a
+// command argument, printed safely and matched by topic policy globs.
+func ValidateRoomName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > MaxRoomNameLength {
+		return fmt.Errorf("room names are 1 to %d characters", MaxRoomNameLength)
+	}
+	if strings.IndexFunc(name, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) || r == '/' }) >= 0 {
+		return fmt.Errorf("bad room name %q: no spaces, control characters or slashes", name)
+	}
+	return nil
+}
+
+type ChatRoom struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	ps       *pubsub.PubSub
+	topic    *pubsub.Topic
+	sub      *pubsub.Subscription
+	self     peer.ID
+	nick     string
+	roomName string
+	Messages chan *ChatMessage
+
+	roster *roster
+	// statusMu guards status and nick, which can change while we are in the room.
+	statusMu sync.Mutex
+	status   string
+
+	// protecting is closed once ProtectPeers has let go of the topic.
+	protecting chan struct{}
+}
+
+type ChatMessage struct {
+	Message    string
+	SenderID   string
+	SenderNick string
+	// Kind is KindChat for messages people typed and KindPresence for heartbeats.
+	Kind   string `json:",omitempty"`
+	Status string `json:",omitempty"`
+}
+
+// topic handler
+func JoinChatRoom(ctx context.Context, ps *pubsub.PubSub, selfID peer.ID, nickname, roomName string, cfg Config) (*ChatRoom, error) {
+	if err := ValidateRoomName(roomName); err != nil {
+		return nil, err
+	}
+	topicName := TopicName(roomName)
+	if err := RegisterRoomValidator(ps, selfID, topicName, cfg.MessageLimits.For(roomName)); err != nil {
+		return nil, err
+	}
+
+	topic, err := ps.Join(topicName)
+	if err != nil {
+		ps.UnregisterTopicValidator(topicName)
+		return nil, err
+	}
+
+	if err := ApplyTopicScore(topic, cfg.Scoring); err != nil {
+		topic.Close()
+		ps.UnregisterTopicValidator(topicName)
+		return nil, err
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		ps.UnregisterTopicValidator(topicName)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	chatRoom := &ChatRoom{
+		ctx:      ctx,
+		cancel:   cancel,
+		ps:       ps,
+		topic:    topic,
+		sub:      sub,
+		self:     selfID,
+		nick:     nickname,
+		roomName: roomName,
+		Messages: make(chan *ChatMessage, ChatRoomBufSize),
+		roster:   newRoster(),
+		status:   StatusOnline,
+	}
+	chatRoom.roster.members[selfID] = &RosterEntry{ID: selfID, Nick: nickname, Status: StatusOnline, LastSeen: time.Now(), Self: true}
+
+	go chatRoom.readLoop()
+	go chatRoom.presenceLoop()
+	chatRoom.sendPresence(StatusOnline)
+	return chatRoom, nil
+}
+
+// Leave tells the room we are going, then unsubscribes and closes the topic.
+// Messages is closed once the read loop stops.
+func (cr *ChatRoom) Leave() error {
+	cr.sendPresence(StatusLeft)
+	cr.cancel()
+	if cr.protecting != nil {
+		<-cr.protecting
+	}
+	cr.sub.Cancel()
+	err := cr.topic.Close()
+	cr.ps.UnregisterTopicValidator(cr.topic.String())
+	return err
+}
+
+// Name is the room name without the topic prefix.
+func (cr *ChatRoom) Name() string {
+	return cr.roomName
+}
+
+// Nick is the nickname we publish under in this room.
+func (cr *ChatRoom) Nick() string {
+	cr.statusMu.Lock()
+	defer cr.statusMu.Unlock()
+	return cr.nick
+}
+
+// Self is our own peer ID.
+func (cr *ChatRoom) Self() peer.ID {
+	return cr.self
+}
+
+// message handler for chatrooms
+func (cr *ChatRoom) Publish(message string) error {
+	return cr.publish(cr.ctx, KindChat, message)
+}
+
+// PublishAction sends a /me action, e.g. "waves" for "* nick waves".
+func (cr *ChatRoom) PublishAction(action string) error {
+	return cr.publish(cr.ctx, KindAction, action)
+}
+
+// PublishReady publishes message once at least minPeers peers are in our
+// mesh for the room, giving up when ctx ends first. With minPeers 0 it
+// publishes straight away.
+func (cr *ChatRoom) PublishReady(ctx context.Context, message string, minPeers int) error {
+	return cr.publish(ctx, KindChat, message, readiness(minPeers)...)
+}
+
+// readiness waits for minPeers mesh peers. gossipsub takes a size of 0 as
+// its own lower mesh bound, so 0 gets no readiness at all.
+func readiness(minPeers int) []pubsub.PubOpt {
+	if minPeers <= 0 {
+		return nil
+	}
+	return []pubsub.PubOpt{pubsub.WithReadiness(pubsub.MinTopicSize(minPeers))}
+}
+
+func (cr *ChatRoom) publish(ctx context.Context, kind, message string, opts ...pubsub.PubOpt) error {
+	m := ChatMessage{
+		Message:    message,
+		SenderID:   cr.self.String(),
+		SenderNick: cr.Nick(),
+		Kind:       kind,
+	}
+	msgBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return cr.topic.Publish(ctx, msgBytes, opts...)
+}
+
+func (cr *ChatRoom) ListPeers() []peer.ID {
+	return cr.ps.ListPeers(TopicName(cr.roomName))
+}
+
+// readLoop pulls messages from the pubsub topic and pushes them onto the Messages channel.
+func (cr *ChatRoom) readLoop() {
+	for {
+		msg, err := cr.sub.Next(cr.ctx)
+		if err != nil {
+			close(cr.Messages)
+			return
+		}
+		// only forward messages delivered by others
+		if msg.ReceivedFrom == cr.self {
+			continue
+		}
+		cm := new(ChatMessage)
+		err = json.Unmarshal(msg.Data, cm)
+		if err != nil {
+			continue
+		}
+		cr.roster.seen(msg.GetFrom(), cm.SenderNick, cm.Status, time.Now())
+		if cm.Kind == KindPresence {
+			continue
+		}
+		// send valid messages onto the Messages channel
+		cr.Messages <- cm
+	}
+}
+
+func TopicName(roomName string) string {
+	return "chat-room:" + roomName
+}
@@ -0,0 +1,168 @@
+// Package node holds the libp2p and pubsub plumbing shared by the chat front-ends.
+package node
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// Supported pubsub routers.
+const (
+	RouterGossipSub = "gossipsub"
+	RouterFloodSub  = "floodsub"
+	RouterRandomSub = "randomsub"
+)
+
+// DefaultRandomSubSize is the network size hint handed to RandomSub when none is configured.
+const DefaultRandomSubSize = 32
+
+// Duration is a time.Duration that reads and writes as a string such as "700ms" in config files.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// RouterConfig selects the pubsub router and, for GossipSub, its mesh parameters.
+type RouterConfig struct {
+	Router string `json:"router"`
+
+	// GossipSub mesh parameters, see pubsub.GossipSubParams.
+	D             int      `json:"d"`
+	Dlo           int      `json:"dlo"`
+	Dhi           int      `json:"dhi"`
+	Heartbeat     Duration `json:"heartbeat"`
+	HistoryLength int      `json:"historyLength"`
+	HistoryGossip int      `json:"historyGossip"`
+
+	// RandomSubSize is the expected network size used by RandomSub to pick fanout.
+	RandomSubSize int `json:"randomSubSize"`
+}
+
+// DefaultRouterConfig returns GossipSub with the library's default parameters.
+func DefaultRouterConfig() RouterConfig {
+	p := pubsub.DefaultGossipSubParams()
+	return RouterConfig{
+		Router:        RouterGossipSub,
+		D:             p.D,
+		Dlo:           p.Dlo,
+		Dhi:           p.Dhi,
+		Heartbeat:     Duration(p.HeartbeatInterval),
+		HistoryLength: p.HistoryLength,
+		HistoryGossip: p.HistoryGossip,
+		RandomSubSize: DefaultRandomSubSize,
+	}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+// Load the config file before calling this so flags win over the file.
+func (c *RouterConfig) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Router, "router", c.Router, "pubsub router: gossipsub, floodsub or randomsub")
+	fs.IntVar(&c.D, "gossip-d", c.D, "gossipsub mesh degree D")
+	fs.IntVar(&c.Dlo, "gossip-dlo", c.Dlo, "gossipsub lower mesh bound Dlo")
+	fs.IntVar(&c.Dhi, "gossip-dhi", c.Dhi, "gossipsub upper mesh bound Dhi")
+	fs.Var(&durationFlag{&c.Heartbeat}, "gossip-heartbeat", "gossipsub heartbeat interval")
+	fs.IntVar(&c.HistoryLength, "gossip-history-length", c.HistoryLength, "gossipsub message cache window in heartbeats")
+	fs.IntVar(&c.HistoryGossip, "gossip-history-gossip", c.HistoryGossip, "gossipsub heartbeats advertised in gossip")
+	fs.IntVar(&c.RandomSubSize, "randomsub-size", c.RandomSubSize, "randomsub network size hint")
+}
+
+type durationFlag struct {
+	d *Duration
+}
+
+func (f *durationFlag) String() string {
+	if f.d == nil {
+		return ""
+	}
+	return f.d.String()
+}
+
+func (f *durationFlag) Set(s string) error {
+	return f.d.UnmarshalText([]byte(s))
+}
+
+// Validate checks that the router is known and that the GossipSub parameters are consistent.
+func (c RouterConfig) Validate() error {
+	switch strings.ToLower(c.Router) {
+	case RouterGossipSub:
+		if c.Dlo > c.D || c.D > c.Dhi {
+			return fmt.Errorf("gossipsub requires Dlo <= D <= Dhi, got %d/%d/%d", c.Dlo, c.D, c.Dhi)
+		}
+		if c.Heartbeat <= 0 {
+			return fmt.Errorf("gossipsub heartbeat must be positive, got %s", c.Heartbeat)
+		}
+		if c.HistoryGossip > c.HistoryLength {
+			return fmt.Errorf("gossipsub history gossip (%d) exceeds history length (%d)", c.HistoryGossip, c.HistoryLength)
+		}
+	case RouterFloodSub:
+	case RouterRandomSub:
+		if c.RandomSubSize <= 0 {
+			return fmt.Errorf("randomsub size must be positive, got %d", c.RandomSubSize)
+		}
+	default:
+		return fmt.Errorf("unknown pubsub router %q", c.Router)
+	}
+	return nil
+}
+
+// GossipSubParams returns the library defaults overridden by the configured mesh parameters.
+func (c RouterConfig) GossipSubParams() pubsub.GossipSubParams {
+	p := pubsub.DefaultGossipSubParams()
+	p.D = c.D
+	p.Dlo = c.Dlo
+	p.Dhi = c.Dhi
+	p.HeartbeatInterval = time.Duration(c.Heartbeat)
+	p.HistoryLength = c.HistoryLength
+	p.HistoryGossip = c.HistoryGossip
+	return p
+}
+
+// Describe summarises the router and its parameters for status output.
+func (c RouterConfig) Describe() string {
+	switch strings.ToLower(c.Router) {
+	case RouterGossipSub:
+		return fmt.Sprintf("gossipsub (D=%d Dlo=%d Dhi=%d heartbeat=%s history=%d gossip=%d)",
+			c.D, c.Dlo, c.Dhi, c.Heartbeat, c.HistoryLength, c.HistoryGossip)
+	case RouterRandomSub:
+		return fmt.Sprintf("randomsub (size=%d)", c.RandomSubSize)
+	default:
+		return strings.ToLower(c.Router)
+	}
+}
+
+// NewPubSub starts the configured router on h. Extra options are passed through to the router.
+func NewPubSub(ctx context.Context, h host.Host, cfg RouterConfig, opts ...pubsub.Option) (*pubsub.PubSub, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(cfg.Router) {
+	case RouterFloodSub:
+		return pubsub.NewFloodSub(ctx, h, opts...)
+	case RouterRandomSub:
+		return pubsub.NewRandomSub(ctx, h, cfg.RandomSubSize, opts...)
+	default:
+		opts = append([]pubsub.Option{pubsub.WithGossipSubParams(cfg.GossipSubParams())}, opts...)
+		return pubsub.NewGossipSub(ctx, h, opts...)
+	}
+}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *RouterConfig)
+		err    string
+	}{
+		{"defaults", func(c *RouterConfig) {}, ""},
+		{"D equal bounds", func(c *RouterConfig) { c.Dlo, c.D, c.Dhi = 6, 6, 6 }, ""},
+		{"Dlo over D", func(c *RouterConfig) { c.Dlo, c.D = 7, 6 }, "Dlo <= D <= Dhi"},
+		{"D over Dhi", func(c *RouterConfig) { c.D, c.Dhi = 13, 12 }, "Dlo <= D <= Dhi"},
+		{"no heartbeat", func(c *RouterConfig) { c.Heartbeat = 0 }, "heartbeat"},
+		{"gossip equals history", func(c *RouterConfig) { c.HistoryGossip = c.HistoryLength }, ""},
+		{"gossip over history", func(c *RouterConfig) { c.HistoryGossip = c.HistoryLength + 1 }, "exceeds history length"},
+		{"floodsub", func(c *RouterConfig) { c.Router, c.Dlo = RouterFloodSub, 99 }, ""},
+		{"randomsub", func(c *RouterConfig) { c.Router = RouterRandomSub }, ""},
+		{"randomsub no size", func(c *RouterConfig) { c.Router, c.RandomSubSize = RouterRandomSub, 0 }, "randomsub size"},
+		{"upper case", func(c *RouterConfig) { c.Router = "GossipSub" }, ""},
+		{"unknown", func(c *RouterConfig) { c.Router = "meshsub" }, "unknown pubsub router"},
+	}
+	for _, tt := range tests {
+		c := DefaultRouterConfig()
+		tt.modify(&c)
+		err := c.Validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want an error about %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestGossipSubParams(t *testing.T) {
+	c := DefaultRouterConfig()
+	c.D, c.Dlo, c.Dhi = 4, 3, 8
+	c.Heartbeat = Duration(500 * time.Millisecond)
+	c.HistoryLength, c.HistoryGossip = 6, 2
+
+	p := c.GossipSubParams()
+	if p.D != 4 || p.Dlo != 3 || p.Dhi != 8 || p.HeartbeatInterval != 500*time.Millisecond ||
+		p.HistoryLength != 6 || p.HistoryGossip != 2 {
+		t.Fatalf("params %+v do not carry the config", p)
+	}
+	// Everything not configured keeps the library default.
+	if def := DefaultRouterConfig().GossipSubParams(); p.Dscore != def.Dscore || p.GossipFactor != def.GossipFactor {
+		t.Errorf("Dscore %d, gossip factor %v changed", p.Dscore, p.GossipFactor)
+	}
+}
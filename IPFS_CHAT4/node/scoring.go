@@ -0,0 +1,321 @@
+package node
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Peer score profiles.
+const (
+	ScoreProfileOff     = "off"
+	ScoreProfileDefault = "default"
+	ScoreProfileStrict  = "strict"
+)
+
+// ScoreInspectInterval is how often the router reports peer scores to a ScoreBoard.
+const ScoreInspectInterval = 2 * time.Second
+
+// TopicScoreConfig is the tunable part of pubsub.TopicScoreParams.
+// Decays are given as the time it takes a counter to fade to nothing.
+type TopicScoreConfig struct {
+	Weight                  float64  `json:"weight"`
+	TimeInMeshWeight        float64  `json:"timeInMeshWeight"`
+	TimeInMeshCap           float64  `json:"timeInMeshCap"`
+	FirstDeliveriesWeight   float64  `json:"firstDeliveriesWeight"`
+	FirstDeliveriesCap      float64  `json:"firstDeliveriesCap"`
+	FirstDeliveriesDecay    Duration `json:"firstDeliveriesDecay"`
+	InvalidDeliveriesWeight float64  `json:"invalidDeliveriesWeight"`
+	InvalidDeliveriesDecay  Duration `json:"invalidDeliveriesDecay"`
+}
+
+// ScoringConfig selects a peer score profile and per-topic overrides.
+// Topics is keyed by topic name or a path.Match pattern such as "chat-room:*".
+type ScoringConfig struct {
+	Profile string                      `json:"profile"`
+	Topics  map[string]TopicScoreConfig `json:"topics"`
+}
+
+// DefaultScoringConfig leaves scoring disabled.
+func DefaultScoringConfig() ScoringConfig {
+	return ScoringConfig{Profile: ScoreProfileOff}
+}
+
+// BindFlags registers command line flags that override the values already in c.
+func (c *ScoringConfig) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Profile, "score-profile", c.Profile, "gossipsub peer score profile: off, default or strict")
+}
+
+// Enabled reports whether a scoring profile other than off is selected.
+func (c ScoringConfig) Enabled() bool {
+	return strings.ToLower(c.Profile) != ScoreProfileOff && c.Profile != ""
+}
+
+// Validate checks the profile name and that every topic override produces valid params.
+func (c ScoringConfig) Validate() error {
+	switch strings.ToLower(c.Profile) {
+	case "", ScoreProfileOff, ScoreProfileDefault, ScoreProfileStrict:
+	default:
+		return fmt.Errorf("unknown peer score profile %q", c.Profile)
+	}
+	for pattern, tc := range c.Topics {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad topic pattern %q: %w", pattern, err)
+		}
+		if err := tc.validate(); err != nil {
+			return fmt.Errorf("score params for %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Thresholds returns the score thresholds of the selected profile.
+func (c ScoringConfig) Thresholds() *pubsub.PeerScoreThresholds {
+	if strings.ToLower(c.Profile) == ScoreProfileStrict {
+		return &pubsub.PeerScoreThresholds{
+			GossipThreshold:             -100,
+			PublishThreshold:            -200,
+			GraylistThreshold:           -400,
+			AcceptPXThreshold:           50,
+			OpportunisticGraftThreshold: 5,
+		}
+	}
+	return &pubsub.PeerScoreThresholds{
+		GossipThreshold:             -500,
+		PublishThreshold:            -1000,
+		GraylistThreshold:           -2500,
+		AcceptPXThreshold:           10,
+		OpportunisticGraftThreshold: 3,
+	}
+}
+
+// PeerScoreParams returns the peer level params of the selected profile.
+// Topic params are attached per topic when rooms are joined, see ApplyTopicScore.
+func (c ScoringConfig) PeerScoreParams() *pubsub.PeerScoreParams {
+	p := &pubsub.PeerScoreParams{
+		Topics:                      make(map[string]*pubsub.TopicScoreParams),
+		TopicScoreCap:               100,
+		AppSpecificScore:            func(peer.ID) float64 { return 0 },
+		AppSpecificWeight:           1,
+		IPColocationFactorWeight:    -50,
+		IPColocationFactorThreshold: 5,
+		BehaviourPenaltyWeight:      -10,
+		BehaviourPenaltyThreshold:   6,
+		BehaviourPenaltyDecay:       pubsub.ScoreParameterDecay(10 * time.Minute),
+		DecayInterval:               pubsub.DefaultDecayInterval,
+		DecayToZero:                 pubsub.DefaultDecayToZero,
+		RetainScore:                 time.Hour,
+	}
+	if strings.ToLower(c.Profile) == ScoreProfileStrict {
+		p.IPColocationFactorWeight = -100
+		p.IPColocationFactorThreshold = 3
+		p.BehaviourPenaltyWeight = -30
+		p.BehaviourPenaltyThreshold = 3
+		p.BehaviourPenaltyDecay = pubsub.ScoreParameterDecay(time.Hour)
+		p.RetainScore = 6 * time.Hour
+	}
+	return p
+}
+
+// topicDefaults is the profile's topic config used when no override matches.
+// Mesh delivery penalties stay off: chat rooms are too quiet for delivery rates to mean anything.
+func (c ScoringConfig) topicDefaults() TopicScoreConfig {
+	tc := TopicScoreConfig{
+		Weight:                  1,
+		TimeInMeshWeight:        0.01,
+		TimeInMeshCap:           3600,
+		FirstDeliveriesWeight:   1,
+		FirstDeliveriesCap:      50,
+		FirstDeliveriesDecay:    Duration(time.Hour),
+		InvalidDeliveriesWeight: -100,
+		InvalidDeliveriesDecay:  Duration(time.Hour),
+	}
+	if strings.ToLower(c.Profile) == ScoreProfileStrict {
+		tc.InvalidDeliveriesWeight = -1000
+		tc.InvalidDeliveriesDecay = Duration(24 * time.Hour)
+	}
+	return tc
+}
+
+// TopicConfig returns the override matching topic, falling back to the profile defaults.
+// An exact name wins over a pattern.
+func (c ScoringConfig) TopicConfig(topic string) TopicScoreConfig {
+	if tc, ok := c.Topics[topic]; ok {
+		return tc
+	}
+	patterns := make([]string, 0, len(c.Topics))
+	for pattern := range c.Topics {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return c.Topics[pattern]
+		}
+	}
+	return c.topicDefaults()
+}
+
+// TopicScoreParams returns the pubsub params for topic.
+func (c ScoringConfig) TopicScoreParams(topic string) *pubsub.TopicScoreParams {
+	return c.TopicConfig(topic).params()
+}
+
+func (tc TopicScoreConfig) validate() error {
+	switch {
+	case tc.Weight < 0:
+		return fmt.Errorf("weight must not be negative")
+	case tc.TimeInMeshWeight < 0 || (tc.TimeInMeshWeight != 0 && tc.TimeInMeshCap <= 0):
+		return fmt.Errorf("time in mesh needs a non-negative weight and a positive cap")
+	case tc.FirstDeliveriesWeight < 0 || (tc.FirstDeliveriesWeight != 0 && (tc.FirstDeliveriesCap <= 0 || tc.FirstDeliveriesDecay <= 0)):
+		return fmt.Errorf("first deliveries need a non-negative weight, a positive cap and a decay")
+	case tc.InvalidDeliveriesWeight > 0 || tc.InvalidDeliveriesDecay <= 0:
+		return fmt.Errorf("invalid deliveries need a non-positive weight and a decay")
+	}
+	return nil
+}
+
+func (tc TopicScoreConfig) params() *pubsub.TopicScoreParams {
+	p := &pubsub.TopicScoreParams{
+		TopicWeight:                    tc.Weight,
+		TimeInMeshWeight:               tc.TimeInMeshWeight,
+		TimeInMeshQuantum:              time.Second,
+		TimeInMeshCap:                  tc.TimeInMeshCap,
+		FirstMessageDeliveriesWeight:   tc.FirstDeliveriesWeight,
+		FirstMessageDeliveriesCap:      tc.FirstDeliveriesCap,
+		InvalidMessageDeliveriesWeight: tc.InvalidDeliveriesWeight,
+	}
+	if tc.FirstDeliveriesDecay > 0 {
+		p.FirstMessageDeliveriesDecay = pubsub.ScoreParameterDecay(time.Duration(tc.FirstDeliveriesDecay))
+	}
+	if tc.InvalidDeliveriesDecay > 0 {
+		p.InvalidMessageDeliveriesDecay = pubsub.ScoreParameterDecay(time.Duration(tc.InvalidDeliveriesDecay))
+	}
+	return p
+}
+
+// Options returns the pubsub options that turn on scoring and feed board, or nil when scoring is off.
+// board may be nil if nobody needs to look at live scores.
+func (c ScoringConfig) Options(board *ScoreBoard) []pubsub.Option {
+	if !c.Enabled() {
+		return nil
+	}
+	opts := []pubsub.Option{pubsub.WithPeerScore(c.PeerScoreParams(), c.Thresholds())}
+	if board != nil {
+		opts = append(opts, pubsub.WithPeerScoreInspect(pubsub.ExtendedPeerScoreInspectFn(board.update), ScoreInspectInterval))
+	}
+	return opts
+}
+
+// ApplyTopicScore attaches the configured topic params to a joined topic.
+// It does nothing when scoring is off.
+func ApplyTopicScore(t *pubsub.Topic, cfg ScoringConfig) error {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return t.SetScoreParams(cfg.TopicScoreParams(t.String()))
+}
+
+// PeerScore is one row of a ScoreBoard snapshot.
+type PeerScore struct {
+	ID     peer.ID
+	Score  float64
+	Status string
+	Reason string
+}
+
+// ScoreBoard keeps the most recent peer scores reported by the router.
+type ScoreBoard struct {
+	cfg        ScoringConfig
+	thresholds *pubsub.PeerScoreThresholds
+	params     *pubsub.PeerScoreParams
+
+	mu     sync.Mutex
+	scores map[peer.ID]*pubsub.PeerScoreSnapshot
+}
+
+// NewScoreBoard creates a board that explains scores using cfg's weights and thresholds.
+func NewScoreBoard(cfg ScoringConfig) *ScoreBoard {
+	return &ScoreBoard{
+		cfg:        cfg,
+		thresholds: cfg.Thresholds(),
+		params:     cfg.PeerScoreParams(),
+	}
+}
+
+func (b *ScoreBoard) update(scores map[peer.ID]*pubsub.PeerScoreSnapshot) {
+	b.mu.Lock()
+	b.scores = scores
+	b.mu.Unlock()
+}
+
+// Scores returns the latest scores, lowest first.
+func (b *ScoreBoard) Scores() []PeerScore {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make([]PeerScore, 0, len(b.scores))
+	for id, snap := range b.scores {
+		out = append(out, PeerScore{
+			ID:     id,
+			Score:  snap.Score,
+			Status: b.status(snap.Score),
+			Reason: b.reason(snap),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Score < out[j].Score })
+	return out
+}
+
+// status names the strongest sanction the score currently triggers.
+func (b *ScoreBoard) status(score float64) string {
+	switch {
+	case score < b.thresholds.GraylistThreshold:
+		return "graylisted"
+	case score < b.thresholds.PublishThreshold:
+		return "no publish"
+	case score < b.thresholds.GossipThreshold:
+		return "no gossip"
+	default:
+		return "ok"
+	}
+}
+
+// reason lists the penalties dragging a score down, worst first.
+func (b *ScoreBoard) reason(snap *pubsub.PeerScoreSnapshot) string {
+	type penalty struct {
+		what  string
+		value float64
+	}
+	var penalties []penalty
+
+	for topic, ts := range snap.Topics {
+		tp := b.cfg.TopicScoreParams(topic)
+		if v := tp.TopicWeight * tp.InvalidMessageDeliveriesWeight * ts.InvalidMessageDeliveries * ts.InvalidMessageDeliveries; v < 0 {
+			penalties = append(penalties, penalty{fmt.Sprintf("invalid messages on %s", topic), v})
+		}
+	}
+	if v := snap.AppSpecificScore * b.params.AppSpecificWeight; v < 0 {
+		penalties = append(penalties, penalty{"app specific", v})
+	}
+	if v := snap.IPColocationFactor * b.params.IPColocationFactorWeight; v < 0 {
+		penalties = append(penalties, penalty{"ip colocation", v})
+	}
+	if excess := snap.BehaviourPenalty - b.params.BehaviourPenaltyThreshold; excess > 0 {
+		penalties = append(penalties, penalty{"misbehaviour", excess * excess * b.params.BehaviourPenaltyWeight})
+	}
+
+	sort.Slice(penalties, func(i, j int) bool { return penalties[i].value < penalties[j].value })
+	parts := make([]string, len(penalties))
+	for i, p := range penalties {
+		parts[i] = fmt.Sprintf("%s %.1f", p.what, p.value)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScoreProfilesAccepted(t *testing.T) {
+	override := map[string]TopicScoreConfig{"chat-room:*": {
+		Weight:                 0.5,
+		InvalidDeliveriesDecay: Duration(time.Minute),
+	}}
+	for _, cfg := range []ScoringConfig{
+		{Profile: ScoreProfileOff},
+		{Profile: ScoreProfileDefault},
+		{Profile: ScoreProfileStrict},
+		{Profile: ScoreProfileStrict, Topics: override},
+	} {
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%s: %v", cfg.Profile, err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// pubsub checks the peer params when the router starts and the topic
+		// params when they are set.
+		ps, err := NewPubSub(ctx, newTestHost(t), DefaultRouterConfig(), cfg.Options(NewScoreBoard(cfg))...)
+		if err != nil {
+			t.Fatalf("%s: gossipsub refused the peer params: %v", cfg.Profile, err)
+		}
+		topic, err := ps.Join(TopicName("lobby"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyTopicScore(topic, cfg); err != nil {
+			t.Fatalf("%s: gossipsub refused the topic params: %v", cfg.Profile, err)
+		}
+		topic.Close()
+	}
+}
+
+func TestTopicScoreOverrides(t *testing.T) {
+	exact := TopicScoreConfig{Weight: 1}
+	rooms := TopicScoreConfig{Weight: 2}
+	dev := TopicScoreConfig{Weight: 3}
+	cfg := ScoringConfig{Profile: ScoreProfileStrict, Topics: map[string]TopicScoreConfig{
+		"chat-room:lobby": exact,
+		"chat-room:*":     rooms,
+		"chat-room:dev*":  dev,
+		"chat-room:[":     {Weight: 4},
+	}}
+
+	tests := []struct {
+		topic string
+		want  TopicScoreConfig
+	}{
+		{"chat-room:lobby", exact},
+		{"chat-room:random", rooms},
+		// Patterns are tried in sorted order, and "*" sorts before "d".
+		{"chat-room:devops", rooms},
+		{"chat-directory", cfg.topicDefaults()},
+	}
+	for _, tt := range tests {
+		if got := cfg.TopicConfig(tt.topic); got != tt.want {
+			t.Errorf("TopicConfig(%q) = %+v, want %+v", tt.topic, got, tt.want)
+		}
+	}
+	if cfg.Validate() == nil {
+		t.Error("a malformed pattern passed validation")
+	}
+	if got := cfg.topicDefaults().InvalidDeliveriesWeight; got != -1000 {
+		t.Errorf("strict invalid deliveries weight %v, want -1000", got)
+	}
+}
@@ -0,0 +1,352 @@
+package node
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Theme roles: what a piece of output is, for the theme to colour it.
+const (
+	RoleTitle   = "title"   // headings and pane titles
+	RoleRule    = "rule"    // lines around the menu
+	RoleMenu    = "menu"    // menu numbers
+	RoleWarning = "warning" // the private network banner
+	RoleAccent  = "accent"  // the focused pane
+	RoleBorder  = "border"  // other panes
+	RoleMuted   = "muted"   // timestamps and system text
+	RoleNick    = "nick"
+	RoleSelf    = "self" // our own nick
+	RoleAction  = "action"
+	RoleDirect  = "direct" // direct messages
+	RoleNotice  = "notice" // LAN peers and other events
+	RoleError   = "error"
+	RoleMention = "mention" // messages meant for us and their counts
+	RoleSelect  = "selected"
+	RoleStatus  = "status" // the status bar
+	RoleUnread  = "unread"
+)
+
+// ThemeRoles lists every role a palette may set.
+var ThemeRoles = []string{
+	RoleTitle, RoleRule, RoleMenu, RoleWarning, RoleAccent, RoleBorder,
+	RoleMuted, RoleNick, RoleSelf, RoleAction, RoleDirect, RoleNotice,
+	RoleError, RoleMention, RoleSelect, RoleStatus, RoleUnread,
+}
+
+// Sanitize drops control characters from what peers send, so nobody can
+// move our cursor or recolour the screen. Line breaks and tabs stay.
+func Sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// Values of ChatConfig.Color.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// Palette maps roles to styles, e.g. "nick": "bold green". A style is any
+// of bold, dim, italic, underline and reverse, a colour, and "on" and a
+// background colour. Colours are the eight names black, red, green, yellow,
+// blue, magenta, cyan and white, the same with "bright-", 0 to 255 from the
+// 256-colour palette, or #rrggbb.
+type Palette map[string]string
+
+// Themes are the built-in palettes. A palette in the config file with one of
+// these names changes it; roles a config palette leaves out come from the
+// built-in palette of the same name, or from "default".
+var Themes = map[string]Palette{
+	// default suits dark terminals.
+	"default": {
+		RoleTitle:   "bold bright-white",
+		RoleRule:    "bold yellow",
+		RoleMenu:    "bold green",
+		RoleWarning: "bold red",
+		RoleAccent:  "39",
+		RoleBorder:  "240",
+		RoleMuted:   "244",
+		RoleNick:    "bold green",
+		RoleSelf:    "bold 33",
+		RoleAction:  "italic yellow",
+		RoleDirect:  "magenta",
+		RoleNotice:  "cyan",
+		RoleError:   "bold 196",
+		RoleMention: "bold 214",
+		RoleSelect:  "reverse",
+		RoleStatus:  "252 on 236",
+		RoleUnread:  "bold",
+	},
+	// light keeps to darker colours that read on a white background.
+	"light": {
+		RoleTitle:   "bold black",
+		RoleRule:    "bold 130",
+		RoleMenu:    "bold 28",
+		RoleWarning: "bold 160",
+		RoleAccent:  "25",
+		RoleBorder:  "246",
+		RoleMuted:   "242",
+		RoleNick:    "bold 28",
+		RoleSelf:    "bold 25",
+		RoleAction:  "italic 130",
+		RoleDirect:  "90",
+		RoleNotice:  "30",
+		RoleError:   "bold 160",
+		RoleMention: "bold 166",
+		RoleSelect:  "reverse",
+		RoleStatus:  "236 on 252",
+		RoleUnread:  "bold",
+	},
+	// mono uses no colour at all, only weight, underline and reverse.
+	"mono": {
+		RoleTitle:   "bold",
+		RoleRule:    "bold",
+		RoleMenu:    "bold",
+		RoleWarning: "bold underline",
+		RoleAccent:  "bold",
+		RoleBorder:  "",
+		RoleMuted:   "dim",
+		RoleNick:    "bold",
+		RoleSelf:    "bold",
+		RoleAction:  "italic",
+		RoleDirect:  "underline",
+		RoleNotice:  "dim",
+		RoleError:   "bold",
+		RoleMention: "bold underline",
+		RoleSelect:  "reverse",
+		RoleStatus:  "reverse",
+		RoleUnread:  "bold",
+	},
+}
+
+// Style is a parsed palette entry. Colours are "0" to "255" or "#rrggbb",
+// which lipgloss takes as they are.
+type Style struct {
+	Fg, Bg                                string
+	Bold, Dim, Italic, Underline, Reverse bool
+}
+
+// basicColors are the names of the first sixteen palette colours.
+var basicColors = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ParseStyle reads a palette entry; see Palette.
+func ParseStyle(spec string) (Style, error) {
+	var s Style
+	words := strings.Fields(strings.ToLower(spec))
+	for i := 0; i < len(words); i++ {
+		switch w := words[i]; w {
+		case "bold":
+			s.Bold = true
+		case "dim":
+			s.Dim = true
+		case "italic":
+			s.Italic = true
+		case "underline":
+			s.Underline = true
+		case "reverse":
+			s.Reverse = true
+		case "on":
+			if i+1 == len(words) {
+				return Style{}, fmt.Errorf("%q: no colour after on", spec)
+			}
+			i++
+			c, err := parseColor(words[i])
+			if err != nil {
+				return Style{}, fmt.Errorf("%q: %w", spec, err)
+			}
+			s.Bg = c
+		default:
+			c, err := parseColor(w)
+			if err != nil {
+				return Style{}, fmt.Errorf("%q: %w", spec, err)
+			}
+			s.Fg = c
+		}
+	}
+	return s, nil
+}
+
+func parseColor(w string) (string, error) {
+	name, bright := strings.CutPrefix(w, "bright-")
+	for i, c := range basicColors {
+		if name == c {
+			if bright {
+				i += 8
+			}
+			return strconv.Itoa(i), nil
+		}
+	}
+	if n, err := strconv.Atoi(w); err == nil && n >= 0 && n <= 255 {
+		return w, nil
+	}
+	if len(w) == 7 && w[0] == '#' {
+		if _, err := strconv.ParseUint(w[1:], 16, 32); err == nil {
+			return w, nil
+		}
+	}
+	return "", fmt.Errorf("unknown colour or attribute %q", w)
+}
+
+// SGR is the escape sequence that switches a terminal to s, or "" for none.
+func (s Style) SGR() string {
+	var codes []string
+	for _, a := range []struct {
+		on   bool
+		code string
+	}{{s.Bold, "1"}, {s.Dim, "2"}, {s.Italic, "3"}, {s.Underline, "4"}, {s.Reverse, "7"}} {
+		if a.on {
+			codes = append(codes, a.code)
+		}
+	}
+	if s.Fg != "" {
+		codes = append(codes, colorSGR(s.Fg, 30, 90, "38"))
+	}
+	if s.Bg != "" {
+		codes = append(codes, colorSGR(s.Bg, 40, 100, "48"))
+	}
+	if len(codes) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// colorSGR picks the shortest code for c: the 16 basic colours have their
+// own, the rest go through the 256-colour or true colour extensions.
+func colorSGR(c string, base, bright int, ext string) string {
+	if c[0] == '#' {
+		v, _ := strconv.ParseUint(c[1:], 16, 32)
+		return fmt.Sprintf("%s;2;%d;%d;%d", ext, v>>16, v>>8&0xff, v&0xff)
+	}
+	n, _ := strconv.Atoi(c)
+	switch {
+	case n < 8:
+		return strconv.Itoa(base + n)
+	case n < 16:
+		return strconv.Itoa(bright + n - 8)
+	}
+	return fmt.Sprintf("%s;5;%d", ext, n)
+}
+
+// NoColor drops the colours and keeps the attributes.
+func (s Style) NoColor() Style {
+	s.Fg, s.Bg = "", ""
+	return s
+}
+
+// Theme is a palette ready to use. The zero Theme styles nothing, for
+// output that is not a terminal.
+type Theme struct {
+	Name   string
+	styles map[string]Style
+}
+
+// Style is the style of role.
+func (t Theme) Style(role string) Style {
+	return t.styles[role]
+}
+
+// Render wraps text in role's style, resetting the terminal after it.
+func (t Theme) Render(role, text string) string {
+	sgr := t.styles[role].SGR()
+	if sgr == "" {
+		return text
+	}
+	return sgr + text + "\x1b[0m"
+}
+
+// ThemeNames lists the built-in and configured themes, sorted.
+func (c ChatConfig) ThemeNames() []string {
+	var names []string
+	for name := range Themes {
+		names = append(names, name)
+	}
+	for name := range c.Themes {
+		if _, ok := Themes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadTheme builds the theme called name from the config's palettes and
+// the built-in ones.
+func (c ChatConfig) LoadTheme(name string) (Theme, error) {
+	builtin, isBuiltin := Themes[name]
+	custom, isCustom := c.Themes[name]
+	if !isBuiltin && !isCustom {
+		return Theme{}, fmt.Errorf("unknown theme %q, pick one of %s", name, strings.Join(c.ThemeNames(), ", "))
+	}
+	if !isBuiltin {
+		builtin = Themes["default"]
+	}
+	t := Theme{Name: name, styles: make(map[string]Style)}
+	for _, role := range ThemeRoles {
+		spec := builtin[role]
+		if s, ok := custom[role]; ok {
+			spec = s
+		}
+		st, err := ParseStyle(spec)
+		if err != nil {
+			return Theme{}, fmt.Errorf("theme %s, %s: %w", name, role, err)
+		}
+		t.styles[role] = st
+	}
+	for role := range custom {
+		if !isRole(role) {
+			return Theme{}, fmt.Errorf("theme %s: unknown role %q, roles are %s", name, role, strings.Join(ThemeRoles, ", "))
+		}
+	}
+	return t, nil
+}
+
+func isRole(role string) bool {
+	for _, r := range ThemeRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// UseColor reports whether output gets colours. Color "auto" leaves them out
+// when the output is not a terminal, TERM is dumb or NO_COLOR is set, see
+// https://no-color.org.
+func (c ChatConfig) UseColor(tty bool) bool {
+	switch c.Color {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	return tty && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// OutputTheme is the theme for output to a terminal or not. Plain mode and
+// output that is not a terminal get the zero Theme, without any escape
+// sequences, unless Color is "always". Without colour the theme keeps its
+// bold, underline and the like.
+func (c ChatConfig) OutputTheme(tty bool) (Theme, error) {
+	if c.Plain || (!tty && c.Color != ColorAlways) {
+		return Theme{Name: "plain"}, nil
+	}
+	t, err := c.LoadTheme(c.Theme)
+	if err != nil {
+		return Theme{}, err
+	}
+	if !c.UseColor(tty) {
+		for role, s := range t.styles {
+			t.styles[role] = s.NoColor()
+		}
+	}
+	return t, nil
+}
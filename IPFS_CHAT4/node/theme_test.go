@@ -0,0 +1,98 @@
+package node
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStyle(t *testing.T) {
+	for spec, want := range map[string]string{
+		"":                     "",
+		"bold green":           "\x1b[1;32m",
+		"bright-white on blue": "\x1b[97;44m",
+		"italic 208":           "\x1b[3;38;5;208m",
+		"#ff8000 on 236":       "\x1b[38;2;255;128;0;48;5;236m",
+		"Reverse":              "\x1b[7m",
+	} {
+		s, err := ParseStyle(spec)
+		if err != nil {
+			t.Errorf("ParseStyle(%q): %v", spec, err)
+			continue
+		}
+		if got := s.SGR(); got != want {
+			t.Errorf("ParseStyle(%q).SGR() = %q, want %q", spec, got, want)
+		}
+	}
+	for _, bad := range []string{"purple", "256", "#12345", "bold on"} {
+		if _, err := ParseStyle(bad); err == nil {
+			t.Errorf("ParseStyle(%q) was accepted", bad)
+		}
+	}
+}
+
+func TestOutputTheme(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "xterm-256color")
+	c := DefaultChatConfig()
+	c.Themes = map[string]Palette{"solar": {RoleNick: "bold 33"}}
+	c.Theme = "solar"
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	th, err := c.OutputTheme(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := th.Render(RoleNick, "bob"); got != "\x1b[1;38;5;33mbob\x1b[0m" {
+		t.Errorf("custom role rendered %q", got)
+	}
+	if got := th.Render(RoleNotice, "hi"); got != "\x1b[36mhi\x1b[0m" {
+		t.Errorf("role left out of the palette rendered %q, want the default", got)
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	th, _ = c.OutputTheme(true)
+	if got := th.Render(RoleNick, "bob"); got != "\x1b[1mbob\x1b[0m" {
+		t.Errorf("NO_COLOR rendered %q, want bold only", got)
+	}
+	th, _ = c.OutputTheme(false)
+	if got := th.Render(RoleNick, "bob"); got != "bob" {
+		t.Errorf("output to a pipe rendered %q", got)
+	}
+	c.Color = ColorAlways
+	th, _ = c.OutputTheme(false)
+	if got := th.Render(RoleNick, "bob"); got == "bob" {
+		t.Error("color always rendered nothing")
+	}
+	c.Plain = true
+	th, _ = c.OutputTheme(true)
+	if got := th.Render(RoleNick, "bob"); got != "bob" {
+		t.Errorf("plain mode rendered %q", got)
+	}
+
+	c = DefaultChatConfig()
+	for _, tc := range []struct {
+		theme  string
+		themes map[string]Palette
+		color  string
+		want   string
+	}{
+		{theme: "nope", want: "unknown theme"},
+		{themes: map[string]Palette{"x": {"nickname": "red"}}, want: "unknown role"},
+		{themes: map[string]Palette{"x": {RoleNick: "purple"}}, want: "purple"},
+		{color: "sometimes", want: "color must be"},
+	} {
+		c := c
+		if tc.theme != "" {
+			c.Theme = tc.theme
+		}
+		c.Themes = tc.themes
+		if tc.color != "" {
+			c.Color = tc.color
+		}
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Validate() = %v, want %q", err, tc.want)
+		}
+	}
+}
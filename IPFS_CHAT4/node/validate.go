@@ -0,0 +1,274 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// MaxNickLength bounds the SenderNick of an accepted chat message.
+const MaxNickLength = 32
+
+// ValidateNick checks that nick is one we may publish under.
+func ValidateNick(nick string) error {
+	if nick == "" || utf8.RuneCountInString(nick) > MaxNickLength {
+		return fmt.Errorf("nicknames are 1 to %d characters", MaxNickLength)
+	}
+	if hasControl(nick) {
+		return fmt.Errorf("nicknames cannot contain control characters")
+	}
+	return nil
+}
+
+// hasControl reports whether s holds a control character such as ESC, which
+// would let its sender drive other people's terminals.
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
+
+// RoomLimits are the per-room checks applied to every chat message before it is delivered or forwarded.
+type RoomLimits struct {
+	// MaxMessageSize is the largest encoded ChatMessage accepted, in bytes.
+	MaxMessageSize int `json:"maxMessageSize"`
+	// Rate is the sustained number of messages per second allowed from one sender.
+	Rate float64 `json:"rate"`
+	// Burst is how many messages a sender may send back to back before Rate applies.
+	Burst int `json:"burst"`
+}
+
+// MessageLimitsConfig holds the default room limits and per-room overrides.
+// Rooms is keyed by room name or a path.Match pattern such as "ops-*".
+type MessageLimitsConfig struct {
+	Default RoomLimits            `json:"default"`
+	Rooms   map[string]RoomLimits `json:"rooms"`
+}
+
+// DefaultMessageLimitsConfig allows 4 KiB messages at two a second with bursts of ten.
+func DefaultMessageLimitsConfig() MessageLimitsConfig {
+	return MessageLimitsConfig{
+		Default: RoomLimits{
+			MaxMessageSize: 4096,
+			Rate:           2,
+			Burst:          10,
+		},
+	}
+}
+
+// Validate checks the default limits and every override.
+func (c MessageLimitsConfig) Validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default message limits: %w", err)
+	}
+	for pattern, l := range c.Rooms {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad room pattern %q: %w", pattern, err)
+		}
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("message limits for %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (l RoomLimits) validate() error {
+	switch {
+	case l.MaxMessageSize <= 0:
+		return fmt.Errorf("max message size must be positive")
+	case l.Rate <= 0:
+		return fmt.Errorf("rate must be positive")
+	case l.Burst < 1:
+		return fmt.Errorf("burst must be at least 1")
+	}
+	return nil
+}
+
+// For returns the limits for roomName. An exact name wins over a pattern, and
+// anything unmatched gets the defaults.
+func (c MessageLimitsConfig) For(roomName string) RoomLimits {
+	if l, ok := c.Rooms[roomName]; ok {
+		return l
+	}
+	patterns := make([]string, 0, len(c.Rooms))
+	for pattern := range c.Rooms {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, roomName); ok {
+			return c.Rooms[pattern]
+		}
+	}
+	return c.Default
+}
+
+// RegisterRoomValidator installs the chat message validator for topic on
+// the node self.
+func RegisterRoomValidator(ps *pubsub.PubSub, self peer.ID, topic string, limits RoomLimits) error {
+	return ps.RegisterTopicValidator(topic, NewRoomValidator(self, topic, limits))
+}
+
+// NewRoomValidator checks size, schema and per-sender rate.
+//
+// Oversized or malformed messages and spoofed senders are rejected, which
+// counts against the forwarding peer's score. A sender that is merely over its
+// rate is ignored, and only rejected once it keeps going after a full burst
+// of dropped messages. pubsub validates what self publishes too; the rate is
+// for protecting us from others, so our own messages skip it. Rejections are
+// logged at most once a minute per sender.
+func NewRoomValidator(self peer.ID, topic string, limits RoomLimits) pubsub.ValidatorEx {
+	buckets := newBucketSet(limits.Rate, limits.Burst)
+	rejects := newRejectLog()
+
+	return func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		if len(msg.Data) > limits.MaxMessageSize {
+			rejects.Printf(msg.GetFrom(), time.Now(), "%s: rejecting %d byte message from %s", topic, len(msg.Data), msg.GetFrom())
+			return pubsub.ValidationReject
+		}
+
+		var cm ChatMessage
+		if err := json.Unmarshal(msg.Data, &cm); err != nil {
+			rejects.Printf(msg.GetFrom(), time.Now(), "%s: rejecting malformed message from %s: %v", topic, msg.GetFrom(), err)
+			return pubsub.ValidationReject
+		}
+		if err := checkChatMessage(&cm, msg.GetFrom()); err != nil {
+			rejects.Printf(msg.GetFrom(), time.Now(), "%s: rejecting message from %s: %v", topic, msg.GetFrom(), err)
+			return pubsub.ValidationReject
+		}
+
+		if msg.ReceivedFrom == self {
+			return pubsub.ValidationAccept
+		}
+		switch buckets.take(msg.GetFrom(), time.Now()) {
+		case bucketOK:
+			return pubsub.ValidationAccept
+		case bucketOver:
+			return pubsub.ValidationIgnore
+		default:
+			return pubsub.ValidationReject
+		}
+	}
+}
+
+// checkChatMessage is the schema check for a decoded chat message.
+func checkChatMessage(cm *ChatMessage, author peer.ID) error {
+	switch cm.Kind {
+	case KindChat, KindAction:
+		if cm.Message == "" {
+			return fmt.Errorf("empty message")
+		}
+	case KindPresence:
+		if cm.Message != "" {
+			return fmt.Errorf("presence with a message body")
+		}
+		switch cm.Status {
+		case StatusOnline, StatusAway, StatusLeft:
+		default:
+			return fmt.Errorf("unknown presence status %q", cm.Status)
+		}
+	default:
+		return fmt.Errorf("unknown message kind %q", cm.Kind)
+	}
+
+	switch {
+	case !utf8.ValidString(cm.Message) || !utf8.ValidString(cm.SenderNick):
+		return fmt.Errorf("invalid utf-8")
+	case utf8.RuneCountInString(cm.SenderNick) > MaxNickLength:
+		return fmt.Errorf("nickname longer than %d characters", MaxNickLength)
+	case hasControl(cm.SenderNick):
+		return fmt.Errorf("control characters in nickname")
+	case cm.SenderID != author.String():
+		return fmt.Errorf("sender %q does not match author", cm.SenderID)
+	}
+	return nil
+}
+
+type bucketResult int
+
+const (
+	bucketOK bucketResult = iota
+	bucketOver
+	bucketFlood
+)
+
+// bucketIdle is how long a sender's bucket is kept after its last message.
+const bucketIdle = 10 * time.Minute
+
+// bucketSet is a token bucket per sender.
+type bucketSet struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[peer.ID]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	dropped int
+	last    time.Time
+}
+
+// take refills the bucket for the time since it was last used and takes a token if one is there.
+func (b *bucket) take(now time.Time, rate, burst float64) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func newBucketSet(rate float64, burst int) *bucketSet {
+	return &bucketSet{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[peer.ID]*bucket),
+	}
+}
+
+func (s *bucketSet) take(p peer.ID, now time.Time) bucketResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > bucketIdle {
+		for id, b := range s.buckets {
+			if now.Sub(b.last) > bucketIdle {
+				delete(s.buckets, id)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[p]
+	if !ok {
+		b = &bucket{tokens: s.burst, last: now}
+		s.buckets[p] = b
+	}
+
+	if b.take(now, s.rate, s.burst) {
+		b.dropped = 0
+		return bucketOK
+	}
+
+	b.dropped++
+	if float64(b.dropped) > s.burst {
+		return bucketFlood
+	}
+	return bucketOver
+}
@@ -0,0 +1,129 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func chatMsg(t *testing.T, from peer.ID, cm ChatMessage) *pubsub.Message {
+	t.Helper()
+	data, err := json.Marshal(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pubsub.Message{Message: &pb.Message{From: []byte(from), Data: data}}
+}
+
+func TestRoomValidator(t *testing.T) {
+	alice := peer.ID("alice")
+	limits := RoomLimits{MaxMessageSize: 128, Rate: 1, Burst: 2}
+	validate := NewRoomValidator(peer.ID("self"), "chat-room:test", limits)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		msg  *pubsub.Message
+		want pubsub.ValidationResult
+	}{
+		{"valid", chatMsg(t, alice, ChatMessage{Message: "hi", SenderID: alice.String(), SenderNick: "alice"}), pubsub.ValidationAccept},
+		{"oversized", chatMsg(t, alice, ChatMessage{Message: strings.Repeat("x", 200), SenderID: alice.String()}), pubsub.ValidationReject},
+		{"spoofed", chatMsg(t, alice, ChatMessage{Message: "hi", SenderID: "mallory"}), pubsub.ValidationReject},
+		{"empty", chatMsg(t, alice, ChatMessage{SenderID: alice.String()}), pubsub.ValidationReject},
+		{"garbage", &pubsub.Message{Message: &pb.Message{From: []byte(alice), Data: []byte("{")}}, pubsub.ValidationReject},
+		{"presence", chatMsg(t, alice, ChatMessage{Kind: KindPresence, Status: StatusAway, SenderID: alice.String()}), pubsub.ValidationAccept},
+		{"bad status", chatMsg(t, alice, ChatMessage{Kind: KindPresence, Status: "lurking", SenderID: alice.String()}), pubsub.ValidationReject},
+		{"control nick", chatMsg(t, alice, ChatMessage{Kind: KindPresence, Status: StatusOnline, SenderID: alice.String(), SenderNick: "\x1b[2Jalice"}), pubsub.ValidationReject},
+		{"unknown kind", chatMsg(t, alice, ChatMessage{Kind: "file", Message: "hi", SenderID: alice.String()}), pubsub.ValidationReject},
+	}
+	for _, tt := range tests {
+		if got := validate(ctx, alice, tt.msg); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLocalPublishNotRateLimited(t *testing.T) {
+	ctx := context.Background()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	cfg := DefaultConfig()
+	cfg.MessageLimits.Default.Rate = 1
+	cfg.MessageLimits.Default.Burst = 2
+	ps, err := NewPubSub(ctx, h, cfg.Router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err := JoinChatRoom(ctx, ps, h.ID(), "alice", "paste", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer room.Leave()
+
+	// A pasted block goes out line by line, well past the burst.
+	for i := 0; i < 5*cfg.MessageLimits.Default.Burst; i++ {
+		if err := room.Publish("line"); err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+	}
+}
+
+func TestBucketSet(t *testing.T) {
+	s := newBucketSet(1, 2)
+	p := peer.ID("p")
+	now := time.Now()
+
+	want := []bucketResult{bucketOK, bucketOK, bucketOver, bucketOver, bucketFlood}
+	for i, w := range want {
+		if got := s.take(p, now); got != w {
+			t.Fatalf("take %d: got %v, want %v", i, got, w)
+		}
+	}
+
+	// A second later one token has come back.
+	if got := s.take(p, now.Add(time.Second)); got != bucketOK {
+		t.Fatalf("after refill: got %v, want ok", got)
+	}
+}
+
+func TestValidateNick(t *testing.T) {
+	for nick, ok := range map[string]bool{
+		"alice":                              true,
+		"zoë":                                true,
+		"":                                   false,
+		strings.Repeat("x", MaxNickLength+1): false,
+		"bob\x1b[31m":                        false,
+		"line\nbreak":                        false,
+	} {
+		if err := ValidateNick(nick); (err == nil) != ok {
+			t.Errorf("ValidateNick(%q) = %v", nick, err)
+		}
+	}
+}
+
+func TestValidateRoomName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"lobby":                                  true,
+		"café-42":                                true,
+		"":                                       false,
+		" lobby":                                 false,
+		"big room":                               false,
+		"a/b":                                    false,
+		"esc\x1b":                                false,
+		strings.Repeat("r", MaxRoomNameLength+1): false,
+	} {
+		if err := ValidateRoomName(name); (err == nil) != ok {
+			t.Errorf("ValidateRoomName(%q) = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"IPFS_CHAT4/node"
+
+	"golang.org/x/term"
+)
+
+// stdout is where command output goes.
+var stdout io.Writer = os.Stdout
+
+// theme colours what the chat apps print. Until setupOutput runs it is the
+// zero Theme, which prints plain text.
+var theme node.Theme
+
+// plain is the accessible mode: every event on one line that starts with
+// what it is, and no colours or cursor movement.
+var plain bool
+
+// setupOutput picks the theme for stdout from the config.
+func setupOutput(cfg node.ChatConfig) error {
+	t, err := cfg.OutputTheme(term.IsTerminal(int(os.Stdout.Fd())))
+	if err != nil {
+		return err
+	}
+	theme, plain = t, cfg.Plain
+	return nil
+}
+
+// clean makes what a peer sent safe to print: no control characters, and in
+// plain mode no line breaks either, written as \n instead, so every event
+// stays on its one labelled line.
+func clean(s string) string {
+	s = node.Sanitize(s)
+	if plain {
+		s = strings.ReplaceAll(s, "\n", `\n`)
+	}
+	return s
+}
+
+// formatMessage is a room message as printed. tagged adds the room name,
+// for when we are in several rooms; plain mode always has it.
+func formatMessage(room, nick, text, kind string, self, tagged bool) string {
+	nick, text = clean(nick), clean(text)
+	if plain {
+		label := "message"
+		if kind == node.KindAction {
+			label = "action"
+		}
+		return fmt.Sprintf("%s %s %s: %s\n", label, room, nick, text)
+	}
+	tag := ""
+	if tagged {
+		tag = "[" + room + "] "
+	}
+	if kind == node.KindAction {
+		return tag + theme.Render(node.RoleAction, "* "+nick+" "+text) + "\n"
+	}
+	role := node.RoleNick
+	if self {
+		role = node.RoleSelf
+	}
+	return tag + theme.Render(role, nick) + ": " + text + "\n"
+}
+
+// formatDirect is a direct message from nick, with the sender's short peer
+// ID since anyone can pick any nick.
+func formatDirect(nick, from, text string) string {
+	nick, text = clean(nick), clean(text)
+	if plain {
+		return fmt.Sprintf("direct from %s (%s): %s\n", nick, from, text)
+	}
+	return theme.Render(node.RoleDirect, fmt.Sprintf("[dm] %s (%s)", nick, from)) + ": " + text + "\n"
+}
+
+// formatDirectSent echoes a direct message we sent.
+func formatDirectSent(to, text string) string {
+	text = clean(text)
+	if plain {
+		return fmt.Sprintf("direct to %s: %s\n", to, text)
+	}
+	return theme.Render(node.RoleDirect, "[dm -> "+to+"]") + " " + text + "\n"
+}
+
+// formatNotice is an event that is not a message, such as a LAN peer.
+func formatNotice(text string) string {
+	if plain {
+		return "notice " + text + "\n"
+	}
+	return theme.Render(node.RoleNotice, "* "+text) + "\n"
+}
+
+// printInfo prints a line of what a command did or found. Plain mode starts
+// it with "info".
+func printInfo(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if plain {
+		line = "info " + strings.TrimLeft(line, " ")
+	}
+	fmt.Fprintln(stdout, line)
+}
+
+// printError reports a failed command or message.
+func printError(err error) {
+	if plain {
+		fmt.Fprintln(stdout, "error", err)
+		return
+	}
+	fmt.Fprintln(stdout, theme.Render(node.RoleError, err.Error()))
+}
+
+// menuRule is a line across the menu, left out in plain mode.
+func menuRule(c string) {
+	if !plain {
+		fmt.Println(theme.Render(node.RoleRule, strings.Repeat(c, 45)))
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"IPFS_CHAT4/node"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// relayPort is where a relay listens unless -listen says otherwise.
+const relayPort = 4001
+
+// runRelay runs a headless node that strengthens the mesh for a set of topics
+// without subscribing to, storing or displaying any of their messages.
+//
+//	IPFS_CHAT4 relay -topics chat-room:lobby,chat-room:dev -listen /ip4/0.0.0.0/tcp/4001
+func runRelay(args []string) {
+	cfg, err := node.LoadConfig(GetConfigFile())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	topics := fs.String("topics", "", "comma separated topics to relay, e.g. chat-room:lobby")
+	interval := fs.Duration("stats", time.Minute, "how often to log relay stats, 0 to disable")
+	cfg.BindFlags(fs)
+	fs.Parse(args)
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	cfg.Log.Apply()
+	topicNames := splitList(*topics)
+	if len(topicNames) == 0 {
+		log.Fatal("relay: -topics is required")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	blocklist, err := node.LoadBlocklist(filepath.Join(GetProfileDir(), node.BlocklistFile))
+	if err != nil {
+		log.Fatal(err)
+	}
+	blocklist.ReloadOnHangup(ctx)
+
+	h, lan, err := MakeHost(ctx, relayPort, cfg, blocklist)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close()
+	if lan != nil {
+		defer lan.Close()
+	}
+
+	stats := node.NewRelayStats(topicNames)
+	opts, err := cfg.PubSubOptions(h, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts = append(opts, pubsub.WithRawTracer(stats), pubsub.WithBlacklist(blocklist))
+	ps, err := node.NewPubSub(ctx, h, cfg.Router, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stop, err := node.RelayTopics(ps, h.ID(), topicNames, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	if cfg.DHT.Enabled {
+		rdv, err := node.StartDHT(ctx, h, cfg.DHT)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rdv.Close()
+
+		// Let chat users beyond the LAN find the relay through the rooms it serves.
+		for _, name := range topicNames {
+			if roomName, ok := strings.CutPrefix(name, node.TopicName("")); ok {
+				rdv.Advertise(roomName)
+			}
+		}
+	}
+
+	log.Printf("Relay %s using %s on the %s network", h.ID(), cfg.Router.Describe(), cfg.PrivateNet.Describe(GetProfileDir()))
+	for _, a := range node.DialAddrs(h) {
+		log.Printf(" - %s", a)
+	}
+	log.Printf("Relaying %s", strings.Join(topicNames, ", "))
+
+	var tick <-chan time.Time
+	if *interval > 0 {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			logRelayStats(stats, len(h.Network().Peers()))
+		case <-ctx.Done():
+			logRelayStats(stats, len(h.Network().Peers()))
+			log.Println("Relay shutting down")
+			return
+		}
+	}
+}
+
+func logRelayStats(stats *node.RelayStats, peers int) {
+	log.Printf("Up %s, %d peers connected", time.Since(stats.Started).Round(time.Second), peers)
+	snap := stats.Snapshot()
+	for _, name := range stats.Topics() {
+		ts := snap[name]
+		log.Printf(" %s: mesh=%d received=%d forwarded=%d (%d bytes) duplicates=%d rejected=%d",
+			name, ts.MeshPeers, ts.Received, ts.Forwarded, ts.ForwardedBytes, ts.Duplicates, ts.Rejected)
+	}
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
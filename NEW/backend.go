@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sync"
+
+	"IPFS_CHAT4/control"
+)
+
+// backend is where the TUI's rooms live: a node in this process, or a
+// daemon it is attached to. Both speak the control API's types.
+type backend interface {
+	Status() (control.Status, error)
+	Join(room string) error
+	Leave(room string) error
+	Publish(room, text string, action bool) error
+	SetNick(nick string) error
+	Peers(room string) ([]control.Peer, error)
+	History(room string, limit int) ([]control.Message, error)
+	// Messages delivers the messages of every joined room. It is closed
+	// when the backend goes away.
+	Messages() <-chan control.Message
+	Close() error
+}
+
+// localBackend runs the rooms on this process's own node, through the same
+// server a daemon uses, so both modes behave alike.
+type localBackend struct {
+	srv *control.Server
+	out chan control.Message
+
+	mu    sync.Mutex
+	stops map[string]func()
+}
+
+func newLocalBackend(srv *control.Server) *localBackend {
+	return &localBackend{
+		srv:   srv,
+		out:   make(chan control.Message, control.HistorySize),
+		stops: make(map[string]func()),
+	}
+}
+
+func (b *localBackend) Status() (control.Status, error) {
+	return b.srv.Status(), nil
+}
+
+func (b *localBackend) Join(room string) error {
+	if err := b.srv.Join(room); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.stops[room]; ok {
+		return nil
+	}
+	messages, stop, err := b.srv.Watch(room)
+	if err != nil {
+		return err
+	}
+	b.stops[room] = stop
+	go func() {
+		for m := range messages {
+			b.out <- m
+		}
+	}()
+	return nil
+}
+
+// Leave leaves the room, which also ends its watch.
+func (b *localBackend) Leave(room string) error {
+	b.mu.Lock()
+	delete(b.stops, room)
+	b.mu.Unlock()
+	return b.srv.Leave(room)
+}
+
+func (b *localBackend) Publish(room, text string, action bool) error {
+	return b.srv.Publish(control.PublishParams{Room: room, Message: text, Action: action})
+}
+
+func (b *localBackend) SetNick(nick string) error {
+	return b.srv.SetNick(nick)
+}
+
+func (b *localBackend) Peers(room string) ([]control.Peer, error) {
+	return b.srv.Peers(room)
+}
+
+func (b *localBackend) History(room string, limit int) ([]control.Message, error) {
+	return b.srv.History(control.HistoryParams{Room: room, Limit: limit})
+}
+
+func (b *localBackend) Messages() <-chan control.Message {
+	return b.out
+}
+
+// Close leaves every room; the UI is quitting, so out stays open.
+func (b *localBackend) Close() error {
+	return b.srv.Close()
+}
+
+// remoteBackend is attached to a daemon. Closing it detaches and leaves the
+// daemon in its rooms.
+type remoteBackend struct {
+	c *control.Client
+}
+
+func (b remoteBackend) Status() (control.Status, error) {
+	return b.c.Status()
+}
+
+func (b remoteBackend) Join(room string) error {
+	if err := b.c.Join(room); err != nil {
+		return err
+	}
+	return b.c.Subscribe(room)
+}
+
+func (b remoteBackend) Leave(room string) error {
+	return b.c.Leave(room)
+}
+
+func (b remoteBackend) Publish(room, text string, action bool) error {
+	return b.c.Publish(room, text, action)
+}
+
+func (b remoteBackend) SetNick(nick string) error {
+	return b.c.SetNick(nick)
+}
+
+func (b remoteBackend) Peers(room string) ([]control.Peer, error) {
+	return b.c.Peers(room)
+}
+
+func (b remoteBackend) History(room string, limit int) ([]control.Message, error) {
+	return b.c.History(control.HistoryParams{Room: room, Limit: limit})
+}
+
+func (b remoteBackend) Messages() <-chan control.Message {
+	return b.c.Messages()
+}
+
+func (b remoteBackend) Close() error {
+	return b.c.Close()
+}
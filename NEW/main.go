@@ -4,11 +4,15 @@ import (
     "bufio"
     "context"
     "crypto/rand"
+    "flag"
     "fmt"
     "os"
     "io"
+    "path/filepath"
+    "sort"
     "strings"
     "log"
+    "time"
 
     "github.com/libp2p/go-libp2p"
     "github.com/libp2p/go-libp2p/core/crypto"
@@ -17,187 +21,920 @@ import (
     "github.com/libp2p/go-libp2p/core/peer"
     "github.com/libp2p/go-libp2p/core/peerstore"
     "github.com/multiformats/go-multiaddr"
+    "github.com/charmbracelet/bubbles/textinput"
+    "github.com/charmbracelet/bubbles/viewport"
     tea "github.com/charmbracelet/bubbletea"
+    "github.com/charmbracelet/lipgloss"
+    "github.com/muesli/termenv"
+    "golang.org/x/term"
     pubsub "github.com/libp2p/go-libp2p-pubsub"
+    "IPFS_CHAT4/control"
+    "IPFS_CHAT4/node"
 
 )
 
+// pane is a part of the screen that can have the keyboard.
+type pane int
+
+const (
+    paneInput pane = iota
+    paneRooms
+    paneMessages
+    panePeers
+    paneCount
+)
+
+func (p pane) String() string {
+    return [...]string{"input", "rooms", "messages", "peers"}[p]
+}
+
+// Overlays cover the panes until Esc.
+const (
+    overlayNone      = ""
+    overlayHelp      = "help"
+    overlayDirectory = "directory"
+    overlayStatus    = "status"
+    overlayLimits    = "limits"
+)
+
+// maxLog is how many messages the TUI keeps per room.
+const maxLog = 1000
+
+// mentionsLabel is how the rooms pane shows the mentions room, which
+// collects the messages mentioning us in every room. It is not a room, so
+// it is never looked up by name.
+const mentionsLabel = "@ mentions"
+
+// paneEntry is one line of the rooms pane: a joined room, or the mentions
+// room when mentions is set.
+type paneEntry struct {
+    room     string
+    mentions bool
+}
+
 type model struct {
+    // The node in this process. All nil when attached to a daemon.
     host         host.Host
-    currentView  string
-    input        string
-    messages     []string
-    errorMessage string
-    messageChan  chan string
     ps *pubsub.PubSub
-    selectedMenuItem int
-    subscribedTopics []string
+    cfg node.Config
+    scores *node.ScoreBoard
+    ctx context.Context
+    dir *node.Directory
+    lan *node.LANDiscovery
+    lanLog []string
+    rdv *node.Rendezvous
+    reach *node.Reachability
+    blocklist *node.Blocklist
+
+    be backend
+    // attached is the daemon's socket when the rooms live there.
+    attached string
+    network string
+    nick string
+    daemon control.Status
+    gone bool
+
+    rooms    []string
+    // current is the room on screen, "" when there is none or the mentions
+    // room is on screen instead.
+    current  string
+    inMentions bool
+    logs     map[string][]control.Message
+    mentionLog []control.Message
+    peers    []control.Peer
+    peerCursor int
+    // unread and mentions count what arrived in a room while it was not on
+    // screen.
+    unread   map[string]int
+    mentions map[string]int
+    unreadMentions int
+    hl       *node.Highlighter
+
+    focus    pane
+    overlay  string
+    selectedRoom int
+    viewport viewport.Model
+    input    textinput.Model
+    commands *node.Commands
+    // pending is the tea.Cmd a slash command asks for.
+    pending  tea.Cmd
+    width, height int
+
+    status       string
+    errorMessage string
 }
 
-func (m model) Init() tea.Cmd {
-    return nil
+// lanLogSize is how many LAN discovery events the status view keeps.
+const lanLogSize = 5
+
+// lanMsg carries a LAN peer appearing or disappearing into the UI.
+type lanMsg node.LANEvent
+
+func waitLAN(lan *node.LANDiscovery) tea.Cmd {
+    return func() tea.Msg {
+        ev, ok := <-lan.Events()
+        if !ok {
+            return nil
+        }
+        return lanMsg(ev)
+    }
 }
 
-func (m *model) updateMessages() {
-    m.host.SetStreamHandler("/chat/1.0.0", func(s network.Stream) {
-        rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
-        go func() {
-            for {
-                str, err := rw.ReadString('\n')
-                if err != nil {
-                    log.Println("Error reading from buffer")
-                    break
-                }
-                m.messageChan <- str
-            }
-        }()
+// tickMsg refreshes views that show live node state, such as peer scores.
+type tickMsg time.Time
+
+func tick() tea.Cmd {
+    return tea.Tick(node.ScoreInspectInterval, func(t time.Time) tea.Msg {
+        return tickMsg(t)
     })
 }
 
+// roomMsg is a message in one of our rooms, ours included.
+type roomMsg control.Message
 
-func (m *model) publishMessage(topic string, msg string) error {
-    t, err := m.ps.Join(topic)
-    if err != nil {
-        return err
+// goneMsg means the backend closed its message stream: the daemon went away.
+type goneMsg struct{}
+
+func waitMessage(be backend) tea.Cmd {
+    return func() tea.Msg {
+        m, ok := <-be.Messages()
+        if !ok {
+            return goneMsg{}
+        }
+        return roomMsg(m)
+    }
+}
+
+// joinedMsg is a finished join with the room's history. show switches to it.
+type joinedMsg struct {
+    room    string
+    history []control.Message
+    show    bool
+    err     error
+}
+
+func joinCmd(be backend, room string, show bool) tea.Cmd {
+    return func() tea.Msg {
+        if err := be.Join(room); err != nil {
+            return joinedMsg{room: room, err: err}
+        }
+        history, err := be.History(room, control.HistorySize)
+        return joinedMsg{room: room, history: history, show: show, err: err}
     }
+}
 
-    sub, err := t.Subscribe()
+type leftMsg struct {
+    room string
+    err  error
+}
+
+func leaveCmd(be backend, room string) tea.Cmd {
+    return func() tea.Msg {
+        return leftMsg{room: room, err: be.Leave(room)}
+    }
+}
+
+// errMsg reports a command that failed in the background.
+type errMsg struct{ err error }
+
+func publishCmd(be backend, room, text string, action bool) tea.Cmd {
+    return func() tea.Msg {
+        if err := be.Publish(room, text, action); err != nil {
+            return errMsg{err}
+        }
+        return nil
+    }
+}
+
+type nickMsg struct {
+    nick string
+    err  error
+}
+
+func nickCmd(be backend, nick string) tea.Cmd {
+    return func() tea.Msg {
+        return nickMsg{nick: nick, err: be.SetNick(nick)}
+    }
+}
+
+type peersMsg struct {
+    room  string
+    peers []control.Peer
+}
+
+func peersCmd(be backend, room string) tea.Cmd {
+    if room == "" {
+        return nil
+    }
+    return func() tea.Msg {
+        peers, err := be.Peers(room)
+        if err != nil {
+            return nil
+        }
+        return peersMsg{room: room, peers: peers}
+    }
+}
+
+type statusMsg control.Status
+
+func statusCmd(be backend) tea.Cmd {
+    return func() tea.Msg {
+        st, err := be.Status()
+        if err != nil {
+            return nil
+        }
+        return statusMsg(st)
+    }
+}
+
+func newModel(be backend, cfg node.Config) (*model, error) {
+    hl, err := node.NewHighlighter(cfg.Chat.Nick, cfg.Chat.Highlight)
     if err != nil {
-        return err
+        return nil, err
     }
-    defer sub.Cancel()
+    input := textinput.New()
+    input.Prompt = "> "
+    input.Placeholder = "Type a message, /help for commands"
+    input.PlaceholderStyle = theme.timestamp
+    input.Focus()
+
+    m := &model{
+        be:       be,
+        cfg:      cfg,
+        nick:     cfg.Chat.Nick,
+        hl:       hl,
+        logs:     make(map[string][]control.Message),
+        unread:   make(map[string]int),
+        mentions: make(map[string]int),
+        viewport: viewport.New(0, 0),
+        input:    input,
+        commands: node.NewCommands(),
+    }
+    m.registerCommands()
+    return m, nil
+}
 
-    return t.Publish(context.Background(), []byte(msg))
+// Init joins the startup rooms and starts listening for messages.
+func (m *model) Init() tea.Cmd {
+    cmds := []tea.Cmd{tick(), textinput.Blink, waitMessage(m.be), statusCmd(m.be)}
+    if m.lan != nil {
+        cmds = append(cmds, waitLAN(m.lan))
+    }
+    for i, name := range m.startRooms() {
+        cmds = append(cmds, joinCmd(m.be, name, i == 0))
+    }
+    return tea.Batch(cmds...)
 }
 
+// startRooms are the daemon's rooms when attached, then the configured ones.
+func (m *model) startRooms() []string {
+    var names []string
+    for _, r := range m.daemon.Rooms {
+        names = append(names, r.Name)
+    }
+    for _, name := range m.cfg.Chat.Rooms {
+        if !contains(names, name) {
+            names = append(names, name)
+        }
+    }
+    return names
+}
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
     switch msg := msg.(type) {
+    case tea.WindowSizeMsg:
+        m.width, m.height = msg.Width, msg.Height
+        m.layout()
+        return m, nil
+
     case tea.KeyMsg:
-        switch msg.Type {
-        case tea.KeyUp, tea.KeyDown:
-            if msg.Type == tea.KeyUp {
-                m.selectedMenuItem--
-            } else {
-                m.selectedMenuItem++
-            }
+        return m.handleKey(msg)
 
-            menuItemsCount := 4
-            if m.selectedMenuItem > menuItemsCount {
-                m.selectedMenuItem = 1
-            } else if m.selectedMenuItem < 1 {
-                m.selectedMenuItem = menuItemsCount
+    case roomMsg:
+        cmds := []tea.Cmd{waitMessage(m.be)}
+        for _, added := range m.addMessages(msg.Room, control.Message(msg)) {
+            cmds = append(cmds, m.notice(added))
+        }
+        return m, tea.Batch(cmds...)
+
+    case goneMsg:
+        m.gone = true
+        m.errorMessage = "The daemon went away. Ctrl+C to quit."
+        return m, nil
+
+    case joinedMsg:
+        if msg.err != nil {
+            m.errorMessage = msg.err.Error()
+            return m, nil
+        }
+        if !contains(m.rooms, msg.room) {
+            m.rooms = append(m.rooms, msg.room)
+        }
+        // Mentions from before we joined are collected, but not counted.
+        for _, added := range m.addMessages(msg.room, msg.history...) {
+            if !added.Self && m.hl.Match(added.Text) {
+                m.addMention(added)
             }
+        }
+        if msg.show || (m.current == "" && !m.inMentions) {
+            return m, m.showRoom(msg.room)
+        }
+        return m, nil
 
-        case tea.KeyEnter:
-            switch m.currentView {
-            case "publish":
-                if m.input != "" {
-                    err := m.publishMessage("your_topic", m.input)
-                    if err != nil {
-                        m.errorMessage = err.Error()
-                    }
-                    m.input = ""
-                }
-            case "menu":
-                switch m.selectedMenuItem {
-                case 1:
-                    m.currentView = "subscribe"
-                case 2:
-                    m.currentView = "publish"
-                case 3:
-                    m.currentView = "listTopics"
-                case 4:
-                    m.currentView = "listPeers"
-                }
+    case leftMsg:
+        if msg.err != nil {
+            m.errorMessage = msg.err.Error()
+            return m, nil
+        }
+        m.dropRoom(msg.room)
+        m.status = "Left " + msg.room
+        if m.inMentions {
+            return m, nil
+        }
+        return m, m.showRoom(m.current)
+
+    case nickMsg:
+        if msg.err != nil {
+            m.errorMessage = msg.err.Error()
+            return m, nil
+        }
+        m.nick = msg.nick
+        m.hl.SetNick(msg.nick)
+        m.status = "You are now " + msg.nick
+        return m, nil
+
+    case peersMsg:
+        if msg.room == m.current {
+            m.peers = msg.peers
+            if m.peerCursor >= len(m.peers) {
+                m.peerCursor = len(m.peers) - 1
             }
+            if m.peerCursor < 0 {
+                m.peerCursor = 0
+            }
+        }
+        return m, nil
 
-        case tea.KeyCtrlC, tea.KeyEsc:
-            return m, tea.Quit
+    case statusMsg:
+        m.daemon = control.Status(msg)
+        if msg.Nick != m.nick {
+            m.nick = msg.Nick
+            m.hl.SetNick(msg.Nick)
         }
+        if m.network == "" {
+            m.network = msg.Network
+        }
+        return m, nil
+
+    case errMsg:
+        m.errorMessage = msg.err.Error()
+        return m, nil
 
-    case string:
-        m.messages = append(m.messages, msg)
+    case tickMsg:
+        if m.gone {
+            return m, tick()
+        }
+        return m, tea.Batch(tick(), peersCmd(m.be, m.current), statusCmd(m.be))
+
+    case lanMsg:
+        line := fmt.Sprintf("%s LAN peer %s %s", msg.At.Format("15:04:05"), msg.Peer.ID.ShortString(), msg.Kind)
+        m.lanLog = append(m.lanLog, line)
+        if len(m.lanLog) > lanLogSize {
+            m.lanLog = m.lanLog[len(m.lanLog)-lanLogSize:]
+        }
+        m.status = line
+        return m, waitLAN(m.lan)
     }
 
-    return m, nil
+    // Cursor blinks and the like.
+    var cmd tea.Cmd
+    m.input, cmd = m.input.Update(msg)
+    return m, cmd
 }
 
+// handleKey runs the keys that work everywhere, then those of the overlay or
+// the focused pane.
+func (m *model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+    if msg.Type == tea.KeyCtrlC {
+        return m, tea.Quit
+    }
+    if m.overlay != overlayNone {
+        return m, m.overlayKey(msg)
+    }
 
+    switch msg.String() {
+    case "tab":
+        if m.focus == paneInput && node.IsCommand(m.input.Value()) {
+            m.complete()
+            return m, nil
+        }
+        m.setFocus((m.focus + 1) % paneCount)
+        return m, nil
+    case "shift+tab":
+        m.setFocus((m.focus + paneCount - 1) % paneCount)
+        return m, nil
+    case "ctrl+n":
+        return m, m.cycleRoom(1)
+    case "ctrl+p":
+        return m, m.cycleRoom(-1)
+    case "f1":
+        m.openOverlay(overlayHelp)
+        return m, nil
+    case "f2":
+        m.openOverlay(overlayDirectory)
+        return m, nil
+    case "f3":
+        m.openOverlay(overlayStatus)
+        return m, nil
+    case "f4":
+        m.openOverlay(overlayLimits)
+        return m, nil
+    case "esc":
+        if m.focus != paneInput {
+            m.setFocus(paneInput)
+        } else {
+            m.input.Reset()
+        }
+        m.errorMessage = ""
+        return m, nil
+    case "pgup", "pgdown":
+        // The message pane scrolls by page whichever pane has the keyboard.
+        var cmd tea.Cmd
+        m.viewport, cmd = m.viewport.Update(msg)
+        return m, cmd
+    }
 
-func (m model) View() string {
-    var s strings.Builder
+    switch m.focus {
+    case paneInput:
+        if msg.Type == tea.KeyEnter {
+            return m, m.submit()
+        }
+        var cmd tea.Cmd
+        m.input, cmd = m.input.Update(msg)
+        return m, cmd
+
+    case paneRooms:
+        switch msg.String() {
+        case "up", "k":
+            return m, m.cycleRoom(-1)
+        case "down", "j":
+            return m, m.cycleRoom(1)
+        case "enter":
+            m.setFocus(paneInput)
+        case "delete", "x":
+            if m.current != "" {
+                return m, leaveCmd(m.be, m.current)
+            }
+        }
 
-    switch m.currentView {
-    case "menu":
-        // Dynamically display menu items with selection
-        menuItems := []string{"Subscribe to a topic", "Publish a message", "List topics", "List peers"}
+    case paneMessages:
+        switch msg.String() {
+        case "home", "g":
+            m.viewport.GotoTop()
+        case "end", "G":
+            m.viewport.GotoBottom()
+        case "enter":
+            m.setFocus(paneInput)
+        default:
+            var cmd tea.Cmd
+            m.viewport, cmd = m.viewport.Update(msg)
+            return m, cmd
+        }
 
-        s.WriteString("Dangerous Net | IPFS Chat Menu\n")
-        for i, item := range menuItems {
-            if m.selectedMenuItem == i+1 {
-                s.WriteString(fmt.Sprintf("-> %d. %s\n", i+1, item)) // Highlight selected item
-            } else {
-                s.WriteString(fmt.Sprintf("   %d. %s\n", i+1, item))
+    case panePeers:
+        switch msg.String() {
+        case "up", "k":
+            if m.peerCursor > 0 {
+                m.peerCursor--
+            }
+        case "down", "j":
+            if m.peerCursor < len(m.peers)-1 {
+                m.peerCursor++
             }
+        case "enter":
+            m.setFocus(paneInput)
         }
-        s.WriteString("\nPress number to select, Esc to return to menu\n")
+    }
+    return m, nil
+}
 
-    case "subscribe":
-        // Handle the subscribe view here
-        // Example:
-        s.WriteString("Enter topic to subscribe: " + m.input + "\n")
+// overlayKey handles keys while an overlay covers the panes.
+func (m *model) overlayKey(msg tea.KeyMsg) tea.Cmd {
+    switch msg.String() {
+    case "esc", "q", "f1", "f2", "f3", "f4":
+        m.overlay = overlayNone
+        return nil
+    }
+    if m.overlay != overlayDirectory {
+        return nil
+    }
+    switch msg.Type {
+    case tea.KeyUp, tea.KeyDown:
+        m.moveRoomSelection(msg.Type)
+    case tea.KeyEnter:
+        if cmd := m.joinSelectedRoom(); cmd != nil {
+            m.overlay = overlayNone
+            return cmd
+        }
+    }
+    return nil
+}
 
-    case "publish":
-        // Handle the publish view here
-        // Example:
-        s.WriteString("Enter message to publish: " + m.input + "\n")
+func (m *model) openOverlay(name string) {
+    m.overlay = name
+    m.status = ""
+    m.errorMessage = ""
+}
 
-    case "listTopics":
-        s.WriteString("List of Topics:\n")
-        for _, topic := range listTopics() { // Assuming listTopics is a function that returns []string
-            s.WriteString(fmt.Sprintf("- %s\n", topic))
-        }
+func (m *model) setFocus(p pane) {
+    m.focus = p
+    if p == paneInput {
+        m.input.Focus()
+    } else {
+        m.input.Blur()
+    }
+}
+
+// submit sends the typed line to the current room or runs it as a command.
+func (m *model) submit() tea.Cmd {
+    text := m.input.Value()
+    if strings.TrimSpace(text) == "" {
+        return nil
+    }
+    m.input.Reset()
+    m.status = ""
+    m.errorMessage = ""
 
-    case "listPeers":
-        s.WriteString("List of Peers:\n")
-        for _, peerID := range listPeers(m.host) { // Assuming listPeers is a function that returns []string
-            s.WriteString(fmt.Sprintf("- %s\n", peerID))
+    if node.IsCommand(text) {
+        if err := m.commands.Run(text); err != nil {
+            m.errorMessage = err.Error()
         }
+        cmd := m.pending
+        m.pending = nil
+        return cmd
+    }
+    if m.inMentions {
+        m.errorMessage = "The mentions room is read-only, switch to a room to reply"
+        return nil
+    }
+    if m.current == "" {
+        m.errorMessage = "Not in a room, /join one first"
+        return nil
+    }
+    return publishCmd(m.be, m.current, strings.TrimPrefix(text, "/"), false)
+}
 
-    // Add other cases if necessary
+// complete fills in the command line as far as every candidate agrees and
+// lists them when they do not.
+func (m *model) complete() {
+    candidates := m.commands.Complete(m.input.Value())
+    switch len(candidates) {
+    case 0:
+        return
+    case 1:
+        m.input.SetValue(candidates[0])
+    default:
+        m.input.SetValue(node.CommonPrefix(candidates))
+        m.status = strings.Join(candidates, "  ")
     }
+    m.input.CursorEnd()
+}
 
-    // Error message display
-    if m.errorMessage != "" {
-        s.WriteString("\nError: " + m.errorMessage + "\n")
+// showRoom puts a room on screen, marks it read and asks for its peers.
+func (m *model) showRoom(name string) tea.Cmd {
+    if name != m.current {
+        m.peers = nil
+        m.peerCursor = 0
     }
+    m.current = name
+    m.inMentions = false
+    delete(m.unread, name)
+    delete(m.mentions, name)
+    m.refreshViewport(true)
+    return peersCmd(m.be, name)
+}
 
-    return s.String()
+// showMentions puts the mentions room on screen and marks it read.
+func (m *model) showMentions() tea.Cmd {
+    m.peers = nil
+    m.peerCursor = 0
+    m.current = ""
+    m.inMentions = true
+    m.unreadMentions = 0
+    m.refreshViewport(true)
+    return nil
 }
 
+// roomList is the rooms pane: the mentions room, then the joined rooms.
+func (m *model) roomList() []paneEntry {
+    entries := []paneEntry{{mentions: true}}
+    for _, name := range m.rooms {
+        entries = append(entries, paneEntry{room: name})
+    }
+    return entries
+}
 
+// onScreen is the rooms pane entry on screen.
+func (m *model) onScreen() paneEntry {
+    if m.inMentions {
+        return paneEntry{mentions: true}
+    }
+    return paneEntry{room: m.current}
+}
 
-func (m *model) subscribeToTopic(topicName string) error {
-    // Join the topic
-    t, err := m.ps.Join(topicName)
-    if err != nil {
-        return err
+// cycleRoom switches to the next or previous room in the rooms pane.
+func (m *model) cycleRoom(step int) tea.Cmd {
+    entries := m.roomList()
+    i := 0
+    for j, e := range entries {
+        if e == m.onScreen() {
+            i = j
+        }
+    }
+    i = (i + step + len(entries)) % len(entries)
+    if entries[i].mentions {
+        return m.showMentions()
     }
+    return m.showRoom(entries[i].room)
+}
 
-    // Subscribe to the topic
-    _, err = t.Subscribe()
-    if err != nil {
-        return err
+// notice counts a new message in a room that is not on screen and collects
+// it in the mentions room if it is meant for us. It rings the bell, when
+// configured, for mentions.
+func (m *model) notice(msg control.Message) tea.Cmd {
+    if msg.Self {
+        return nil
+    }
+    if msg.Room != m.current {
+        m.unread[msg.Room]++
+    }
+    if !m.hl.Match(msg.Text) {
+        return nil
+    }
+    if msg.Room != m.current {
+        m.mentions[msg.Room]++
+    }
+    if !m.inMentions {
+        m.unreadMentions++
+    }
+    m.addMention(msg)
+    if m.cfg.Chat.Bell {
+        return ringBell
     }
+    return nil
+}
 
-    // Optionally, keep track of subscribed topics
-    m.subscribedTopics = append(m.subscribedTopics, topicName)
+// ringBell rings the terminal bell. Bubble Tea owns the screen, but a bare
+// BEL moves no cursor and draws nothing.
+func ringBell() tea.Msg {
+    os.Stdout.WriteString("\a")
     return nil
 }
 
+// addMention adds a message to the mentions room in time order, once.
+func (m *model) addMention(msg control.Message) {
+    lines := m.mentionLog
+    for _, l := range lines {
+        if l.Room == msg.Room && l.Seq == msg.Seq {
+            return
+        }
+    }
+    i := len(lines)
+    for i > 0 && lines[i-1].Time.After(msg.Time) {
+        i--
+    }
+    lines = append(lines, control.Message{})
+    copy(lines[i+1:], lines[i:])
+    lines[i] = msg
+    if len(lines) > maxLog {
+        lines = lines[len(lines)-maxLog:]
+    }
+    m.mentionLog = lines
+    if m.inMentions {
+        m.refreshViewport(false)
+    }
+}
 
-func makeHost(port int, randomness io.Reader) (host.Host, error) {
+// dropRoom forgets a room we left, moving to a neighbour if it was on screen.
+func (m *model) dropRoom(name string) {
+    i := indexOf(m.rooms, name)
+    if i < 0 {
+        return
+    }
+    m.rooms = append(m.rooms[:i], m.rooms[i+1:]...)
+    // Its mentions stay in the mentions room.
+    delete(m.logs, name)
+    delete(m.unread, name)
+    delete(m.mentions, name)
+    if m.current != name {
+        return
+    }
+    m.current = ""
+    if len(m.rooms) > 0 {
+        if i >= len(m.rooms) {
+            i = len(m.rooms) - 1
+        }
+        m.current = m.rooms[i]
+    }
+}
+
+// addMessages merges messages into a room's log by seq, skipping any we
+// already have: history and live messages overlap right after a join, in
+// either order. The daemon numbers a room from 1 again when it leaves and
+// rejoins it, so a message that takes the seq of a different one, or that
+// comes before the whole log yet is newer than all of it, starts the log
+// over. It returns the ones it added.
+func (m *model) addMessages(room string, msgs ...control.Message) []control.Message {
+    lines := m.logs[room]
+    var added []control.Message
+    for _, msg := range msgs {
+        i := sort.Search(len(lines), func(i int) bool { return lines[i].Seq >= msg.Seq })
+        if i < len(lines) && lines[i].Seq == msg.Seq {
+            if sameMessage(lines[i], msg) {
+                continue
+            }
+            lines, i = nil, 0
+        } else if i == 0 && len(lines) > 0 && msg.Time.After(lines[len(lines)-1].Time) {
+            lines = nil
+        }
+        lines = append(lines, control.Message{})
+        copy(lines[i+1:], lines[i:])
+        lines[i] = msg
+        added = append(added, msg)
+    }
+    if len(lines) > maxLog {
+        lines = lines[len(lines)-maxLog:]
+    }
+    m.logs[room] = lines
+    if room == m.current {
+        m.refreshViewport(false)
+    }
+    return added
+}
+
+// sameMessage reports whether a and b are one message seen twice.
+func sameMessage(a, b control.Message) bool {
+    return a.From == b.From && a.Text == b.Text && a.Time.Equal(b.Time)
+}
+
+// refreshViewport redraws the current room's messages, following new ones
+// unless the user has scrolled up. jump always goes to the newest.
+func (m *model) refreshViewport(jump bool) {
+    follow := jump || m.viewport.AtBottom()
+    m.viewport.SetContent(m.renderLog())
+    if follow {
+        m.viewport.GotoBottom()
+    }
+}
+
+func (m *model) registerCommands() {
+    c := m.commands
+    c.Register(node.Command{
+        Name: "help", Help: "show keys and commands",
+        Run: func([]string) error {
+            m.openOverlay(overlayHelp)
+            return nil
+        },
+    })
+    c.Register(node.Command{
+        Name: "join", Args: "<room>", Help: "join a room, or switch to one we are in",
+        MinArgs: 1, MaxArgs: 1, Complete: m.completeRooms,
+        Run: func(args []string) error {
+            if contains(m.rooms, args[0]) {
+                m.pending = m.showRoom(args[0])
+                return nil
+            }
+            m.pending = joinCmd(m.be, args[0], true)
+            return nil
+        },
+    })
+    c.Register(node.Command{
+        Name: "mentions", Help: "show the messages that mention you, from every room",
+        Run: func([]string) error {
+            m.pending = m.showMentions()
+            return nil
+        },
+    })
+    c.Register(node.Command{
+        Name: "leave", Args: "[room]", Help: "leave a room, the current one by default",
+        MaxArgs: 1, Complete: m.completeRooms,
+        Run: func(args []string) error {
+            name := m.current
+            if len(args) == 1 {
+                name = args[0]
+            }
+            if name == "" && m.inMentions {
+                return fmt.Errorf("the mentions room cannot be left")
+            }
+            if name == "" {
+                return fmt.Errorf("not in a room")
+            }
+            m.pending = leaveCmd(m.be, name)
+            return nil
+        },
+    })
+    c.Register(node.Command{
+        Name: "me", Args: "<action>", Help: "say what you are doing, e.g. /me waves",
+        MinArgs: 1, MaxArgs: 1, Rest: true,
+        Run: func(args []string) error {
+            if m.inMentions {
+                return fmt.Errorf("the mentions room is read-only")
+            }
+            if m.current == "" {
+                return fmt.Errorf("not in a room, /join one first")
+            }
+            m.pending = publishCmd(m.be, m.current, args[0], true)
+            return nil
+        },
+    })
+    c.Register(node.Command{
+        Name: "nick", Args: "<name>", Help: "change your nickname in every room",
+        MinArgs: 1, MaxArgs: 1,
+        Run: func(args []string) error {
+            m.pending = nickCmd(m.be, args[0])
+            return nil
+        },
+    })
+    c.Register(node.Command{
+        Name: "quit", Help: "quit; an attached daemon stays in its rooms",
+        Run: func([]string) error {
+            m.pending = tea.Quit
+            return nil
+        },
+    })
+}
+
+func (m *model) completeRooms(_ int, prefix string) []string {
+    var out []string
+    for _, r := range m.rooms {
+        if strings.HasPrefix(r, prefix) {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+// usedOf formats a used/limit pair, where a limit of -1 means unlimited.
+func usedOf(used, limit int64) string {
+    if limit < 0 {
+        return fmt.Sprintf("%d/unlimited", used)
+    }
+    return fmt.Sprintf("%d/%d", used, limit)
+}
+
+func (m *model) moveRoomSelection(key tea.KeyType) {
+    if m.dir == nil {
+        return
+    }
+    count := len(m.dir.Rooms())
+    if count == 0 {
+        m.selectedRoom = 0
+        return
+    }
+    if key == tea.KeyUp {
+        m.selectedRoom--
+    } else {
+        m.selectedRoom++
+    }
+    if m.selectedRoom >= count {
+        m.selectedRoom = 0
+    } else if m.selectedRoom < 0 {
+        m.selectedRoom = count - 1
+    }
+}
+
+// joinSelectedRoom joins the room under the cursor in the directory view.
+// The room announces itself in the directory and on the DHT in turn.
+func (m *model) joinSelectedRoom() tea.Cmd {
+    if m.dir == nil {
+        return nil
+    }
+    rooms := m.dir.Rooms()
+    if m.selectedRoom < 0 || m.selectedRoom >= len(rooms) {
+        return nil
+    }
+    name := rooms[m.selectedRoom].Name
+    if contains(m.rooms, name) {
+        return m.showRoom(name)
+    }
+    return joinCmd(m.be, name, true)
+}
+
+func contains(list []string, s string) bool {
+    return indexOf(list, s) >= 0
+}
+
+func indexOf(list []string, s string) int {
+    for i, v := range list {
+        if v == s {
+            return i
+        }
+    }
+    return -1
+}
+
+
+func makeHost(port int, randomness io.Reader, cfg node.Config, blocklist *node.Blocklist) (host.Host, error) {
     var prvKey crypto.PrivKey
     var err error
 
@@ -215,54 +952,200 @@ func makeHost(port int, randomness io.Reader) (host.Host, error) {
         return nil, err
     }
 
-    // 0.0.0.0 will listen on any interface device.
-    sourceMultiAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port))
+    // Join the private network when one is configured.
+    profileDir, err := node.ProfileDir()
+    if err != nil {
+        return nil, err
+    }
+    psk, err := cfg.PrivateNet.Load(profileDir)
+    if err != nil {
+        return nil, err
+    }
+
+    // Listen on every configured transport, or the defaults on port.
+    opts, err := cfg.HostOptions(port, psk)
     if err != nil {
         log.Println(err)
         return nil, err
     }
 
-    // Constructs a new libp2p Host with the given options.
-    return libp2p.New(
-        libp2p.ListenAddrs(sourceMultiAddr),
-        libp2p.Identity(prvKey),
-    )
+    // Constructs a new libp2p Host with the given options, refusing blocked peers.
+    h, err := libp2p.New(append(opts, libp2p.Identity(prvKey), libp2p.ConnectionGater(blocklist))...)
+    if err != nil {
+        return nil, err
+    }
+    blocklist.Attach(h)
+    return h, nil
 }
 
 
 
 
 func main() {
+    profileDir, err := node.ProfileDir()
+    if err != nil {
+        log.Fatal(err)
+    }
+    cfg, err := node.LoadConfig(node.ConfigPath(profileDir))
+    if err != nil {
+        log.Fatal(err)
+    }
+    attach := flag.Bool("attach", false, "use the rooms of a running daemon instead of starting a node")
+    socket := flag.String("socket", filepath.Join(profileDir, control.SocketFile), "daemon control socket for -attach")
+    cfg.BindFlags(flag.CommandLine)
+    flag.Parse()
+
+    if err := cfg.Validate(); err != nil {
+        log.Fatal(err)
+    }
+    cfg.Log.Apply()
+
+    // The TUI redraws the whole screen, which is no use in a pipe or to a
+    // screen reader; the line client has a plain mode for both.
+    if cfg.Chat.Plain || !term.IsTerminal(int(os.Stdout.Fd())) {
+        log.Fatal("the TUI needs a terminal; for plain output run IPFS_CHAT4 -plain, or IPFS_CHAT4 attach -plain with a daemon")
+    }
+    t, err := cfg.Chat.OutputTheme(true)
+    if err != nil {
+        log.Fatal(err)
+    }
+    theme = newStyles(t)
+    // Under NO_COLOR lipgloss drops bold and reverse along with the colours.
+    // The theme has left the colours out already, and selections need reverse.
+    if lipgloss.ColorProfile() == termenv.Ascii {
+        lipgloss.SetColorProfile(termenv.ANSI)
+    }
+
+    var m *model
+    if *attach {
+        c, err := control.Dial(*socket)
+        if err != nil {
+            log.Fatalf("no daemon on %s, start one with: IPFS_CHAT4 daemon (%v)", *socket, err)
+        }
+        st, err := c.Status()
+        if err != nil {
+            log.Fatal(err)
+        }
+        m, err = newModel(remoteBackend{c}, cfg)
+        if err != nil {
+            log.Fatal(err)
+        }
+        m.attached = *socket
+        m.daemon = st
+        m.nick = st.Nick
+        m.hl.SetNick(st.Nick)
+        m.network = st.Network
+    } else {
+        var stop func()
+        m, stop = startNode(cfg, profileDir)
+        defer stop()
+    }
+    defer m.be.Close()
+
+    p := tea.NewProgram(m, tea.WithAltScreen())
+    if _, err := p.Run(); err != nil {
+        fmt.Printf("Error running program: %v", err)
+        os.Exit(1)
+    }
+}
+
+// startNode brings up a node in this process and a model for it. stop
+// shuts the node down.
+func startNode(cfg node.Config, profileDir string) (m *model, stop func()) {
+    ctx := context.Background()
+    var closers []func()
+    stop = func() {
+        for i := len(closers) - 1; i >= 0; i-- {
+            closers[i]()
+        }
+    }
+    blocklist, err := node.LoadBlocklist(filepath.Join(profileDir, node.BlocklistFile))
+    if err != nil {
+        log.Fatal(err)
+    }
+    blocklist.ReloadOnHangup(ctx)
+
     // Initialize libp2p host and other necessary components
-    h, err := makeHost(0, nil) // nil indicates default randomness
+    h, err := makeHost(0, nil, cfg, blocklist) // nil indicates default randomness
     if err != nil {
-        log.Println(err)
-        return
+        log.Fatal(err)
+    }
+    closers = append(closers, func() { h.Close() })
+
+    reach, err := node.WatchReachability(h)
+    if err != nil {
+        log.Fatal(err)
+    }
+    closers = append(closers, reach.Close)
+
+    var lan *node.LANDiscovery
+    if cfg.MDNS.Enabled {
+        lan, err = node.StartMDNS(ctx, h, cfg.MDNS)
+        if err != nil {
+            log.Fatal(err)
+        }
+        closers = append(closers, lan.Close)
     }
-    defer h.Close()
 
     // Initialize the PubSub service
-    ps, err := pubsub.NewGossipSub(context.Background(), h)
+    scores := node.NewScoreBoard(cfg.Scoring)
+    opts, err := cfg.PubSubOptions(h, scores)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    opts = append(opts, pubsub.WithBlacklist(blocklist))
+    ps, err := node.NewPubSub(ctx, h, cfg.Router, opts...)
     if err != nil {
         log.Fatal(err)
     }
 
-    // Initialize the model with the host, PubSub service, and set initial view
-    m := model{
-        host:        h,
-        messageChan: make(chan string),
-        ps:          ps,
-        currentView: "menu", // Set initial view to "menu"
-	selectedMenuItem: 1,
+    if cfg.Reconnect.Enabled {
+        book, err := node.StartPeerBook(ctx, h, ps, filepath.Join(profileDir, node.PeerBookFile), cfg.Reconnect)
+        if err != nil {
+            log.Fatal(err)
+        }
+        closers = append(closers, func() { book.Close() })
     }
 
-    m.updateMessages()
+    var rdv *node.Rendezvous
+    if cfg.DHT.Enabled {
+        rdv, err = node.StartDHT(ctx, h, cfg.DHT)
+        if err != nil {
+            log.Fatal(err)
+        }
+        closers = append(closers, rdv.Close)
+    }
 
-    p := tea.NewProgram(&m)
-    if err := p.Start(); err != nil {
-        fmt.Printf("Error running program: %v", err)
-        os.Exit(1)
+    var dir *node.Directory
+    if cfg.Directory.Enabled {
+        dir, err = node.JoinDirectory(ctx, ps, h.ID(), cfg.Directory)
+        if err != nil {
+            log.Fatal(err)
+        }
+        closers = append(closers, dir.Close)
+    }
+
+    network := cfg.PrivateNet.Describe(profileDir)
+    srv := control.NewServer(ctx, h, ps, cfg, dir, rdv, network)
+
+    // The rooms run on the same server a daemon uses; the model keeps the
+    // node's parts for the directory, status and limits views.
+    m, err = newModel(newLocalBackend(srv), cfg)
+    if err != nil {
+        log.Fatal(err)
     }
+    m.host = h
+    m.ps = ps
+    m.scores = scores
+    m.ctx = ctx
+    m.dir = dir
+    m.blocklist = blocklist
+    m.network = network
+    m.lan = lan
+    m.rdv = rdv
+    m.reach = reach
+    return m, stop
 }
 
 func startPeer(ctx context.Context, h host.Host, streamHandler network.StreamHandler) {
@@ -271,53 +1154,20 @@ func startPeer(ctx context.Context, h host.Host, streamHandler network.StreamHan
 	// Only applies on the receiving side.
 	h.SetStreamHandler("/chat/1.0.0", streamHandler)
 
-	// Let's get the actual TCP port from our listen multiaddr, in case we're using 0 (default; random available port).
-	var port string
-	for _, la := range h.Network().ListenAddresses() {
-		if p, err := la.ValueForProtocol(multiaddr.P_TCP); err == nil {
-			port = p
-			break
-		}
-	}
-
-	if port == "" {
-		log.Println("was not able to find actual local port")
+	addrs := node.DialAddrs(h)
+	if len(addrs) == 0 {
+		log.Println("was not able to find a dialable address")
 		return
 	}
 
-	log.Printf("Run './chat -d /ip4/127.0.0.1/tcp/%v/p2p/%s' on another console.\n", port, h.ID())
-	log.Println("You can replace 127.0.0.1 with public IP as well.")
+	log.Println("Run './chat -d <address>' on another console with one of:")
+	for _, a := range addrs {
+		log.Printf(" - %s\n", a)
+	}
 	log.Println("Waiting for incoming connection")
 	log.Println()
 }
 
-func listPeers(h host.Host) []string {
-    peers := h.Network().Peers()
-    peerIDs := make([]string, len(peers))
-    for i, peer := range peers {
-        peerIDs[i] = peer.String()
-    }
-    return peerIDs
-}
-
-
-var topics map[string]*pubsub.Topic
-
-func addTopic(topicName string, topic *pubsub.Topic) {
-    if topics == nil {
-        topics = make(map[string]*pubsub.Topic)
-    }
-    topics[topicName] = topic
-}
-
-func listTopics() []string {
-    var topicNames []string
-    for name := range topics {
-        topicNames = append(topicNames, name)
-    }
-    return topicNames
-}
-
 
 func startPeerAndConnect(ctx context.Context, h host.Host, destination string) (*bufio.ReadWriter, error) {
 	log.Println("This node's multiaddresses:")
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"IPFS_CHAT4/control"
+	"IPFS_CHAT4/node"
+)
+
+func newTestModel(t *testing.T) *model {
+	t.Helper()
+	cfg := node.DefaultConfig()
+	cfg.Chat.Nick = "alice"
+	m, err := newModel(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+var epoch = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func testMessage(room string, seq uint64, text string) control.Message {
+	return control.Message{Seq: seq, Room: room, From: "bob-id", Nick: "bob", Text: text, Time: epoch.Add(time.Duration(seq) * time.Second)}
+}
+
+func seqs(msgs []control.Message) []uint64 {
+	var out []uint64
+	for _, msg := range msgs {
+		out = append(out, msg.Seq)
+	}
+	return out
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAddMessagesMerges(t *testing.T) {
+	m := newTestModel(t)
+
+	// A live message arrives before the history it is also in.
+	m.addMessages("lobby", testMessage("lobby", 3, "three"))
+	added := m.addMessages("lobby", testMessage("lobby", 1, "one"), testMessage("lobby", 2, "two"), testMessage("lobby", 3, "three"))
+	if got := seqs(added); !equalSeqs(got, []uint64{1, 2}) {
+		t.Errorf("added %v, want [1 2]", got)
+	}
+	m.addMessages("lobby", testMessage("lobby", 5, "five"), testMessage("lobby", 4, "four"))
+	if got := seqs(m.logs["lobby"]); !equalSeqs(got, []uint64{1, 2, 3, 4, 5}) {
+		t.Errorf("log %v, want [1 2 3 4 5]", got)
+	}
+}
+
+func TestAddMessagesRestart(t *testing.T) {
+	m := newTestModel(t)
+	m.addMessages("lobby", testMessage("lobby", 1, "one"), testMessage("lobby", 2, "two"), testMessage("lobby", 3, "three"))
+
+	// The daemon left and rejoined the room: seq starts at 1 again.
+	again := testMessage("lobby", 1, "after the rejoin")
+	again.Time = epoch.Add(time.Minute)
+	if added := m.addMessages("lobby", again); len(added) != 1 {
+		t.Fatalf("added %d messages, want 1", len(added))
+	}
+	if log := m.logs["lobby"]; len(log) != 1 || log[0].Text != "after the rejoin" {
+		t.Fatalf("log %+v, want only the message after the rejoin", log)
+	}
+
+	// Also when the log no longer holds the seq the count restarts at.
+	m.logs["lobby"] = nil
+	m.addMessages("lobby", testMessage("lobby", 7, "seven"), testMessage("lobby", 8, "eight"))
+	again.Time = epoch.Add(time.Hour)
+	m.addMessages("lobby", again)
+	if got := seqs(m.logs["lobby"]); !equalSeqs(got, []uint64{1}) {
+		t.Errorf("log %v, want [1]", got)
+	}
+}
+
+func TestNoticeCounts(t *testing.T) {
+	m := newTestModel(t)
+	m.rooms = []string{"lobby", "dev"}
+	m.current = "lobby"
+
+	for _, msg := range []control.Message{
+		testMessage("dev", 1, "no mention"),
+		testMessage("dev", 2, "hi alice"),
+		testMessage("lobby", 1, "alice, look"),
+		{Seq: 3, Room: "dev", Text: "alice said this", Self: true},
+	} {
+		for _, added := range m.addMessages(msg.Room, msg) {
+			m.notice(added)
+		}
+	}
+
+	if m.unread["dev"] != 2 || m.mentions["dev"] != 1 {
+		t.Errorf("dev unread %d mentions %d, want 2 and 1", m.unread["dev"], m.mentions["dev"])
+	}
+	// The room on screen counts nothing, but its mentions are still collected.
+	if m.unread["lobby"] != 0 || m.mentions["lobby"] != 0 {
+		t.Errorf("lobby unread %d mentions %d, want 0 and 0", m.unread["lobby"], m.mentions["lobby"])
+	}
+	if m.unreadMentions != 2 || len(m.mentionLog) != 2 {
+		t.Errorf("mentions room unread %d with %d messages, want 2 and 2", m.unreadMentions, len(m.mentionLog))
+	}
+
+	// A mention seen again, as in a join's history, is collected once.
+	m.addMention(testMessage("dev", 2, "hi alice"))
+	if len(m.mentionLog) != 2 {
+		t.Errorf("mentions room has %d messages after a repeat, want 2", len(m.mentionLog))
+	}
+}
+
+func TestCycleRooms(t *testing.T) {
+	m := newTestModel(t)
+	m.rooms = []string{"lobby", "dev"}
+	m.showRoom("lobby")
+
+	var seen []paneEntry
+	for i := 0; i < 3; i++ {
+		m.cycleRoom(1)
+		seen = append(seen, m.onScreen())
+	}
+	want := []paneEntry{{room: "dev"}, {mentions: true}, {room: "lobby"}}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("cycled through %+v, want %+v", seen, want)
+		}
+	}
+
+	// The mentions room is not a room: nothing can be sent to it.
+	m.showMentions()
+	m.input.SetValue("hi")
+	if m.submit() != nil || m.current != "" || m.errorMessage == "" {
+		t.Errorf("current %q, error %q: the mentions room took a message", m.current, m.errorMessage)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"IPFS_CHAT4/control"
+	"IPFS_CHAT4/node"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Outer sizes of the fixed panes, borders included. The side panes are
+// dropped on narrow terminals.
+const (
+	roomsWidth   = 22
+	peersWidth   = 26
+	inputHeight  = 3
+	statusHeight = 1
+)
+
+// styles are the TUI's colours and borders, made from a node.Theme.
+type styles struct {
+	pane, focusedPane lipgloss.Style
+	title, selected   lipgloss.Style
+	timestamp         lipgloss.Style
+	nick, selfNick    lipgloss.Style
+	action, system    lipgloss.Style
+	error, notice     lipgloss.Style
+	statusBar         lipgloss.Style
+	// mention marks messages meant for us and their counts; unread is the
+	// rooms with new messages.
+	mention, unread lipgloss.Style
+}
+
+func newStyles(t node.Theme) styles {
+	role := func(r string) lipgloss.Style { return lipStyle(t.Style(r)) }
+	pane := lipgloss.NewStyle().Border(lipgloss.RoundedBorder())
+	if c := t.Style(node.RoleBorder).Fg; c != "" {
+		pane = pane.BorderForeground(lipgloss.Color(c))
+	}
+	// Without an accent colour a heavier border shows where the keyboard is.
+	focused := lipgloss.NewStyle().Border(lipgloss.ThickBorder())
+	if c := t.Style(node.RoleAccent).Fg; c != "" {
+		focused = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color(c))
+	}
+	return styles{
+		pane:        pane,
+		focusedPane: focused,
+		title:       role(node.RoleTitle),
+		selected:    role(node.RoleSelect),
+		timestamp:   role(node.RoleMuted),
+		nick:        role(node.RoleNick),
+		selfNick:    role(node.RoleSelf),
+		action:      role(node.RoleAction),
+		system:      role(node.RoleMuted).Italic(true),
+		error:       role(node.RoleError),
+		notice:      role(node.RoleNotice),
+		statusBar:   role(node.RoleStatus),
+		mention:     role(node.RoleMention),
+		unread:      role(node.RoleUnread),
+	}
+}
+
+// lipStyle sets only what s turns on, so the result still inherits the rest.
+func lipStyle(s node.Style) lipgloss.Style {
+	st := lipgloss.NewStyle()
+	if s.Fg != "" {
+		st = st.Foreground(lipgloss.Color(s.Fg))
+	}
+	if s.Bg != "" {
+		st = st.Background(lipgloss.Color(s.Bg))
+	}
+	if s.Bold {
+		st = st.Bold(true)
+	}
+	if s.Dim {
+		st = st.Faint(true)
+	}
+	if s.Italic {
+		st = st.Italic(true)
+	}
+	if s.Underline {
+		st = st.Underline(true)
+	}
+	if s.Reverse {
+		st = st.Reverse(true)
+	}
+	return st
+}
+
+// theme is replaced by the configured one in main.
+var theme = newStyles(node.Theme{})
+
+// sideWidths are the widths of the room and peer panes for the terminal's width.
+func (m *model) sideWidths() (rooms, peers int) {
+	rooms, peers = roomsWidth, peersWidth
+	if m.width < 90 {
+		peers = 0
+	}
+	if m.width < 50 {
+		rooms = 0
+	}
+	return rooms, peers
+}
+
+// layout sizes the viewport and input to the terminal.
+func (m *model) layout() {
+	rooms, peers := m.sideWidths()
+	body := m.height - inputHeight - statusHeight
+	// Borders on both sides, and a title line above the messages.
+	m.viewport.Width = max(m.width-rooms-peers-2, 1)
+	m.viewport.Height = max(body-3, 1)
+	m.input.Width = max(m.width-2-lipgloss.Width(m.input.Prompt)-1, 1)
+	m.refreshViewport(false)
+}
+
+func (m *model) View() string {
+	if m.width == 0 {
+		return "Starting…"
+	}
+	body := m.height - inputHeight - statusHeight
+	if m.overlay != overlayNone {
+		return lipgloss.JoinVertical(lipgloss.Left,
+			m.box(false, m.width, m.height-statusHeight, m.overlayTitle(), m.overlayView()),
+			m.statusBar())
+	}
+
+	rooms, peers := m.sideWidths()
+	var panes []string
+	if rooms > 0 {
+		panes = append(panes, m.box(m.focus == paneRooms, rooms, body, "Rooms", m.roomsView(rooms-2, body-3)))
+	}
+	panes = append(panes, m.box(m.focus == paneMessages, m.width-rooms-peers, body, m.messagesTitle(), m.viewport.View()))
+	if peers > 0 {
+		panes = append(panes, m.box(m.focus == panePeers, peers, body, "Peers", m.peersView(peers-2, body-3)))
+	}
+
+	input := theme.pane
+	if m.focus == paneInput {
+		input = theme.focusedPane
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		lipgloss.JoinHorizontal(lipgloss.Top, panes...),
+		input.Width(m.width-2).Render(m.input.View()),
+		m.statusBar())
+}
+
+// box draws a bordered pane of outer size w by h with a title line.
+func (m *model) box(focused bool, w, h int, title, content string) string {
+	st := theme.pane
+	if focused {
+		st = theme.focusedPane
+	}
+	inner := w - 2
+	lines := strings.Split(content, "\n")
+	if len(lines) > h-3 {
+		lines = lines[:max(h-3, 0)]
+	}
+	clip := lipgloss.NewStyle().MaxWidth(inner)
+	for i, l := range lines {
+		lines[i] = clip.Render(l)
+	}
+	return st.Width(inner).Height(h - 2).Render(clip.Render(theme.title.Render(title)) + "\n" + strings.Join(lines, "\n"))
+}
+
+// roomsView lists the rooms with what arrived in them while they were not
+// on screen: the unread count and, after an @, how many mention us.
+func (m *model) roomsView(w, h int) string {
+	entries := m.roomList()
+	// Keep the current room in sight when the list is longer than the pane.
+	start := 0
+	for i, e := range entries {
+		if e == m.onScreen() && i >= h {
+			start = i - h + 1
+		}
+	}
+	var lines []string
+	for _, e := range entries[start:] {
+		name, unread, mentions := e.room, m.unread[e.room], m.mentions[e.room]
+		if e.mentions {
+			name, unread, mentions = mentionsLabel, m.unreadMentions, 0
+		}
+		var counts []string
+		if unread > 0 {
+			counts = append(counts, theme.unread.Render(fmt.Sprint(unread)))
+		}
+		if mentions > 0 {
+			counts = append(counts, theme.mention.Render(fmt.Sprintf("@%d", mentions)))
+		}
+		right := strings.Join(counts, " ")
+		label := " " + name
+		if unread > 0 {
+			label = theme.unread.Render(label)
+		}
+		gap := max(w-lipgloss.Width(label)-lipgloss.Width(right)-1, 1)
+		line := label + strings.Repeat(" ", gap) + right + " "
+		if e == m.onScreen() {
+			line = theme.selected.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	if len(m.rooms) == 0 {
+		lines = append(lines, theme.system.Render(" no rooms yet"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m *model) peersView(w, h int) string {
+	if m.current == "" {
+		return ""
+	}
+	if len(m.peers) == 0 {
+		return theme.system.Render("nobody else yet")
+	}
+	start := 0
+	if m.focus == panePeers && m.peerCursor >= h {
+		start = m.peerCursor - h + 1
+	}
+	var lines []string
+	for i, p := range m.peers[start:] {
+		name := node.Sanitize(p.Nick)
+		if name == "" {
+			name = shortID(p.ID)
+		}
+		line := fmt.Sprintf(" %-*s %s", max(w-10, 1), name, p.Status)
+		if m.focus == panePeers && start+i == m.peerCursor {
+			line = theme.selected.Render(fmt.Sprintf("%-*s", w, line))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m *model) messagesTitle() string {
+	title := m.current
+	switch {
+	case m.inMentions:
+		title = mentionsLabel
+	case m.current == "":
+		return "No room"
+	}
+	if !m.viewport.AtBottom() {
+		title += fmt.Sprintf("  (%d%%, End for newest)", int(m.viewport.ScrollPercent()*100))
+	}
+	return title
+}
+
+// renderLog draws the current room's messages, wrapped to the viewport.
+func (m *model) renderLog() string {
+	if m.inMentions && len(m.mentionLog) == 0 {
+		return theme.system.Render("Messages that mention you in any room collect here.")
+	}
+	if m.current == "" && !m.inMentions {
+		return theme.system.Render("Not in a room. /join one, or press F2 for the room directory.")
+	}
+	msgs := m.logs[m.current]
+	if m.inMentions {
+		msgs = m.mentionLog
+	}
+	if len(msgs) == 0 {
+		return theme.system.Render("No messages in " + m.current + " yet.")
+	}
+	wrap := lipgloss.NewStyle().Width(m.viewport.Width)
+	lines := make([]string, len(msgs))
+	for i, msg := range msgs {
+		switch {
+		case m.inMentions:
+			lines[i] = wrap.Render(theme.timestamp.Render("["+msg.Room+"]") + " " + formatMessage(msg))
+		case !msg.Self && m.hl.Match(msg.Text):
+			lines[i] = wrap.Inherit(theme.mention).Render(formatMessage(msg))
+		default:
+			lines[i] = wrap.Render(formatMessage(msg))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatMessage(msg control.Message) string {
+	ts := theme.timestamp.Render(msg.Time.Local().Format("15:04"))
+	text := node.Sanitize(msg.Text)
+	if msg.Kind == node.KindAction {
+		return ts + " " + theme.action.Render("* "+node.Sanitize(msg.Nick)+" "+text)
+	}
+	nick := theme.nick
+	if msg.Self {
+		nick = theme.selfNick
+	}
+	return ts + " " + nick.Render(node.Sanitize(msg.Nick)) + ": " + text
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return "…" + id[len(id)-8:]
+	}
+	return id
+}
+
+func (m *model) statusBar() string {
+	left := m.nick
+	if m.network != "" {
+		left += " on " + m.network
+	}
+	if m.attached != "" {
+		left += " (attached)"
+	}
+	if m.host != nil {
+		left += fmt.Sprintf(" | %d peers", len(m.host.Network().Peers()))
+	} else {
+		left += fmt.Sprintf(" | %d peers", m.daemon.Peers)
+	}
+	if len(m.rooms) > 0 && !m.inMentions {
+		left += fmt.Sprintf(" | room %d/%d", indexOf(m.rooms, m.current)+1, len(m.rooms))
+	}
+	if n := m.unreadMentions; n > 0 {
+		left += " | " + theme.mention.Inherit(theme.statusBar).Render(fmt.Sprintf("@%d", n))
+	}
+	left += " | " + m.focus.String()
+
+	right := "Tab panes  Ctrl+N/P rooms  F1 help"
+	switch {
+	case m.errorMessage != "":
+		right = theme.error.Inherit(theme.statusBar).Render(m.errorMessage)
+	case m.status != "":
+		right = m.status
+	}
+	gap := m.width - lipgloss.Width(left) - lipgloss.Width(right) - 2
+	if gap < 1 {
+		gap = 1
+	}
+	bar := " " + left + strings.Repeat(" ", gap) + right + " "
+	return theme.statusBar.Width(m.width).MaxWidth(m.width).Render(bar)
+}
+
+func (m *model) overlayTitle() string {
+	switch m.overlay {
+	case overlayHelp:
+		return "Help (Esc to close)"
+	case overlayDirectory:
+		return "Room Directory (Up/Down to select, Enter to join, Esc to close)"
+	case overlayStatus:
+		return "Node Status (Esc to close)"
+	case overlayLimits:
+		return "Limits (Esc to close)"
+	}
+	return ""
+}
+
+func (m *model) overlayView() string {
+	switch m.overlay {
+	case overlayHelp:
+		return m.helpView()
+	case overlayDirectory:
+		return m.directoryView()
+	case overlayStatus:
+		return m.statusView()
+	case overlayLimits:
+		return m.limitsView()
+	}
+	return ""
+}
+
+func (m *model) helpView() string {
+	var s strings.Builder
+	s.WriteString("Keys:\n")
+	for _, k := range [][2]string{
+		{"Tab, Shift+Tab", "move between panes; completes commands in the input"},
+		{"Ctrl+N, Ctrl+P", "next or previous room, the mentions room first"},
+		{"Up/Down, j/k", "pick a room or peer, or scroll messages, in that pane"},
+		{"PgUp, PgDn", "scroll messages from any pane"},
+		{"Home, End", "oldest or newest message, in the messages pane"},
+		{"x, Delete", "leave the room, in the rooms pane"},
+		{"Esc", "back to the input, or clear it"},
+		{"F1 F2 F3 F4", "help, room directory, node status, limits"},
+		{"Ctrl+C", "quit"},
+	} {
+		s.WriteString(fmt.Sprintf("  %-16s %s\n", k[0], k[1]))
+	}
+	s.WriteString("\nThe rooms pane counts unread messages and, after an @, those that\n" +
+		"mention you: your nickname or a chat.highlight rule. \"" + mentionsLabel + "\"\n" +
+		"collects those from every room. chat.bell rings the terminal bell for them.\n")
+	s.WriteString("\nCommands (start a message with // to send a leading slash):\n")
+	for _, cmd := range m.commands.List() {
+		s.WriteString(fmt.Sprintf("  %-16s %s\n", cmd.Usage(), cmd.Help))
+	}
+	return s.String()
+}
+
+func (m *model) directoryView() string {
+	var s strings.Builder
+	if m.attached != "" {
+		s.WriteString("The room directory runs in the daemon. /join rooms by name.\n")
+		return s.String()
+	}
+	if m.dir == nil {
+		s.WriteString("The room directory is disabled.\n")
+		return s.String()
+	}
+	rooms := m.dir.Rooms()
+	if len(rooms) == 0 {
+		s.WriteString("No rooms announced yet.\n")
+	}
+	for i, r := range rooms {
+		cursor := "  "
+		if i == m.selectedRoom {
+			cursor = "->"
+		}
+		access := "public"
+		if !r.Public {
+			access = "private"
+		}
+		joined := ""
+		if contains(m.rooms, r.Name) {
+			joined = " (joined)"
+		}
+		s.WriteString(fmt.Sprintf("%s %-20s %3d members  %-7s %s%s\n", cursor, node.Sanitize(r.Name), r.Members, access, node.Sanitize(r.Description), joined))
+	}
+	return s.String()
+}
+
+func (m *model) statusView() string {
+	var s strings.Builder
+	if m.attached != "" {
+		st := m.daemon
+		s.WriteString(fmt.Sprintf("Attached to the daemon on %s\n", m.attached))
+		s.WriteString(fmt.Sprintf("Peer ID: %s\n", st.ID))
+		for _, a := range st.Addrs {
+			s.WriteString(fmt.Sprintf("- %s\n", a))
+		}
+		s.WriteString(fmt.Sprintf("Connected peers: %d\n", st.Peers))
+		s.WriteString(fmt.Sprintf("Network: %s\n", st.Network))
+		s.WriteString(fmt.Sprintf("Running for: %s\n", time.Since(st.Started).Round(time.Second)))
+		return s.String()
+	}
+
+	s.WriteString(fmt.Sprintf("Peer ID: %s\n", m.host.ID()))
+	s.WriteString("Dial addresses:\n")
+	for _, a := range node.DialAddrs(m.host) {
+		s.WriteString(fmt.Sprintf("- %s\n", a))
+	}
+	s.WriteString(fmt.Sprintf("Connected peers: %d\n", len(m.host.Network().Peers())))
+	s.WriteString(fmt.Sprintf("Network: %s\n", m.network))
+	s.WriteString(fmt.Sprintf("Reachability: %s\n", m.reach.Describe()))
+	if m.lan != nil {
+		s.WriteString(fmt.Sprintf("LAN peers (mDNS %q): %d\n", m.cfg.MDNS.ServiceTag, len(m.lan.Peers())))
+		for _, pi := range m.lan.Peers() {
+			s.WriteString(fmt.Sprintf("- %s %v\n", pi.ID, pi.Addrs))
+		}
+		for _, line := range m.lanLog {
+			s.WriteString("  " + line + "\n")
+		}
+	} else {
+		s.WriteString("LAN discovery: off\n")
+	}
+	if m.rdv != nil {
+		s.WriteString(fmt.Sprintf("DHT: %s mode, %d peers in routing table\n", m.cfg.DHT.Mode, m.rdv.RoutingTableSize()))
+	} else {
+		s.WriteString("DHT: off\n")
+	}
+	s.WriteString(fmt.Sprintf("Router: %s\n", m.cfg.Router.Describe()))
+	blockedPeers, blockedRanges := m.blocklist.Entries()
+	s.WriteString(fmt.Sprintf("Blocked: %d peer(s), %d range(s)\n", len(blockedPeers), len(blockedRanges)))
+	if !m.cfg.Scoring.Enabled() {
+		s.WriteString("Peer scoring: off\n")
+		return s.String()
+	}
+	s.WriteString(fmt.Sprintf("Peer scoring: %s\n", m.cfg.Scoring.Profile))
+	for _, ps := range m.scores.Scores() {
+		s.WriteString(fmt.Sprintf("- %s %8.2f %-10s %s\n", ps.ID, ps.Score, ps.Status, ps.Reason))
+	}
+	return s.String()
+}
+
+func (m *model) limitsView() string {
+	var s strings.Builder
+	if m.attached != "" {
+		s.WriteString("Limits are the daemon's and not visible from here.\n")
+		return s.String()
+	}
+	l := m.cfg.Limits
+	s.WriteString(fmt.Sprintf("Connections: %d (trim to %d above %d, grace %s)\n",
+		len(m.host.Network().Conns()), l.LowWater, l.HighWater, l.GracePeriod))
+	for _, u := range l.Usage(m.host) {
+		s.WriteString(fmt.Sprintf("%-28s conns %s  streams %s  fd %s  mem %s\n", u.Scope,
+			usedOf(int64(u.Conns), int64(u.ConnsLimit)),
+			usedOf(int64(u.Streams), int64(u.StreamsLimit)),
+			usedOf(int64(u.FD), int64(u.FDLimit)),
+			usedOf(u.Memory, u.MemoryLimit)))
+	}
+	return s.String()
+}
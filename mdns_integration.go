@@ -2,26 +2,21 @@ package main
 
 import (
 	"context"
-	"time"
+	"fmt"
 
-	"github.com/libp2p/go-libp2p"
-	"github.com/libp2p/go-libp2p-core/host"
-	"github.com/libp2p/go-libp2p-core/peer"
-	"github.com/libp2p/go-libp2p-discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 )
 
-func setupMDNS(ctx context.Context, h host.Host, serviceTag string) error {
-	mdnsService, err := mdns.NewMdnsService(ctx, h, time.Minute, serviceTag)
-	if err != nil {
-		return err
+// setupMDNS advertises h on the local network under serviceTag and connects
+// to every peer found with the same tag. Close the returned service to stop.
+func setupMDNS(h host.Host, serviceTag string) (mdns.Service, error) {
+	s := mdns.NewMdnsService(h, serviceTag, &mdnsNotifee{h: h})
+	if err := s.Start(); err != nil {
+		return nil, err
 	}
-
-	disc := discovery.NewRoutingDiscovery(mdnsService)
-	discovery.Advertise(ctx, disc, serviceTag)
-
-	mdnsService.RegisterNotifee(&mdnsNotifee{h: h})
-	return nil
+	return s, nil
 }
 
 // mdnsNotifee implements the Notifee interface for mDNS discovery
@@ -30,6 +25,8 @@ type mdnsNotifee struct {
 }
 
 func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
-	fmt.Printf("Discovered new peer: %s\n", pi.ID.Pretty())
-	n.h.Peerstore().AddAddrs(pi.ID, pi.Addrs, peer.AddressTTL)
-}
\ No newline at end of file
+	fmt.Printf("Discovered new peer: %s\n", pi.ID)
+	if err := n.h.Connect(context.Background(), pi); err != nil {
+		fmt.Printf("Error connecting to peer %s: %s\n", pi.ID, err)
+	}
+}
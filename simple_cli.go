@@ -1,25 +0,0 @@
-package main
-
-import (
-	"flag"
-	"fmt"
-	"os"
-)
-
-func main() {
-	// Define CLI flags
-	messageFlag := flag.String("message", "", "Message to publish")
-	topicFlag := flag.String("topic", "", "Topic to subscribe or publish")
-	flag.Parse()
-
-	// Check if flags are provided
-	if *messageFlag == "" || *topicFlag == "" {
-		fmt.Println("Please provide both --message and --topic flags")
-		os.Exit(1)
-	}
-
-	// Use the provided flags to publish a message to the specified topic
-	fmt.Printf("Publishing message '%s' to topic '%s'\n", *messageFlag, *topicFlag)
-
-	// TODO: Implement the actual message publishing and topic subscription logic
-}
\ No newline at end of file